@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/downloader"
+)
+
+// packageFormat is the format of the -format flag, or the one the extension
+// of path names
+func packageFormat(format, path string) string {
+	if format != "" {
+		return format
+	}
+	switch filepath.Ext(path) {
+	case ".zip":
+		return downloader.PackageZip
+	case ".tar":
+		return downloader.PackageTar
+	}
+	return downloader.PackageDir
+}
+
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dir := flags.String("storage", "", "folder of the cache")
+	prefix := flags.String("prefix", "", "segment url prefix the url was cached with")
+	keysName := flags.String("keys", "path", "key strategy the cache was written with: "+keyStrategyNames())
+	format := flags.String("format", "", "package format: dir, tar or zip, by default named by the extension of path")
+	flags.Parse(args)
+	if *dir == "" || flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: streaming export -storage dir [-prefix prefix] [-keys strategy] [-format format] url path")
+		return 2
+	}
+	keys, err := keyStrategy(*keysName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	url, path := flags.Arg(0), flags.Arg(1)
+	storage := downloader.NewFileStorage(*dir)
+	if err := downloader.Export(url, storage, *prefix, path, packageFormat(*format, path), downloader.WithKeyStrategy(keys)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", url, err)
+		return 1
+	}
+	return 0
+}
+
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := flags.String("storage", "", "folder of the cache")
+	catalogPath := flags.String("catalog", "", "path of the catalog database")
+	prefix := flags.String("prefix", "", "segment url prefix to cache the package with")
+	keysName := flags.String("keys", "path", "key strategy the cache is written with: "+keyStrategyNames())
+	flags.Parse(args)
+	if *dir == "" || flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: streaming import -storage dir [-catalog path] [-prefix prefix] [-keys strategy] path...")
+		return 2
+	}
+	keys, err := keyStrategy(*keysName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	storage := downloader.NewFileStorage(*dir)
+	opts := []downloader.Option{downloader.WithKeyStrategy(keys)}
+	if *catalogPath != "" {
+		catalog, err := downloader.OpenCatalog(*catalogPath, downloader.WithKeyStrategy(keys))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer catalog.Close()
+		opts = append(opts, downloader.WithCatalog(catalog))
+	}
+
+	status := 0
+	ps := pubsub.New(1)
+	for _, path := range flags.Args() {
+		url, err := downloader.Import(path, storage, *prefix, ps, opts...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			status = 1
+			continue
+		}
+		fmt.Println(url)
+	}
+	return status
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/downloader"
+)
+
+func runFsck(args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	dir := flags.String("storage", "", "folder of the cache")
+	catalogPath := flags.String("catalog", "", "path of the catalog database")
+	prefix := flags.String("prefix", "", "segment url prefix of playlists which are not cataloged")
+	keysName := flags.String("keys", "path", "key strategy the cache was written with: "+keyStrategyNames())
+	repair := flags.Bool("repair", false, "repair the problems found")
+	asJSON := flags.Bool("json", false, "print problems as JSON")
+	flags.Parse(args)
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "usage: streaming fsck -storage dir [-catalog path] [-prefix prefix] [-keys strategy] [-repair] [-json]")
+		return 2
+	}
+	keys, err := keyStrategy(*keysName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	storage := downloader.NewFileStorage(*dir)
+	var catalog *downloader.Catalog
+	if *catalogPath != "" {
+		if catalog, err = downloader.OpenCatalog(*catalogPath, downloader.WithKeyStrategy(keys)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer catalog.Close()
+	}
+
+	problems, err := downloader.VerifyCache(catalog, storage, *prefix, downloader.WithKeyStrategy(keys))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *repair && len(problems) > 0 {
+		if err := repairCache(catalog, storage, problems, downloader.WithKeyStrategy(keys)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if problems, err = downloader.VerifyCache(catalog, storage, *prefix, downloader.WithKeyStrategy(keys)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(problems)
+	} else if len(problems) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tOBJECT\tENTRY\tDETAIL")
+		for _, p := range problems {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Kind, p.Object, p.Entry, p.Detail)
+		}
+		w.Flush()
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// repairCache repairs problems with opts, printing the progress events to
+// stderr
+func repairCache(catalog *downloader.Catalog, storage downloader.Storage, problems []downloader.Problem, opts ...downloader.Option) error {
+	ps := pubsub.New(100)
+	ch := ps.Sub(downloader.DownloadStatusChannel, downloader.RemoveStatusChannel)
+	done := make(chan struct{})
+	go func() {
+		for msg := range ch {
+			switch s := msg.(type) {
+			case downloader.DownloadStatus:
+				if s.Status == "repaired" || s.Status == "failed repair" {
+					fmt.Fprintf(os.Stderr, "%s %s %s\n", s.Status, s.URL, s.Error)
+				}
+			case downloader.RemoveStatus:
+				fmt.Fprintf(os.Stderr, "%s %s %s\n", s.Status, s.TempFilename, s.Error)
+			}
+		}
+		close(done)
+	}()
+	err := downloader.Repair(catalog, storage, problems, ps, opts...)
+	ps.Shutdown()
+	<-done
+	return err
+}
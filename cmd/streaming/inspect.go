@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/osiloke/streaming/downloader"
+)
+
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	catalogPath := flags.String("catalog", "", "path of the catalog database")
+	prefix := flags.String("prefix", "", "segment url prefix the urls were cached with")
+	keysName := flags.String("keys", "path", "key strategy the cache was written with: "+keyStrategyNames())
+	asJSON := flags.Bool("json", false, "print entries as JSON")
+	flags.Parse(args)
+	if *catalogPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: streaming inspect -catalog path [-prefix prefix] [-keys strategy] [-json] [url...]")
+		return 2
+	}
+	keys, err := keyStrategy(*keysName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	catalog, err := downloader.OpenCatalog(*catalogPath, downloader.WithKeyStrategy(keys))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer catalog.Close()
+
+	status := 0
+	var entries []*downloader.CatalogEntry
+	if flags.NArg() == 0 {
+		if entries, err = catalog.Entries(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	for _, url := range flags.Args() {
+		entry, err := catalog.Entry(url, *prefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", url, err)
+			status = 1
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+		return status
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TRACK\tKIND\tOBJECTS\tBYTES\tDOWNLOADED\tLAST ACCESS\tACCESSES\tPINNED\tEXPIRES\tURL")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%d\t%t\t%s\t%s\n", e.TrackID, e.Kind, len(e.Objects), e.Size, formatTime(e.Downloaded), formatTime(e.LastAccess), e.AccessCount, e.Pinned, formatTime(e.Expires), e.URL)
+	}
+	w.Flush()
+	return status
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
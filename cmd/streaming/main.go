@@ -0,0 +1,113 @@
+// Command streaming works with HLS playlists and the streaming cache.
+//
+// Usage:
+//
+//	streaming validate [-json] playlist...
+//	streaming probe [-json] segment...
+//	streaming inspect -catalog path [-prefix prefix] [-keys strategy] [-json] [url...]
+//	streaming fsck -storage dir [-catalog path] [-prefix prefix] [-keys strategy] [-repair] [-json]
+//	streaming export -storage dir [-prefix prefix] [-keys strategy] [-format format] url path
+//	streaming import -storage dir [-catalog path] [-prefix prefix] [-keys strategy] path...
+//	streaming remux -storage dir [-prefix prefix] [-keys strategy] [-format format] url path
+//
+// validate checks playlists, given as files or http(s) urls, against RFC 8216
+// and exits with status 1 when one of them has errors.
+//
+// probe checks MPEG-TS segments, given as files or http(s) urls, and lists
+// their streams with codecs and durations and their ID3 timed metadata. It
+// exits with status 1 when one of them is broken.
+//
+// inspect prints the catalog entries of the given source urls, or of every
+// cached playlist and manifest, with their sizes, access statistics and
+// expiry.
+//
+// fsck checks a cache folder for missing, corrupted and orphaned objects and
+// exits with status 1 when it finds any. With -repair the problems are fixed,
+// downloading cataloged entries again where needed, before the cache is
+// checked once more.
+//
+// export writes a cached url with its segments to a folder, tar or zip file
+// which plays offline, -format is dir, tar or zip and defaults to the one the
+// extension of path names. import caches such packages again and prints their
+// source urls.
+//
+// remux joins the cached segments of an HLS url into a single file, -format
+// is ts, mp4 or m4a and defaults to the extension of path.
+//
+// -keys names the key strategy the cache was written with, one of path (the
+// default), url, noquery or notokens.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/osiloke/streaming/downloader"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"validate", "validate [-json] playlist...", runValidate},
+	{"probe", "probe [-json] segment...", runProbe},
+	{"inspect", "inspect -catalog path [-prefix prefix] [-keys strategy] [-json] [url...]", runInspect},
+	{"fsck", "fsck -storage dir [-catalog path] [-prefix prefix] [-keys strategy] [-repair] [-json]", runFsck},
+	{"export", "export -storage dir [-prefix prefix] [-keys strategy] [-format format] url path", runExport},
+	{"import", "import -storage dir [-catalog path] [-prefix prefix] [-keys strategy] path...", runImport},
+	{"remux", "remux -storage dir [-prefix prefix] [-keys strategy] [-format format] url path", runRemux},
+}
+
+// keyStrategies are the key strategies the -keys flag selects
+var keyStrategies = map[string]func() downloader.KeyStrategy{
+	"path":    downloader.LastPathSegmentsKey,
+	"url":     downloader.FullURLKey,
+	"noquery": downloader.WithoutQueryKey,
+	"notokens": func() downloader.KeyStrategy {
+		return downloader.StripTokensKey(downloader.DefaultTokenPatterns())
+	},
+}
+
+func keyStrategyNames() string {
+	names := []string{}
+	for name := range keyStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// keyStrategy gets the key strategy named by the -keys flag
+func keyStrategy(name string) (downloader.KeyStrategy, error) {
+	keys, ok := keyStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown key strategy %q, use one of %s", name, keyStrategyNames())
+	}
+	return keys(), nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: streaming <command> [arguments]")
+	for _, c := range commands {
+		fmt.Fprintln(os.Stderr, "  streaming "+c.usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	usage()
+	os.Exit(2)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/osiloke/streaming/ts"
+)
+
+type probeResult struct {
+	Source string   `json:"source"`
+	Error  string   `json:"error,omitempty"`
+	Info   *ts.Info `json:"info,omitempty"`
+}
+
+func runProbe(args []string) int {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print streams as JSON")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: streaming probe [-json] segment...")
+		return 2
+	}
+	status := 0
+	results := []probeResult{}
+	for _, source := range flags.Args() {
+		result := probeResult{Source: source}
+		data, err := readSource(source)
+		if err == nil {
+			err = ts.Validate(bytes.NewReader(data))
+		}
+		if err == nil {
+			result.Info, err = ts.Probe(bytes.NewReader(data))
+		}
+		if err != nil {
+			result.Error = err.Error()
+			status = 1
+		}
+		results = append(results, result)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return status
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Printf("%s: %s\n", r.Source, r.Error)
+			continue
+		}
+		fmt.Printf("%s: %v\n", r.Source, r.Info.Duration)
+		for _, s := range r.Info.Streams {
+			fmt.Printf("  PID %d: %s %v\n", s.PID, s.Codec, s.Duration)
+		}
+		for _, m := range r.Info.Metadata {
+			for _, f := range m.Frames {
+				fmt.Printf("  PTS %d: %s %s\n", m.PTS, f.ID, f.Text())
+			}
+		}
+	}
+	return status
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/downloader"
+)
+
+func runRemux(args []string) int {
+	flags := flag.NewFlagSet("remux", flag.ExitOnError)
+	dir := flags.String("storage", "", "folder of the cache")
+	prefix := flags.String("prefix", "", "segment url prefix the url was cached with")
+	keysName := flags.String("keys", "path", "key strategy the cache was written with: "+keyStrategyNames())
+	format := flags.String("format", "", "file format: ts, mp4 or m4a, by default named by the extension of path")
+	flags.Parse(args)
+	if *dir == "" || flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: streaming remux -storage dir [-prefix prefix] [-keys strategy] [-format format] url path")
+		return 2
+	}
+	keys, err := keyStrategy(*keysName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	url, path := flags.Arg(0), flags.Arg(1)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	storage := downloader.NewFileStorage(*dir)
+	if err := downloader.RemuxHLSPlaylist(url, storage, *prefix, path, *format, pubsub.New(1), downloader.WithKeyStrategy(keys)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", url, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/osiloke/streaming/hls"
+)
+
+type validateResult struct {
+	Source      string           `json:"source"`
+	Error       string           `json:"error,omitempty"`
+	Diagnostics []hls.Diagnostic `json:"diagnostics"`
+}
+
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print findings as JSON")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: streaming validate [-json] playlist...")
+		return 2
+	}
+	status := 0
+	results := []validateResult{}
+	for _, source := range flags.Args() {
+		result := validateResult{Source: source, Diagnostics: []hls.Diagnostic{}}
+		data, err := readSource(source)
+		if err != nil {
+			result.Error = err.Error()
+			status = 1
+		} else {
+			result.Diagnostics = hls.Validate(data)
+			if hls.HasErrors(result.Diagnostics) {
+				status = 1
+			}
+		}
+		results = append(results, result)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return status
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Printf("%s: %s\n", r.Source, r.Error)
+		}
+		for _, d := range r.Diagnostics {
+			fmt.Printf("%s:%d: %s: %s [%s]\n", r.Source, d.Line, d.Severity, d.Message, d.Rule)
+		}
+	}
+	return status
+}
+
+// readSource reads a playlist from a file or an http(s) url
+func readSource(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	resp, err := http.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New(resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
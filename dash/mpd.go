@@ -0,0 +1,284 @@
+// Package dash reads, rewrites and writes MPEG-DASH media presentation
+// descriptions (ISO/IEC 23009-1). Manifests are kept as an element tree so
+// that elements and attributes this package does not model are written back
+// unchanged.
+package dash
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotMPD is returned when a document is not a media presentation
+// description
+var ErrNotMPD = errors.New("not an MPD manifest")
+
+// ErrUnknownDuration is returned when segments are addressed by number but
+// the duration of their period is not known
+var ErrUnknownDuration = errors.New("period duration is unknown")
+
+// ErrTooManySegments is returned when a representation would address more
+// than MaxSegments segments
+var ErrTooManySegments = errors.New("too many segments")
+
+// MaxSegments bounds the number of segments a representation may address,
+// so a malformed manifest cannot make Segments exhaust memory
+const MaxSegments = 1 << 20
+
+// MPD is a media presentation description
+type MPD struct {
+	*Element
+	// prolog holds the nodes around the root element
+	prolog []Node
+}
+
+// Period is a Period element of an MPD
+type Period struct {
+	*Element
+	MPD *MPD
+	// index of the period in its MPD
+	index int
+}
+
+// AdaptationSet is an AdaptationSet element of a Period
+type AdaptationSet struct {
+	*Element
+	Period *Period
+}
+
+// Representation is a Representation element of an AdaptationSet
+type Representation struct {
+	*Element
+	AdaptationSet *AdaptationSet
+}
+
+// Parse parses a manifest
+func Parse(data []byte) (*MPD, error) {
+	return Decode(bytes.NewReader(data))
+}
+
+// Decode reads a manifest from r
+func Decode(r io.Reader) (*MPD, error) {
+	nodes, err := decodeDocument(r)
+	if err != nil {
+		return nil, err
+	}
+	m := &MPD{}
+	for _, n := range nodes {
+		if el, ok := n.(*Element); ok {
+			if m.Element != nil || localName(el.Name) != "MPD" {
+				return nil, ErrNotMPD
+			}
+			m.Element = el
+		}
+		m.prolog = append(m.prolog, n)
+	}
+	if m.Element == nil {
+		return nil, ErrNotMPD
+	}
+	return m, nil
+}
+
+// Encode writes the manifest
+func (m *MPD) Encode() []byte {
+	buf := new(bytes.Buffer)
+	for _, n := range m.prolog {
+		n.encode(buf)
+	}
+	return buf.Bytes()
+}
+
+// Type is "static" for on demand or "dynamic" for live presentations
+func (m *MPD) Type() string {
+	if t, ok := m.Attr("type"); ok {
+		return t
+	}
+	return "static"
+}
+
+// Duration is the mediaPresentationDuration, zero when it is not set
+func (m *MPD) Duration() time.Duration {
+	d, _ := durationAttr(m.Element, "mediaPresentationDuration")
+	return d
+}
+
+// Periods returns the periods of the presentation
+func (m *MPD) Periods() []*Period {
+	periods := []*Period{}
+	for i, el := range m.ChildElements("Period") {
+		periods = append(periods, &Period{Element: el, MPD: m, index: i})
+	}
+	return periods
+}
+
+// Start is the start of the period in the presentation
+func (p *Period) Start() time.Duration {
+	if start, ok := durationAttr(p.Element, "start"); ok {
+		return start
+	}
+	if p.index == 0 {
+		return 0
+	}
+	previous := p.MPD.Periods()[p.index-1]
+	return previous.Start() + previous.Duration()
+}
+
+// Duration is the duration of the period, from its duration attribute, the
+// start of the next period or the end of the presentation. It is zero when
+// unknown
+func (p *Period) Duration() time.Duration {
+	if d, ok := durationAttr(p.Element, "duration"); ok {
+		return d
+	}
+	periods := p.MPD.Periods()
+	if p.index+1 < len(periods) {
+		if next, ok := durationAttr(periods[p.index+1].Element, "start"); ok {
+			return next - p.Start()
+		}
+		return 0
+	}
+	if total := p.MPD.Duration(); total > 0 {
+		return total - p.Start()
+	}
+	return 0
+}
+
+// AdaptationSets returns the adaptation sets of the period
+func (p *Period) AdaptationSets() []*AdaptationSet {
+	sets := []*AdaptationSet{}
+	for _, el := range p.ChildElements("AdaptationSet") {
+		sets = append(sets, &AdaptationSet{Element: el, Period: p})
+	}
+	return sets
+}
+
+// ContentType is the contentType of the set, or the type of its mimeType
+func (a *AdaptationSet) ContentType() string {
+	if t, ok := a.Attr("contentType"); ok {
+		return t
+	}
+	mimeType, _ := a.Attr("mimeType")
+	if mimeType == "" {
+		for _, r := range a.Representations() {
+			if mimeType, _ = r.Attr("mimeType"); mimeType != "" {
+				break
+			}
+		}
+	}
+	if i := strings.Index(mimeType, "/"); i > 0 {
+		if t := mimeType[:i]; t != "application" {
+			return t
+		}
+		// ttml and other subtitles in application/mp4 or application/ttml+xml
+		return "text"
+	}
+	return ""
+}
+
+// Language is the lang of the set
+func (a *AdaptationSet) Language() string {
+	lang, _ := a.Attr("lang")
+	return lang
+}
+
+// Representations returns the representations of the set
+func (a *AdaptationSet) Representations() []*Representation {
+	reps := []*Representation{}
+	for _, el := range a.ChildElements("Representation") {
+		reps = append(reps, &Representation{Element: el, AdaptationSet: a})
+	}
+	return reps
+}
+
+// ID is the id of the representation
+func (r *Representation) ID() string {
+	id, _ := r.Attr("id")
+	return id
+}
+
+// Bandwidth is the bandwidth of the representation in bits per second
+func (r *Representation) Bandwidth() int64 {
+	bandwidth, _ := strconv.ParseInt(r.inherited("bandwidth"), 10, 64)
+	return bandwidth
+}
+
+// Codecs is the codecs of the representation or its set
+func (r *Representation) Codecs() string {
+	return r.inherited("codecs")
+}
+
+// MimeType is the mimeType of the representation or its set
+func (r *Representation) MimeType() string {
+	return r.inherited("mimeType")
+}
+
+// Width is the width of the representation or its set
+func (r *Representation) Width() int {
+	w, _ := strconv.Atoi(r.inherited("width"))
+	return w
+}
+
+// Height is the height of the representation or its set
+func (r *Representation) Height() int {
+	h, _ := strconv.Atoi(r.inherited("height"))
+	return h
+}
+
+func (r *Representation) inherited(name string) string {
+	if v, ok := r.Attr(name); ok {
+		return v
+	}
+	v, _ := r.AdaptationSet.Attr(name)
+	return v
+}
+
+// levels returns the elements the representation inherits from, outermost
+// first
+func (r *Representation) levels() []*Element {
+	return []*Element{r.AdaptationSet.Period.MPD.Element, r.AdaptationSet.Period.Element, r.AdaptationSet.Element, r.Element}
+}
+
+func localName(name string) string {
+	return name[strings.LastIndex(name, ":")+1:]
+}
+
+var isoDuration = regexp.MustCompile(`^(-)?P(?:(\d+(?:\.\d+)?)Y)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses an xs:duration such as PT1H2M3.5S, years and months
+// are counted as 365 and 30 days
+func ParseDuration(s string) (time.Duration, error) {
+	m := isoDuration.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, errors.New("invalid duration " + s)
+	}
+	units := []time.Duration{365 * 24 * time.Hour, 30 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[i+2], 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(v * float64(unit))
+	}
+	if m[1] != "" {
+		d = -d
+	}
+	return d, nil
+}
+
+func durationAttr(el *Element, name string) (time.Duration, bool) {
+	v, ok := el.Attr(name)
+	if !ok {
+		return 0, false
+	}
+	d, err := ParseDuration(v)
+	return d, err == nil
+}
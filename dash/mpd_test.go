@@ -0,0 +1,130 @@
+package dash
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func onDemandMPD() []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!-- packaged for testing -->
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" xmlns:cenc="urn:mpeg:cenc:2013" type="static" mediaPresentationDuration="PT12S" minBufferTime="PT2S" profiles="urn:mpeg:dash:profile:isoff-on-demand:2011">
+  <BaseURL>media/</BaseURL>
+  <Period id="0">
+    <AdaptationSet mimeType="video/mp4" codecs="avc1.4d401f" segmentAlignment="true">
+      <ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc" cenc:default_KID="10000000-1000-1000-1000-100000000001"/>
+      <SegmentTemplate timescale="1000" duration="4000" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/seg-$Number%03d$.m4s"/>
+      <Representation id="360p" bandwidth="800000" width="640" height="360"/>
+      <Representation id="720p" bandwidth="2400000" width="1280" height="720"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4" lang="en">
+      <Representation id="audio" bandwidth="128000" codecs="mp4a.40.2">
+        <BaseURL>audio.mp4</BaseURL>
+        <SegmentBase indexRange="700-799">
+          <Initialization range="0-699"/>
+        </SegmentBase>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"on demand", onDemandMPD(), false},
+		{"not an mpd", []byte(`<Playlist/>`), true},
+		{"empty", []byte(`<?xml version="1.0"?>`), true},
+		{"unbalanced", []byte(`<MPD><Period></MPD>`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Parse(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && string(m.Encode()) != string(tt.data) {
+				t.Errorf("Encode() = %s, want %s", m.Encode(), tt.data)
+			}
+		})
+	}
+}
+
+func TestMPD(t *testing.T) {
+	m, err := Parse(onDemandMPD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type() != "static" || m.Duration() != 12*time.Second {
+		t.Errorf("Type() = %s, Duration() = %v", m.Type(), m.Duration())
+	}
+	periods := m.Periods()
+	if len(periods) != 1 || periods[0].Duration() != 12*time.Second {
+		t.Fatalf("Periods() = %+v", periods)
+	}
+	sets := periods[0].AdaptationSets()
+	if len(sets) != 2 || sets[0].ContentType() != "video" || sets[1].ContentType() != "audio" || sets[1].Language() != "en" {
+		t.Fatalf("AdaptationSets() = %+v", sets)
+	}
+	reps := sets[0].Representations()
+	if len(reps) != 2 || reps[1].ID() != "720p" || reps[1].Bandwidth() != 2400000 || reps[1].Codecs() != "avc1.4d401f" || reps[1].Height() != 720 {
+		t.Errorf("Representations() = %+v", reps)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"PT12S", 12 * time.Second, false},
+		{"PT1H2M3.5S", time.Hour + 2*time.Minute + 3500*time.Millisecond, false},
+		{"P1DT1S", 24*time.Hour + time.Second, false},
+		{"PT", 0, true},
+		{"12S", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseDuration(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveURIs(t *testing.T) {
+	m, err := Parse(onDemandMPD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ResolveURIs(m, &url.URL{Scheme: "https", Host: "cdn.example.com", Path: "/vod/movie/manifest.mpd"})
+	m.Rewrite(func(uri string) string {
+		return "http://127.0.0.1:7071/cache?r=1&file=" + uri
+	})
+	encoded := string(m.Encode())
+	for _, want := range []string{
+		`<Representation id="360p" bandwidth="800000" width="640" height="360"><BaseURL>http://127.0.0.1:7071/cache?r=1&amp;file=https://cdn.example.com/vod/movie/media/</BaseURL><SegmentTemplate timescale="1000" duration="4000" initialization="http://127.0.0.1:7071/cache?r=1&amp;file=https://cdn.example.com/vod/movie/media/$RepresentationID$/init.mp4" media="http://127.0.0.1:7071/cache?r=1&amp;file=https://cdn.example.com/vod/movie/media/$RepresentationID$/seg-$Number%03d$.m4s"/></Representation>`,
+		`<BaseURL>http://127.0.0.1:7071/cache?r=1&amp;file=https://cdn.example.com/vod/movie/media/audio.mp4</BaseURL>`,
+		`cenc:default_KID="10000000-1000-1000-1000-100000000001"`,
+	} {
+		if !strings.Contains(encoded, want) {
+			t.Errorf("Encode() = %s, want %s", encoded, want)
+		}
+	}
+	if strings.Count(encoded, "<BaseURL>") != 3 || strings.Count(encoded, "<SegmentTemplate") != 2 {
+		t.Errorf("Encode() kept inherited elements = %s", encoded)
+	}
+}
@@ -0,0 +1,228 @@
+package dash
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Node is a node of a manifest document
+type Node interface {
+	encode(buf *bytes.Buffer)
+}
+
+// Attr is an attribute of an Element, prefixed names are kept as written
+type Attr struct {
+	Name  string
+	Value string
+}
+
+// Element is an element of a manifest document. Names keep the namespace
+// prefix they were written with so a manifest is encoded as it was parsed
+type Element struct {
+	Name     string
+	Attrs    []Attr
+	Children []Node
+}
+
+// Text is character data
+type Text string
+
+// Raw is a comment, processing instruction or directive, written verbatim
+type Raw string
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func (t Text) encode(buf *bytes.Buffer) {
+	textEscaper.WriteString(buf, string(t))
+}
+
+func (r Raw) encode(buf *bytes.Buffer) {
+	buf.WriteString(string(r))
+}
+
+func (e *Element) encode(buf *bytes.Buffer) {
+	buf.WriteString("<" + e.Name)
+	for _, a := range e.Attrs {
+		buf.WriteString(" " + a.Name + `="`)
+		attrEscaper.WriteString(buf, a.Value)
+		buf.WriteString(`"`)
+	}
+	if len(e.Children) == 0 {
+		buf.WriteString("/>")
+		return
+	}
+	buf.WriteString(">")
+	for _, c := range e.Children {
+		c.encode(buf)
+	}
+	buf.WriteString("</" + e.Name + ">")
+}
+
+// Attr returns the value of the attribute name
+func (e *Element) Attr(name string) (string, bool) {
+	for _, a := range e.Attrs {
+		if a.Name == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// SetAttr sets the attribute name to value, adding it when missing
+func (e *Element) SetAttr(name, value string) {
+	for i, a := range e.Attrs {
+		if a.Name == name {
+			e.Attrs[i].Value = value
+			return
+		}
+	}
+	e.Attrs = append(e.Attrs, Attr{name, value})
+}
+
+// Child returns the first child element called name
+func (e *Element) Child(name string) *Element {
+	for _, c := range e.Children {
+		if el, ok := c.(*Element); ok && el.Name == name {
+			return el
+		}
+	}
+	return nil
+}
+
+// ChildElements returns the child elements called name
+func (e *Element) ChildElements(name string) []*Element {
+	found := []*Element{}
+	for _, c := range e.Children {
+		if el, ok := c.(*Element); ok && el.Name == name {
+			found = append(found, el)
+		}
+	}
+	return found
+}
+
+// RemoveChild removes the child element el
+func (e *Element) RemoveChild(el *Element) {
+	for i, c := range e.Children {
+		if c == Node(el) {
+			e.Children = append(e.Children[:i:i], e.Children[i+1:]...)
+			return
+		}
+	}
+}
+
+// RemoveChildren removes every child element called one of names
+func (e *Element) RemoveChildren(names ...string) {
+	kept := e.Children[:0:0]
+	for _, c := range e.Children {
+		if el, ok := c.(*Element); ok && containsName(names, el.Name) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	e.Children = kept
+}
+
+// InsertChild adds el before the first child element called one of before,
+// or after every child when there is none
+func (e *Element) InsertChild(el *Element, before ...string) {
+	for i, c := range e.Children {
+		if child, ok := c.(*Element); ok && containsName(before, child.Name) {
+			e.Children = append(e.Children[:i:i], append([]Node{el}, e.Children[i:]...)...)
+			return
+		}
+	}
+	e.Children = append(e.Children, el)
+}
+
+// Text returns the character data of the element
+func (e *Element) Text() string {
+	var sb strings.Builder
+	for _, c := range e.Children {
+		if t, ok := c.(Text); ok {
+			sb.WriteString(string(t))
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// SetText replaces the content of the element with text
+func (e *Element) SetText(text string) {
+	e.Children = []Node{Text(text)}
+}
+
+// Clone returns a deep copy of the element
+func (e *Element) Clone() *Element {
+	clone := &Element{Name: e.Name, Attrs: append([]Attr(nil), e.Attrs...)}
+	for _, c := range e.Children {
+		if el, ok := c.(*Element); ok {
+			c = el.Clone()
+		}
+		clone.Children = append(clone.Children, c)
+	}
+	return clone
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// decodeDocument reads the nodes of a document, namespace prefixes are not
+// resolved so they are written back unchanged
+func decodeDocument(r io.Reader) ([]Node, error) {
+	d := xml.NewDecoder(r)
+	root := &Element{}
+	stack := []*Element{root}
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			el := &Element{Name: qualifiedName(t.Name)}
+			for _, a := range t.Attr {
+				el.Attrs = append(el.Attrs, Attr{qualifiedName(a.Name), a.Value})
+			}
+			parent.Children = append(parent.Children, el)
+			stack = append(stack, el)
+		case xml.EndElement:
+			if len(stack) == 1 || parent.Name != qualifiedName(t.Name) {
+				return nil, &xml.SyntaxError{Msg: "unexpected end element </" + qualifiedName(t.Name) + ">"}
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.Children = append(parent.Children, Text(t))
+		case xml.Comment:
+			parent.Children = append(parent.Children, Raw("<!--"+string(t)+"-->"))
+		case xml.ProcInst:
+			parent.Children = append(parent.Children, Raw("<?"+t.Target+" "+string(t.Inst)+"?>"))
+		case xml.Directive:
+			parent.Children = append(parent.Children, Raw("<!"+string(t)+">"))
+		}
+	}
+	if len(stack) != 1 {
+		return nil, &xml.SyntaxError{Msg: "unexpected EOF"}
+	}
+	return root.Children, nil
+}
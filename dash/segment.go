@@ -0,0 +1,345 @@
+package dash
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// segmentInfoNames are the elements describing how segments are addressed
+var segmentInfoNames = []string{"SegmentTemplate", "SegmentList", "SegmentBase"}
+
+// ByteRange is an inclusive range of bytes, written first-last
+type ByteRange struct {
+	First int64
+	Last  int64
+}
+
+// ParseByteRange parses a range such as 0-719
+func ParseByteRange(s string) (*ByteRange, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid byte range " + s)
+	}
+	first, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	last, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if last < first {
+		return nil, errors.New("invalid byte range " + s)
+	}
+	return &ByteRange{first, last}, nil
+}
+
+// Length is the number of bytes in the range
+func (b *ByteRange) Length() int64 {
+	return b.Last - b.First + 1
+}
+
+func (b *ByteRange) String() string {
+	return fmt.Sprintf("%d-%d", b.First, b.Last)
+}
+
+// Segment is a resource, or a range of one, used by a representation
+type Segment struct {
+	URI       string
+	ByteRange *ByteRange
+	// Init is set for the initialization segment
+	Init bool
+	// Index is set for the segment index
+	Index bool
+	// Number, Time and Duration of a media segment, in timescale units
+	Number   uint64
+	Time     uint64
+	Duration uint64
+}
+
+// BaseURL resolves the BaseURL elements from the MPD down to the
+// representation, it is empty when there are none
+func (r *Representation) BaseURL() string {
+	base := ""
+	for _, level := range r.levels() {
+		if b := level.Child("BaseURL"); b != nil {
+			base = resolveReference(base, b.Text())
+		}
+	}
+	return base
+}
+
+// SegmentInfo returns the SegmentTemplate, SegmentList or SegmentBase which
+// applies to the representation, with the attributes and elements it
+// inherits from its period and adaptation set merged in. It is nil when the
+// representation is a single segment at its BaseURL
+func (r *Representation) SegmentInfo() *Element {
+	levels := r.levels()
+	name := ""
+	for i := len(levels) - 1; i >= 0 && name == ""; i-- {
+		for _, n := range segmentInfoNames {
+			if levels[i].Child(n) != nil {
+				name = n
+				break
+			}
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	merged := &Element{Name: name}
+	for _, level := range levels {
+		el := level.Child(name)
+		if el == nil {
+			continue
+		}
+		for _, a := range el.Attrs {
+			merged.SetAttr(a.Name, a.Value)
+		}
+		children := []*Element{}
+		names := []string{}
+		for _, c := range el.Children {
+			if child, ok := c.(*Element); ok {
+				children = append(children, child)
+				names = append(names, child.Name)
+			}
+		}
+		// inner elements replace those inherited with the same name
+		merged.RemoveChildren(names...)
+		for _, child := range children {
+			merged.Children = append(merged.Children, child.Clone())
+		}
+	}
+	return merged
+}
+
+// Segments lists the segments of the representation, the initialization
+// segment and segment index come first. Uris are resolved against BaseURL
+func (r *Representation) Segments() ([]*Segment, error) {
+	base := r.BaseURL()
+	info := r.SegmentInfo()
+	if info == nil {
+		return []*Segment{{URI: base}}, nil
+	}
+	segments := []*Segment{}
+	for _, name := range []string{"Initialization", "RepresentationIndex"} {
+		el := info.Child(name)
+		if el == nil {
+			continue
+		}
+		s, err := rangedSegment(base, el, "sourceURL", "range")
+		if err != nil {
+			return nil, err
+		}
+		s.Init, s.Index = name == "Initialization", name == "RepresentationIndex"
+		segments = append(segments, s)
+	}
+	switch info.Name {
+	case "SegmentBase":
+		if indexRange, ok := info.Attr("indexRange"); ok && info.Child("RepresentationIndex") == nil {
+			br, err := ParseByteRange(indexRange)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, &Segment{URI: base, ByteRange: br, Index: true})
+		}
+		return append(segments, &Segment{URI: base}), nil
+	case "SegmentList":
+		return r.listSegments(info, base, segments)
+	}
+	return r.templateSegments(info, base, segments)
+}
+
+func rangedSegment(base string, el *Element, uriAttr, rangeAttr string) (*Segment, error) {
+	s := &Segment{URI: base}
+	if uri, ok := el.Attr(uriAttr); ok {
+		s.URI = resolveReference(base, uri)
+	}
+	if v, ok := el.Attr(rangeAttr); ok {
+		br, err := ParseByteRange(v)
+		if err != nil {
+			return nil, err
+		}
+		s.ByteRange = br
+	}
+	return s, nil
+}
+
+func (r *Representation) listSegments(info *Element, base string, segments []*Segment) ([]*Segment, error) {
+	urls := info.ChildElements("SegmentURL")
+	entries, err := r.timeline(info, uint64(len(urls)))
+	if err != nil {
+		return nil, err
+	}
+	for i, el := range urls {
+		s, err := rangedSegment(base, el, "media", "mediaRange")
+		if err != nil {
+			return nil, err
+		}
+		if i < len(entries) {
+			s.Number, s.Time, s.Duration = entries[i].number, entries[i].time, entries[i].duration
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+func (r *Representation) templateSegments(info *Element, base string, segments []*Segment) ([]*Segment, error) {
+	if init, ok := info.Attr("initialization"); ok {
+		uri := expandTemplate(init, r.ID(), r.Bandwidth(), 0, 0)
+		segments = append(segments, &Segment{URI: resolveReference(base, uri), Init: true})
+	}
+	media, ok := info.Attr("media")
+	if !ok {
+		return segments, nil
+	}
+	entries, err := r.timeline(info, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		uri := expandTemplate(media, r.ID(), r.Bandwidth(), e.number, e.time)
+		segments = append(segments, &Segment{URI: resolveReference(base, uri), Number: e.number, Time: e.time, Duration: e.duration})
+	}
+	return segments, nil
+}
+
+type timelineEntry struct {
+	number   uint64
+	time     uint64
+	duration uint64
+}
+
+// timeline numbers and times the media segments of info, from its
+// SegmentTimeline or from its duration. count is the number of segments when
+// they are listed
+func (r *Representation) timeline(info *Element, count uint64) ([]timelineEntry, error) {
+	timescale := uintAttr(info, "timescale", 1)
+	number := uintAttr(info, "startNumber", 1)
+	offset := uintAttr(info, "presentationTimeOffset", 0)
+	periodDuration := r.AdaptationSet.Period.Duration()
+	var end uint64
+	if periodDuration > 0 {
+		end = offset + uint64(math.Ceil(periodDuration.Seconds()*float64(timescale)))
+	}
+	entries := []timelineEntry{}
+	if tl := info.Child("SegmentTimeline"); tl != nil {
+		var t uint64
+		ss := tl.ChildElements("S")
+		for i, s := range ss {
+			t = uintAttr(s, "t", t)
+			d := uintAttr(s, "d", 0)
+			if d == 0 {
+				return nil, errors.New("segment timeline entry without duration")
+			}
+			repeat, _ := strconv.ParseInt(attrOr(s, "r", "0"), 10, 64)
+			if repeat < 0 {
+				limit := end
+				if i+1 < len(ss) {
+					limit = uintAttr(ss[i+1], "t", limit)
+				}
+				if limit <= t {
+					return nil, ErrUnknownDuration
+				}
+				repeat = int64((limit-t+d-1)/d) - 1
+			}
+			if repeat >= MaxSegments-int64(len(entries)) {
+				return nil, ErrTooManySegments
+			}
+			for j := int64(0); j <= repeat; j++ {
+				entries = append(entries, timelineEntry{number, t, d})
+				number++
+				t += d
+			}
+		}
+		return entries, nil
+	}
+	duration := uintAttr(info, "duration", 0)
+	if count == 0 {
+		if last, ok := info.Attr("endNumber"); ok {
+			n, err := strconv.ParseUint(last, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if n < number {
+				return nil, errors.New("endNumber " + last + " before startNumber")
+			}
+			count = n - number + 1
+		} else if duration > 0 && end > 0 {
+			count = (end - offset + duration - 1) / duration
+		} else {
+			return nil, ErrUnknownDuration
+		}
+	}
+	if count > MaxSegments {
+		return nil, ErrTooManySegments
+	}
+	for i := uint64(0); i < count; i++ {
+		entries = append(entries, timelineEntry{number + i, offset + i*duration, duration})
+	}
+	return entries, nil
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)?(%0\d+d)?\$`)
+
+// expandTemplate substitutes the identifiers of a SegmentTemplate url
+func expandTemplate(template, id string, bandwidth int64, number, time uint64) string {
+	return templateIdentifier.ReplaceAllStringFunc(template, func(m string) string {
+		sub := templateIdentifier.FindStringSubmatch(m)
+		format := sub[2]
+		if format == "" {
+			format = "%d"
+		}
+		switch sub[1] {
+		case "RepresentationID":
+			return id
+		case "Number":
+			return fmt.Sprintf(format, number)
+		case "Bandwidth":
+			return fmt.Sprintf(format, bandwidth)
+		case "Time":
+			return fmt.Sprintf(format, time)
+		}
+		return "$"
+	})
+}
+
+// resolveReference resolves ref against base, either may be relative
+func resolveReference(base, ref string) string {
+	if base == "" {
+		return ref
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	if !b.IsAbs() && !r.IsAbs() && !strings.HasPrefix(ref, "/") {
+		// keep relative bases relative
+		return base[:strings.LastIndex(base, "/")+1] + ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+func attrOr(el *Element, name, fallback string) string {
+	if v, ok := el.Attr(name); ok {
+		return v
+	}
+	return fallback
+}
+
+func uintAttr(el *Element, name string, fallback uint64) uint64 {
+	v, err := strconv.ParseUint(attrOr(el, name, ""), 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
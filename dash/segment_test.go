@@ -0,0 +1,128 @@
+package dash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func representation(t *testing.T, mpd string) *Representation {
+	m, err := Parse([]byte(mpd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m.Periods()[0].AdaptationSets()[0].Representations()[0]
+}
+
+func TestRepresentation_Segments(t *testing.T) {
+	tests := []struct {
+		name    string
+		mpd     string
+		want    []*Segment
+		wantErr bool
+	}{
+		{
+			"template number",
+			`<MPD mediaPresentationDuration="PT10S"><Period><AdaptationSet><SegmentTemplate timescale="10" duration="40" startNumber="5" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number%03d$-$Bandwidth$.m4s"/><Representation id="v1" bandwidth="100"><BaseURL>https://a.com/dash/</BaseURL></Representation></AdaptationSet></Period></MPD>`,
+			[]*Segment{
+				{URI: "https://a.com/dash/v1/init.mp4", Init: true},
+				{URI: "https://a.com/dash/v1/005-100.m4s", Number: 5, Time: 0, Duration: 40},
+				{URI: "https://a.com/dash/v1/006-100.m4s", Number: 6, Time: 40, Duration: 40},
+				{URI: "https://a.com/dash/v1/007-100.m4s", Number: 7, Time: 80, Duration: 40},
+			},
+			false,
+		},
+		{
+			"template timeline",
+			`<MPD><Period duration="PT7S"><AdaptationSet><Representation id="a"><SegmentTemplate timescale="1" presentationTimeOffset="10" media="t-$Time$.m4s"><SegmentTimeline><S t="10" d="2" r="1"/><S d="1" r="-1"/></SegmentTimeline></SegmentTemplate></Representation></AdaptationSet></Period></MPD>`,
+			[]*Segment{
+				{URI: "t-10.m4s", Number: 1, Time: 10, Duration: 2},
+				{URI: "t-12.m4s", Number: 2, Time: 12, Duration: 2},
+				{URI: "t-14.m4s", Number: 3, Time: 14, Duration: 1},
+				{URI: "t-15.m4s", Number: 4, Time: 15, Duration: 1},
+				{URI: "t-16.m4s", Number: 5, Time: 16, Duration: 1},
+			},
+			false,
+		},
+		{
+			"list",
+			`<MPD><Period><AdaptationSet><Representation id="a"><BaseURL>https://a.com/a.mp4</BaseURL><SegmentList duration="2"><Initialization range="0-99"/><SegmentURL mediaRange="100-199"/><SegmentURL media="b.mp4"/></SegmentList></Representation></AdaptationSet></Period></MPD>`,
+			[]*Segment{
+				{URI: "https://a.com/a.mp4", ByteRange: &ByteRange{0, 99}, Init: true},
+				{URI: "https://a.com/a.mp4", ByteRange: &ByteRange{100, 199}, Number: 1, Duration: 2},
+				{URI: "https://a.com/b.mp4", Number: 2, Time: 2, Duration: 2},
+			},
+			false,
+		},
+		{
+			"base",
+			`<MPD><Period><AdaptationSet><Representation id="a"><BaseURL>a.mp4</BaseURL><SegmentBase indexRange="100-199"><Initialization range="0-99"/></SegmentBase></Representation></AdaptationSet></Period></MPD>`,
+			[]*Segment{
+				{URI: "a.mp4", ByteRange: &ByteRange{0, 99}, Init: true},
+				{URI: "a.mp4", ByteRange: &ByteRange{100, 199}, Index: true},
+				{URI: "a.mp4"},
+			},
+			false,
+		},
+		{
+			"single segment",
+			`<MPD><Period><AdaptationSet><Representation id="a"><BaseURL>sub.vtt</BaseURL></Representation></AdaptationSet></Period></MPD>`,
+			[]*Segment{{URI: "sub.vtt"}},
+			false,
+		},
+		{
+			"end number",
+			`<MPD><Period><AdaptationSet><Representation id="a"><SegmentTemplate duration="2" startNumber="3" endNumber="4" media="$Number$.m4s"/></Representation></AdaptationSet></Period></MPD>`,
+			[]*Segment{
+				{URI: "3.m4s", Number: 3, Time: 0, Duration: 2},
+				{URI: "4.m4s", Number: 4, Time: 2, Duration: 2},
+			},
+			false,
+		},
+		{
+			"end number before start number",
+			`<MPD><Period><AdaptationSet><Representation id="a"><SegmentTemplate duration="2" startNumber="5" endNumber="3" media="$Number$.m4s"/></Representation></AdaptationSet></Period></MPD>`,
+			nil,
+			true,
+		},
+		{
+			"too many segments",
+			`<MPD mediaPresentationDuration="P1000D"><Period><AdaptationSet><Representation id="a"><SegmentTemplate duration="1" media="$Number$.m4s"/></Representation></AdaptationSet></Period></MPD>`,
+			nil,
+			true,
+		},
+		{
+			"too many timeline segments",
+			`<MPD><Period><AdaptationSet><Representation id="a"><SegmentTemplate media="$Time$.m4s"><SegmentTimeline><S d="1" r="4294967295"/></SegmentTimeline></SegmentTemplate></Representation></AdaptationSet></Period></MPD>`,
+			nil,
+			true,
+		},
+		{
+			"unknown duration",
+			`<MPD type="dynamic"><Period><AdaptationSet><Representation id="a"><SegmentTemplate duration="2" media="$Number$.m4s"/></Representation></AdaptationSet></Period></MPD>`,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := representation(t, tt.mpd).Segments()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Segments() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				for _, s := range got {
+					t.Logf("%+v", s)
+				}
+				t.Errorf("Segments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_expandTemplate(t *testing.T) {
+	got := expandTemplate("$RepresentationID$/$Number%05d$_$Time$_$$.m4s", "v1", 1000, 42, 9000)
+	if want := "v1/00042_9000_$.m4s"; got != want {
+		t.Errorf("expandTemplate() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package dash
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// uriAttrs lists the attributes holding uris for each element
+var uriAttrs = map[string][]string{
+	"SegmentTemplate":     {"media", "initialization", "index", "bitstreamSwitching"},
+	"Initialization":      {"sourceURL"},
+	"RepresentationIndex": {"sourceURL"},
+	"BitstreamSwitching":  {"sourceURL"},
+	"SegmentURL":          {"media", "index"},
+}
+
+// Rewrite replaces every uri of the manifest with fn(uri), SegmentTemplate
+// uris are passed with their identifiers
+func (m *MPD) Rewrite(fn func(uri string) string) {
+	rewriteElement(m.Element, fn)
+}
+
+func rewriteElement(el *Element, fn func(uri string) string) {
+	name := localName(el.Name)
+	if name == "BaseURL" {
+		if uri := el.Text(); uri != "" {
+			el.SetText(fn(uri))
+		}
+		return
+	}
+	for _, attr := range uriAttrs[name] {
+		if uri, ok := el.Attr(attr); ok && uri != "" {
+			el.SetAttr(attr, fn(uri))
+		}
+	}
+	for _, c := range el.Children {
+		if child, ok := c.(*Element); ok {
+			rewriteElement(child, fn)
+		}
+	}
+}
+
+// ResolveURIs makes every uri of m absolute using base, the url the manifest
+// was retrieved from. Each representation is given its own BaseURL and
+// segment information so its uris no longer depend on the elements enclosing
+// it, from which BaseURL and segment information are removed
+func ResolveURIs(m *MPD, base *url.URL) {
+	periods := m.Periods()
+	for _, p := range periods {
+		for _, a := range p.AdaptationSets() {
+			for _, r := range a.Representations() {
+				repBase := resolveReference(base.String(), r.BaseURL())
+				info := r.SegmentInfo()
+				r.RemoveChildren("BaseURL", "SegmentBase", "SegmentList", "SegmentTemplate")
+				baseURL := &Element{Name: "BaseURL"}
+				baseURL.SetText(repBase)
+				r.InsertChild(baseURL, "ExtendedBandwidth", "SubRepresentation")
+				if info != nil {
+					resolveSegmentInfo(info, repBase)
+					r.InsertChild(info)
+				}
+			}
+		}
+	}
+	for _, p := range periods {
+		for _, a := range p.AdaptationSets() {
+			a.RemoveChildren("BaseURL", "SegmentBase", "SegmentList", "SegmentTemplate")
+		}
+		p.RemoveChildren("BaseURL", "SegmentBase", "SegmentList", "SegmentTemplate")
+	}
+	m.RemoveChildren("BaseURL")
+}
+
+func resolveSegmentInfo(el *Element, base string) {
+	name := localName(el.Name)
+	for _, attr := range uriAttrs[name] {
+		if uri, ok := el.Attr(attr); ok && uri != "" {
+			if name == "SegmentTemplate" {
+				el.SetAttr(attr, resolveTemplate(base, uri))
+			} else {
+				el.SetAttr(attr, resolveReference(base, uri))
+			}
+		}
+	}
+	for _, c := range el.Children {
+		if child, ok := c.(*Element); ok {
+			resolveSegmentInfo(child, base)
+		}
+	}
+}
+
+// resolveTemplate resolves a SegmentTemplate url against base, identifiers
+// are kept out of url parsing
+func resolveTemplate(base, template string) string {
+	identifiers := templateIdentifier.FindAllString(template, -1)
+	i := 0
+	masked := templateIdentifier.ReplaceAllStringFunc(template, func(string) string {
+		i++
+		return fmt.Sprintf("dashtemplate%didentifier", i-1)
+	})
+	resolved := resolveReference(base, masked)
+	for i, identifier := range identifiers {
+		resolved = strings.Replace(resolved, fmt.Sprintf("dashtemplate%didentifier", i), identifier, 1)
+	}
+	return resolved
+}
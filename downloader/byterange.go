@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// GetByteRanges get the byte ranges used of every resource which a media
+// playlist only references through EXT-X-BYTERANGE, a ranged EXT-X-MAP or
+// ranged EXT-X-PART tags.
+// Ranges are keyed by url, with segmentURLPrefix stripped, and always carry
+// an offset. Overlapping and adjacent ranges are merged
+func GetByteRanges(hlsRaw []byte, segmentURLPrefix string) map[string][]*hls.ByteRange {
+	found := map[string][]*hls.ByteRange{}
+	playlist, ok := parsePlaylist(hlsRaw).(*hls.MediaPlaylist)
+	if !ok {
+		return found
+	}
+	playlist.Rewrite(func(uri string) string {
+		return strings.TrimPrefix(uri, segmentURLPrefix)
+	})
+	playlist.ResolveByteRanges()
+	whole := map[string]bool{}
+	add := func(uri string, br *hls.ByteRange) {
+		if br == nil {
+			whole[uri] = true
+			return
+		}
+		found[uri] = append(found[uri], br)
+	}
+	for _, s := range playlist.Segments {
+		if s.Map != nil {
+			add(s.Map.URI, s.Map.ByteRange)
+		}
+		for _, part := range s.Parts {
+			add(part.URI, part.ByteRange)
+		}
+		add(s.URI, s.ByteRange)
+	}
+	for _, part := range playlist.Parts {
+		add(part.URI, part.ByteRange)
+	}
+	for uri, ranges := range found {
+		if whole[uri] {
+			delete(found, uri)
+			continue
+		}
+		found[uri] = mergeByteRanges(ranges)
+	}
+	return found
+}
+
+func mergeByteRanges(ranges []*hls.ByteRange) []*hls.ByteRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Offset < ranges[j].Offset
+	})
+	merged := []*hls.ByteRange{}
+	for _, br := range ranges {
+		if n := len(merged); n > 0 {
+			last := merged[n-1]
+			if br.Offset <= last.Offset+last.Length {
+				if end := br.Offset + br.Length; end > last.Offset+last.Length {
+					last.Length = end - last.Offset
+				}
+				continue
+			}
+		}
+		merged = append(merged, &hls.ByteRange{Length: br.Length, Offset: br.Offset, HasOffset: true})
+	}
+	return merged
+}
+
+// DownloadByteRanges fetches the given ranges of each resource with HTTP
+// Range requests and stores them at their offsets in a single file per
+// resource
+func DownloadByteRanges(resources map[string][]*hls.ByteRange, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	return downloadRangedResources(resources, storage, segmentURLPrefix, ps, newOptions(opts))
+}
+
+func downloadRangedResources(resources map[string][]*hls.ByteRange, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, o *options) error {
+	folder, cleanup, err := stagingFolder(storage)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	urls := make([]string, 0, len(resources))
+	for url := range resources {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		if err := o.ctx.Err(); err != nil {
+			return err
+		}
+		ranges := resources[url]
+		sourceURL := mustParseURL(url)
+		idf := idAndFile(sourceURL)
+		filename := o.key(segmentURLPrefix, sourceURL)
+		dst := objectPath(storage, filename)
+		last := ranges[len(ranges)-1]
+		o.use(filename)
+		if info, err := storage.Stat(filename); err == nil && info.Size >= last.Offset+last.Length {
+			continue
+		}
+		header, err := downloadByteRanges(o.ctx, url, stagedPath(folder, filename), ranges)
+		if err == nil {
+			err = commitStaged(storage, folder, filename)
+		}
+		if err != nil {
+			os.Remove(stagedPath(folder, filename))
+			ps.Pub(DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: "0", Status: "error downloading segment", Error: err.Error()}, DownloadStatusChannel)
+			return err
+		}
+		ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: "1", Status: "downloaded segment", Error: ""}
+		completeSegmentDownload(&ds, header, o)
+		ps.Pub(ds, DownloadStatusChannel)
+	}
+	log.Debug.Printf("Downloaded %v ranged resources\n", len(urls))
+	return nil
+}
+
+// downloadByteRanges writes ranges of url into dst and returns the response
+// header of the last request, the requests are canceled with ctx
+func downloadByteRanges(ctx context.Context, url, dst string, ranges []*hls.ByteRange) (http.Header, error) {
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var header http.Header
+	for _, br := range ranges {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Offset, br.Offset+br.Length-1))
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		header = resp.Header
+		err = writeRangeResponse(f, resp, br)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			// the origin ignored the range and sent the whole resource
+			break
+		}
+	}
+	return header, nil
+}
+
+func writeRangeResponse(f *os.File, resp *http.Response, br *hls.ByteRange) error {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if _, err := f.Seek(br.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		n, err := io.Copy(f, io.LimitReader(resp.Body, br.Length))
+		if err == nil && n != br.Length {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	case http.StatusOK:
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(f, resp.Body)
+		return err
+	}
+	return errors.New("unable to retrieve byte range")
+}
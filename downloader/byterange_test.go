@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+)
+
+func TestGetByteRanges(t *testing.T) {
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	hlsRaw := []byte(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="https://cdn.example.com/main.mp4",BYTERANGE="720@0"
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000@720
+` + prefix + `https://cdn.example.com/main.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000
+https://cdn.example.com/main.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:500@5000
+https://cdn.example.com/main.mp4
+#EXTINF:4,
+https://cdn.example.com/whole.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:10@0
+https://cdn.example.com/whole.mp4
+`)
+	want := map[string][]*hls.ByteRange{
+		"https://cdn.example.com/main.mp4": {
+			{Length: 2720, Offset: 0, HasOffset: true},
+			{Length: 500, Offset: 5000, HasOffset: true},
+		},
+	}
+	if got := GetByteRanges(hlsRaw, prefix); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetByteRanges() = %v, want %v", got, want)
+	}
+}
+
+func TestDownloadHLSPlaylistByteRanges(t *testing.T) {
+	resource := strings.Repeat("i", 720) + strings.Repeat("a", 1000) + strings.Repeat("b", 1000) + strings.Repeat("x", 1000)
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8": `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:4
+#EXT-X-MAP:URI="main.mp4",BYTERANGE="720@0"
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000@720
+main.mp4
+#EXTINF:4,
+#EXT-X-BYTERANGE:1000
+main.mp4
+#EXT-X-ENDLIST
+`,
+		"/hls/track_trd.mp4/main.mp4": resource,
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+
+	if err := DownloadHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	files, _ := ioutil.ReadDir(folder)
+	if len(files) != 2 {
+		t.Fatalf("cached %d files, want playlist and one resource", len(files))
+	}
+	cached, err := ioutil.ReadFile(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/hls/track_trd.mp4/main.mp4"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := resource[:2720]; string(cached) != want {
+		t.Errorf("cached resource has %d bytes, want the first %d", len(cached), len(want))
+	}
+
+	proxy := NewProxy(NewFileStorage(folder), prefix)
+	req := httptest.NewRequest("GET", prefix+origin.URL+"/hls/track_trd.mp4/main.mp4", nil)
+	req.Header.Set("Range", "bytes=720-1719")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != 206 || w.Body.String() != strings.Repeat("a", 1000) {
+		t.Errorf("proxy range response = %d %d bytes", w.Code, w.Body.Len())
+	}
+	if got := w.Header().Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("proxy content type = %s", got)
+	}
+	req = httptest.NewRequest("GET", prefix+url, nil)
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `#EXT-X-MAP:URI="`+prefix+origin.URL) {
+		t.Errorf("proxy playlist response = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDownloadByteRangesCanceled(t *testing.T) {
+	origin := testOrigin(map[string]string{"/hls/track_trd.mp4/main.mp4": strings.Repeat("a", 1000)})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ranges := []*hls.ByteRange{{Length: 500, Offset: 0, HasOffset: true}}
+	if _, err := downloadByteRanges(ctx, origin.URL+"/hls/track_trd.mp4/main.mp4", filepath.Join(folder, "main.mp4"), ranges); err == nil {
+		t.Fatal("downloadByteRanges() with a canceled context succeeded")
+	}
+}
@@ -0,0 +1,618 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/minio/sha256-simd"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	entriesBucket = []byte("entries")
+	tracksBucket  = []byte("tracks")
+	objectsBucket = []byte("objects")
+)
+
+// Entry kinds of a Catalog
+const (
+	EntryHLS  = "hls"
+	EntryDASH = "dash"
+)
+
+// CatalogObject is a stored object belonging to a catalog entry. ETag and
+// LastModified are the validators the origin sent with it
+type CatalogObject struct {
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Downloaded   time.Time `json:"downloaded"`
+	LastAccess   time.Time `json:"lastAccess"`
+	AccessCount  int64     `json:"accessCount"`
+}
+
+// CatalogEntry is a cached playlist or manifest. Objects lists the playlist
+// first followed by every object downloaded for it. ETag and LastModified are
+// the validators the origin sent with the playlist, the access statistics
+// count how often the playlist was served or played. Expires is when the
+// entitlement to the source url ends, zero when it does not
+type CatalogEntry struct {
+	Name         string          `json:"name"`
+	URL          string          `json:"url"`
+	Prefix       string          `json:"prefix"`
+	TrackID      string          `json:"trackId"`
+	Kind         string          `json:"kind"`
+	Objects      []CatalogObject `json:"objects"`
+	Size         int64           `json:"size"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"lastModified,omitempty"`
+	Downloaded   time.Time       `json:"downloaded"`
+	Updated      time.Time       `json:"updated"`
+	LastAccess   time.Time       `json:"lastAccess"`
+	AccessCount  int64           `json:"accessCount"`
+	Expires      time.Time       `json:"expires"`
+	// Pinned entries are never evicted
+	Pinned bool `json:"pinned"`
+}
+
+// Expired reports whether the entry expired before now
+func (e *CatalogEntry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && e.Expires.Before(now)
+}
+
+// Catalog records the cached playlists and manifests in a bbolt database so
+// the cache can be queried without reading the stored objects. It is kept in
+// sync by the downloads and removals given the WithCatalog option
+type Catalog struct {
+	db   *bolt.DB
+	keys KeyStrategy
+}
+
+// OpenCatalog opens or creates the catalog database at path, entries are
+// looked up with the WithKeyStrategy option
+func OpenCatalog(path string, opts ...Option) (*Catalog, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, tracksBucket, objectsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Catalog{db: db, keys: newOptions(opts).keys}, nil
+}
+
+// Close closes the catalog database
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+func trackKey(e *CatalogEntry) []byte {
+	return []byte(e.TrackID + "/" + e.Name)
+}
+
+// Entry gets the entry of a source url cached with segmentURLPrefix
+func (c *Catalog) Entry(url, segmentURLPrefix string) (*CatalogEntry, error) {
+	return c.entryNamed(c.keys.Key(segmentURLPrefix, mustParseURL(url)))
+}
+
+// entryNamed gets the entry whose playlist or manifest is stored as name
+func (c *Catalog) entryNamed(name string) (*CatalogEntry, error) {
+	var entry *CatalogEntry
+	err := c.db.View(func(tx *bolt.Tx) error {
+		var err error
+		entry, err = getEntry(tx, name)
+		return err
+	})
+	return entry, err
+}
+
+// Entries lists every entry ordered by name
+func (c *Catalog) Entries() ([]*CatalogEntry, error) {
+	entries := []*CatalogEntry{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			entry := &CatalogEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// TrackEntries lists the entries of a track, the id idAndFile gets from
+// source urls
+func (c *Catalog) TrackEntries(trackID string) ([]*CatalogEntry, error) {
+	entries := []*CatalogEntry{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(trackID + "/")
+		cursor := tx.Bucket(tracksBucket).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			entry, err := getEntry(tx, string(v))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Object describes a stored object with the entries it belongs to
+func (c *Catalog) Object(name string) (*CatalogObject, []*CatalogEntry, error) {
+	var object *CatalogObject
+	entries := []*CatalogEntry{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		names, err := objectEntries(tx, name)
+		if err != nil {
+			return err
+		}
+		for _, entryName := range names {
+			entry, err := getEntry(tx, entryName)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			if o := entry.object(name); o != nil && object == nil {
+				object = o
+			}
+		}
+		if object == nil {
+			return ErrNotCataloged
+		}
+		return nil
+	})
+	return object, entries, err
+}
+
+func (e *CatalogEntry) object(name string) *CatalogObject {
+	for i := range e.Objects {
+		if e.Objects[i].Name == name {
+			return &e.Objects[i]
+		}
+	}
+	return nil
+}
+
+// Size is the number of bytes stored for every entry, objects shared by
+// several entries are counted once
+func (c *Catalog) Size() (int64, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, err
+	}
+	seen := map[string]bool{}
+	var size int64
+	for _, e := range entries {
+		for _, obj := range e.Objects {
+			if !seen[obj.Name] {
+				seen[obj.Name] = true
+				size += obj.Size
+			}
+		}
+	}
+	return size, nil
+}
+
+func getEntry(tx *bolt.Tx, name string) (*CatalogEntry, error) {
+	v := tx.Bucket(entriesBucket).Get([]byte(name))
+	if v == nil {
+		return nil, ErrNotCataloged
+	}
+	entry := &CatalogEntry{}
+	if err := json.Unmarshal(v, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// RecordAccess notes that the entry of a source url was played
+func (c *Catalog) RecordAccess(url, segmentURLPrefix string) error {
+	return c.update(url, segmentURLPrefix, func(e *CatalogEntry) {
+		e.LastAccess = time.Now()
+		e.AccessCount++
+	})
+}
+
+// Pin exempts the entry of a source url from eviction
+func (c *Catalog) Pin(url, segmentURLPrefix string) error {
+	return c.update(url, segmentURLPrefix, func(e *CatalogEntry) {
+		e.Pinned = true
+	})
+}
+
+// Unpin makes the entry of a source url evictable again
+func (c *Catalog) Unpin(url, segmentURLPrefix string) error {
+	return c.update(url, segmentURLPrefix, func(e *CatalogEntry) {
+		e.Pinned = false
+	})
+}
+
+// update changes the entry of a source url in a single transaction
+func (c *Catalog) update(url, segmentURLPrefix string, change func(e *CatalogEntry)) error {
+	name := c.keys.Key(segmentURLPrefix, mustParseURL(url))
+	return c.db.Update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, name)
+		if err != nil {
+			return err
+		}
+		change(entry)
+		return putEntry(tx, entry)
+	})
+}
+
+// shared reports whether object name belongs to entries besides entryName,
+// without a catalog sharedObjects reads the stored playlists instead
+func (c *Catalog) shared(name, entryName string) (bool, error) {
+	shared := false
+	err := c.db.View(func(tx *bolt.Tx) error {
+		names, err := objectEntries(tx, name)
+		for _, n := range names {
+			if n != entryName {
+				shared = true
+			}
+		}
+		return err
+	})
+	return shared, err
+}
+
+// expired reports whether object name only belongs to entries which expired
+// before now, objects which are not cataloged have not expired
+func (c *Catalog) expired(name string, now time.Time) (bool, error) {
+	expired := false
+	err := c.db.View(func(tx *bolt.Tx) error {
+		names, err := objectEntries(tx, name)
+		if err != nil {
+			return err
+		}
+		for _, entryName := range names {
+			entry, err := getEntry(tx, entryName)
+			if err != nil {
+				return err
+			}
+			if !entry.Expired(now) {
+				expired = false
+				return nil
+			}
+			expired = true
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// recordServed notes an access of every entry object name belongs to, the
+// entries themselves count as accessed when their playlist is served
+func (c *Catalog) recordServed(name string) error {
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		names, err := objectEntries(tx, name)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, entryName := range names {
+			entry, err := getEntry(tx, entryName)
+			if err != nil {
+				return err
+			}
+			if entry.Name == name {
+				entry.LastAccess = now
+				entry.AccessCount++
+			}
+			if o := entry.object(name); o != nil {
+				o.LastAccess = now
+				o.AccessCount++
+			}
+			if err := putEntry(tx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// objectEntries gets the names of the entries an object belongs to
+func objectEntries(tx *bolt.Tx, name string) ([]string, error) {
+	names := []string{}
+	v := tx.Bucket(objectsBucket).Get([]byte(name))
+	if v == nil {
+		return names, nil
+	}
+	err := json.Unmarshal(v, &names)
+	return names, err
+}
+
+// indexObjects adds entry to, or removes it from, the entries of each of its
+// objects
+func indexObjects(tx *bolt.Tx, entry *CatalogEntry, add bool) error {
+	bucket := tx.Bucket(objectsBucket)
+	for _, o := range entry.Objects {
+		names, err := objectEntries(tx, o.Name)
+		if err != nil {
+			return err
+		}
+		kept := []string{}
+		for _, name := range names {
+			if name != entry.Name {
+				kept = append(kept, name)
+			}
+		}
+		if add {
+			kept = append(kept, entry.Name)
+		}
+		if len(kept) == 0 {
+			if err := bucket.Delete([]byte(o.Name)); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := json.Marshal(kept)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(o.Name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putEntry(tx *bolt.Tx, entry *CatalogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(entriesBucket).Put([]byte(entry.Name), data); err != nil {
+		return err
+	}
+	return tx.Bucket(tracksBucket).Put(trackKey(entry), []byte(entry.Name))
+}
+
+// put stores an entry, the download time, access statistics and pin of an
+// entry already in the catalog are kept as is the metadata of objects which
+// were not downloaded again. The expiry is kept when the new entry has none
+func (c *Catalog) put(entry *CatalogEntry) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if existing, err := getEntry(tx, entry.Name); err == nil {
+			entry.Downloaded = existing.Downloaded
+			entry.LastAccess, entry.AccessCount = existing.LastAccess, existing.AccessCount
+			entry.Pinned = existing.Pinned
+			if entry.Expires.IsZero() {
+				entry.Expires = existing.Expires
+			}
+			for i := range entry.Objects {
+				o := &entry.Objects[i]
+				old := existing.object(o.Name)
+				if old == nil {
+					continue
+				}
+				if o.Downloaded.IsZero() {
+					o.ETag, o.LastModified, o.Downloaded = old.ETag, old.LastModified, old.Downloaded
+				}
+				o.LastAccess, o.AccessCount = old.LastAccess, old.AccessCount
+			}
+			if err := tx.Bucket(tracksBucket).Delete(trackKey(existing)); err != nil {
+				return err
+			}
+			if err := indexObjects(tx, existing, false); err != nil {
+				return err
+			}
+		}
+		if err := indexObjects(tx, entry, true); err != nil {
+			return err
+		}
+		return putEntry(tx, entry)
+	})
+}
+
+// remove deletes the entry name, a missing entry is not an error
+func (c *Catalog) remove(name string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getEntry(tx, name)
+		if err == ErrNotCataloged {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(tracksBucket).Delete(trackKey(existing)); err != nil {
+			return err
+		}
+		if err := indexObjects(tx, existing, false); err != nil {
+			return err
+		}
+		return tx.Bucket(entriesBucket).Delete([]byte(name))
+	})
+}
+
+// rename renames the entry name and its objects, renamed maps their old
+// names to the new ones. A missing entry is not an error
+func (c *Catalog) rename(name string, renamed map[string]string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, name)
+		if err == ErrNotCataloged {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(tracksBucket).Delete(trackKey(entry)); err != nil {
+			return err
+		}
+		if err := indexObjects(tx, entry, false); err != nil {
+			return err
+		}
+		if err := tx.Bucket(entriesBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		if newName, ok := renamed[entry.Name]; ok {
+			entry.Name = newName
+		}
+		for i := range entry.Objects {
+			if newName, ok := renamed[entry.Objects[i].Name]; ok {
+				entry.Objects[i].Name = newName
+			}
+		}
+		if err := indexObjects(tx, entry, true); err != nil {
+			return err
+		}
+		return putEntry(tx, entry)
+	})
+}
+
+// record catalogs the stored objects of a source url, measuring and
+// checksumming each of them. sources holds the metadata of the objects
+// downloaded for it, expires the end of the entitlement to the url
+func (c *Catalog) record(kind string, sourceURL *url.URL, objects []CatalogObject, storage Storage, segmentURLPrefix string, sources *objectSources, expires time.Time) error {
+	now := time.Now()
+	entry := &CatalogEntry{
+		Name:       objects[0].Name,
+		URL:        sourceURL.String(),
+		Prefix:     segmentURLPrefix,
+		TrackID:    idAndFile(sourceURL)[0],
+		Kind:       kind,
+		Objects:    objects,
+		Downloaded: now,
+		Updated:    now,
+		Expires:    expires,
+	}
+	if src, ok := sources.get(entry.Name); ok {
+		entry.ETag, entry.LastModified = src.ETag, src.LastModified
+	}
+	for i := range entry.Objects {
+		o := &entry.Objects[i]
+		size, checksum, err := checksumObject(storage, o.Name)
+		if err != nil {
+			return err
+		}
+		o.Size, o.Checksum = size, checksum
+		if src, ok := sources.get(o.Name); ok {
+			o.ETag, o.LastModified, o.Downloaded = src.ETag, src.LastModified, src.Downloaded
+		}
+		entry.Size += size
+	}
+	return c.put(entry)
+}
+
+// objectSource is what is known of a download besides its content
+type objectSource struct {
+	ETag         string
+	LastModified string
+	Downloaded   time.Time
+}
+
+// objectSources collects the objectSource of every object downloaded for a
+// playlist or manifest, a nil objectSources collects nothing
+type objectSources struct {
+	mu      sync.Mutex
+	sources map[string]objectSource
+	// stored holds the playlists and manifests which were stored before the
+	// download wrote them
+	stored map[string]bool
+}
+
+func newObjectSources() *objectSources {
+	return &objectSources{sources: map[string]objectSource{}, stored: map[string]bool{}}
+}
+
+// keepStored notes playlist name as stored before the download when it is,
+// it is called before the download writes anything
+func (s *objectSources) keepStored(storage Storage, name string) {
+	if s == nil {
+		return
+	}
+	if _, err := storage.Stat(name); os.IsNotExist(err) {
+		return
+	}
+	s.mu.Lock()
+	s.stored[name] = true
+	s.mu.Unlock()
+}
+
+// created reports whether the download created object name rather than
+// replacing one stored before it
+func (s *objectSources) created(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.stored[name]
+}
+
+// add notes the download of object name with the response header the origin
+// sent
+func (s *objectSources) add(name string, header http.Header) {
+	if s == nil {
+		return
+	}
+	src := objectSource{Downloaded: time.Now()}
+	if header != nil {
+		src.ETag, src.LastModified = header.Get("ETag"), header.Get("Last-Modified")
+	}
+	s.mu.Lock()
+	s.sources[name] = src
+	s.mu.Unlock()
+}
+
+// names lists the objects downloaded
+func (s *objectSources) names() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *objectSources) get(name string) (objectSource, bool) {
+	if s == nil {
+		return objectSource{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.sources[name]
+	return src, ok
+}
+
+// checksumObject reads an object to get its size and sha256
+func checksumObject(storage Storage, name string) (int64, string, error) {
+	obj, err := storage.Get(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer obj.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, obj)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
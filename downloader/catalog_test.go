@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestCatalog(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8": `#EXTM3U
+#EXT-X-TARGETDURATION:9
+#EXTINF:9.009,
+segment-1-a1.ts
+#EXTINF:9.009,
+segment-2-a1.ts
+#EXT-X-ENDLIST
+`,
+		"/hls/track_trd.mp4/segment-1-a1.ts": "abc",
+		"/hls/track_trd.mp4/segment-2-a1.ts": "defgh",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	path := filepath.Join(folder, "catalog.db")
+	catalog, err := OpenCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+
+	if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	catalog.Close()
+	// entries outlive the process which downloaded them
+	if catalog, err = OpenCatalog(path); err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+
+	entry, err := catalog.Entry(url, prefix)
+	if err != nil {
+		t.Fatalf("Entry() error = %v", err)
+	}
+	playlist, _ := storage.Stat(PrefixedHlsFilename(prefix, mustParseURL(url)))
+	if entry.URL != url || entry.Prefix != prefix || entry.TrackID != "track" || entry.Kind != EntryHLS {
+		t.Errorf("Entry() = %+v", entry)
+	}
+	if len(entry.Objects) != 3 || entry.Objects[0].Name != playlist.Name || entry.Objects[2].URL != origin.URL+"/hls/track_trd.mp4/segment-2-a1.ts" {
+		t.Errorf("Entry() objects = %+v", entry.Objects)
+	}
+	if want := playlist.Size + 8; entry.Size != want {
+		t.Errorf("Entry() size = %d, want %d", entry.Size, want)
+	}
+	if got := entry.Objects[1].Checksum; got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("Entry() checksum = %s", got)
+	}
+	if entries, _ := catalog.TrackEntries("track"); len(entries) != 1 {
+		t.Errorf("TrackEntries() = %v, want 1 entry", entries)
+	}
+	if entries, _ := catalog.TrackEntries("other"); len(entries) != 0 {
+		t.Errorf("TrackEntries() = %v, want none", entries)
+	}
+	if size, _ := catalog.Size(); size != entry.Size {
+		t.Errorf("Size() = %d, want %d", size, entry.Size)
+	}
+
+	if err := RemoveHLSPlaylist(url, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if _, err := catalog.Entry(url, prefix); err != ErrNotCataloged {
+		t.Errorf("Entry() removed error = %v, want %v", err, ErrNotCataloged)
+	}
+	if entries, _ := catalog.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() = %v, want none", entries)
+	}
+	if entries, _ := catalog.TrackEntries("track"); len(entries) != 0 {
+		t.Errorf("TrackEntries() = %v, want none", entries)
+	}
+}
+
+func TestCatalogAccess(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8": `#EXTM3U
+#EXT-X-TARGETDURATION:9
+#EXTINF:9.009,
+segment-1-a1.ts
+#EXT-X-ENDLIST
+`,
+		"/hls/track_trd.mp4/segment-1-a1.ts": "abc",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+	segmentURL := origin.URL + "/hls/track_trd.mp4/segment-1-a1.ts"
+	segment := PrefixedHlsFilename(prefix, mustParseURL(segmentURL))
+
+	if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	entry, _ := catalog.Entry(url, prefix)
+	if entry.ETag == "" || entry.Objects[1].ETag != `"`+hashKey("abc")[:16]+`"` || entry.Objects[1].Downloaded.IsZero() {
+		t.Errorf("Entry() sources = %+v", entry)
+	}
+	downloaded := entry.Objects[1].Downloaded
+
+	proxy := NewProxy(storage, prefix, WithCatalog(catalog))
+	for _, u := range []string{url, segmentURL, segmentURL} {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", prefix+u, nil))
+		if w.Code != 200 {
+			t.Fatalf("proxy response = %d", w.Code)
+		}
+	}
+	entry, _ = catalog.Entry(url, prefix)
+	if entry.AccessCount != 1 || entry.LastAccess.IsZero() {
+		t.Errorf("Entry() accesses = %d at %v, want 1", entry.AccessCount, entry.LastAccess)
+	}
+	object, entries, err := catalog.Object(segment)
+	if err != nil || object.AccessCount != 2 || len(entries) != 1 {
+		t.Errorf("Object() = %+v, %d entries, %v", object, len(entries), err)
+	}
+
+	// segments already cached keep their metadata when downloading again
+	if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	entry, _ = catalog.Entry(url, prefix)
+	if o := entry.Objects[1]; !o.Downloaded.Equal(downloaded) || o.AccessCount != 2 || entry.AccessCount != 1 {
+		t.Errorf("Entry() after download = %+v", entry)
+	}
+}
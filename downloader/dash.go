@@ -0,0 +1,301 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/dash"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// fetchDASH retrieves a manifest with every uri resolved to an absolute url,
+// the validators of the response are noted in sources under name
+func fetchDASH(ctx context.Context, url *url.URL, name string, sources *objectSources) (*dash.MPD, error) {
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("unable to retrieve dash")
+	}
+	mpd, err := dash.Decode(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// resolve against the final url so redirected manifests keep working
+	dash.ResolveURIs(mpd, resp.Request.URL)
+	sources.add(name, resp.Header)
+	return mpd, nil
+}
+
+// ProxyDASHUrls prefixes every absolute url of a manifest with proxyServerURL
+func ProxyDASHUrls(mpdRaw []byte, proxyServerURL string) ([]byte, error) {
+	mpd, err := dash.Parse(mpdRaw)
+	if err != nil {
+		return nil, err
+	}
+	mpd.Rewrite(func(uri string) string {
+		if !isAbsoluteURL(uri) || strings.HasPrefix(uri, proxyServerURL) {
+			return uri
+		}
+		return proxyServerURL + uri
+	})
+	return mpd.Encode(), nil
+}
+
+// writeDASH stores a manifest with its urls proxied through segmentURLPrefix
+func writeDASH(body []byte, filename string, storage Storage, segmentURLPrefix string) error {
+	proxiedBody, err := ProxyDASHUrls(body, segmentURLPrefix)
+	if err != nil {
+		return err
+	}
+	return storage.Put(filename, bytes.NewReader(proxiedBody))
+}
+
+// representationVariant describes a representation as a variant so the
+// VariantSelector option picks representations too
+func representationVariant(r *dash.Representation) *hls.Variant {
+	v := &hls.Variant{URI: r.ID(), Bandwidth: r.Bandwidth(), Codecs: r.Codecs()}
+	if r.Width() > 0 && r.Height() > 0 {
+		v.Resolution = &hls.Resolution{Width: r.Width(), Height: r.Height()}
+	}
+	return v
+}
+
+// selectRepresentations removes the representations the selector does not
+// pick in each adaptation set, and the adaptation sets left empty
+func selectRepresentations(mpd *dash.MPD, selector VariantSelector) error {
+	selected := 0
+	for _, p := range mpd.Periods() {
+		for _, a := range p.AdaptationSets() {
+			reps := a.Representations()
+			variants := make([]*hls.Variant, len(reps))
+			for i, r := range reps {
+				variants[i] = representationVariant(r)
+			}
+			keep := map[*hls.Variant]bool{}
+			for _, v := range selector.SelectVariants(variants) {
+				keep[v] = true
+			}
+			kept := 0
+			for i, r := range reps {
+				if !keep[variants[i]] {
+					a.RemoveChild(r.Element)
+					continue
+				}
+				kept++
+			}
+			if kept == 0 {
+				p.RemoveChild(a.Element)
+			}
+			selected += kept
+		}
+	}
+	if selected == 0 {
+		return ErrNoRepresentation
+	}
+	return nil
+}
+
+// dashSegments lists the segments of every representation of a manifest,
+// segmentURLPrefix is stripped from proxied urls
+func dashSegments(mpdRaw []byte, segmentURLPrefix string) ([]*dash.Segment, error) {
+	mpd, err := dash.Parse(mpdRaw)
+	if err != nil {
+		return nil, err
+	}
+	mpd.Rewrite(func(uri string) string {
+		return strings.TrimPrefix(uri, segmentURLPrefix)
+	})
+	segments := []*dash.Segment{}
+	for _, p := range mpd.Periods() {
+		for _, a := range p.AdaptationSets() {
+			for _, r := range a.Representations() {
+				s, err := r.Segments()
+				if err != nil {
+					return nil, err
+				}
+				segments = append(segments, s...)
+			}
+		}
+	}
+	return segments, nil
+}
+
+// GetDASHSegmentURLS get the url of every initialization segment, index and
+// media segment of a manifest, each url is only listed once and
+// segmentURLPrefix is stripped from proxied urls
+func GetDASHSegmentURLS(mpdRaw []byte, segmentURLPrefix string) []string {
+	found := make([]string, 0)
+	segments, _ := dashSegments(mpdRaw, segmentURLPrefix)
+	seen := map[string]bool{}
+	for _, s := range segments {
+		if s.URI != "" && !seen[s.URI] {
+			seen[s.URI] = true
+			found = append(found, s.URI)
+		}
+	}
+	return found
+}
+
+// GetDASHByteRanges get the byte ranges used of every resource which a
+// manifest only references through ranges, such as a SegmentList with
+// mediaRange attributes. Ranges are keyed by url like GetByteRanges
+func GetDASHByteRanges(mpdRaw []byte, segmentURLPrefix string) map[string][]*hls.ByteRange {
+	found := map[string][]*hls.ByteRange{}
+	segments, _ := dashSegments(mpdRaw, segmentURLPrefix)
+	whole := map[string]bool{}
+	for _, s := range segments {
+		if s.ByteRange == nil {
+			whole[s.URI] = true
+			continue
+		}
+		found[s.URI] = append(found[s.URI], &hls.ByteRange{Length: s.ByteRange.Length(), Offset: s.ByteRange.First, HasOffset: true})
+	}
+	for uri, ranges := range found {
+		if whole[uri] {
+			delete(found, uri)
+			continue
+		}
+		found[uri] = mergeByteRanges(ranges)
+	}
+	return found
+}
+
+// GetDASHSegments get the names of all objects related to a DASH url
+func GetDASHSegments(url *url.URL, storage Storage, segmentURLPrefix string, opts ...Option) ([]string, error) {
+	objects, err := dashObjects(url, storage, segmentURLPrefix, newOptions(opts).keys)
+	if err != nil {
+		return nil, err
+	}
+	return objectNames(objects), nil
+}
+
+// dashObjects lists the objects related to a DASH url with the urls they were
+// downloaded from, the manifest comes first
+func dashObjects(url *url.URL, storage Storage, segmentURLPrefix string, keys KeyStrategy) ([]CatalogObject, error) {
+	cleanURL := mustParseURL(strings.Replace(url.String(), segmentURLPrefix, "", -1))
+	filename := keys.Key(segmentURLPrefix, cleanURL)
+	data, err := readObject(storage, filename)
+	if err != nil {
+		return nil, err
+	}
+	objects := []CatalogObject{{Name: filename, URL: cleanURL.String()}}
+	for _, segmentURL := range GetDASHSegmentURLS(data, segmentURLPrefix) {
+		objects = append(objects, CatalogObject{Name: keys.Key(segmentURLPrefix, mustParseURL(segmentURL)), URL: segmentURL})
+	}
+	return objects, nil
+}
+
+// DownloadDASHManifest download an MPEG-DASH manifest, the VariantSelector
+// option picks the representations downloaded from each adaptation set. With
+// the WithTracker option a removal of the manifest waits for the download or
+// cancels it
+func DownloadDASHManifest(url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	o := newOptions(opts)
+	client := grab.NewClient()
+	sourceURL := mustParseURL(url)
+	idf := idAndFile(sourceURL)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	ctx, download, done := o.tracker.begin(context.Background(), filename)
+	defer done()
+	o.ctx, o.download = ctx, download
+	o.sources.keepStored(storage, filename)
+	mpd, err := fetchDASH(o.ctx, sourceURL, filename, o.sources)
+	if err == nil {
+		err = selectRepresentations(mpd, o.variants)
+	}
+	if err == nil {
+		content := mpd.Encode()
+		err = preflight(storage, o, func() (int64, error) {
+			return estimateDASHSize(mpd, content), nil
+		})
+		if err == nil {
+			err = downloadDASHManifest(sourceURL, content, storage, segmentURLPrefix, ps, client, o)
+		}
+	}
+	err = o.canceled(err)
+	if err == nil && o.catalog != nil {
+		var objects []CatalogObject
+		objects, err = dashObjects(sourceURL, storage, segmentURLPrefix, o.keys)
+		if err == nil {
+			err = o.catalog.record(EntryDASH, sourceURL, objects, storage, segmentURLPrefix, o.sources, o.expires(sourceURL))
+		}
+	}
+	if err == nil {
+		err = enforceQuota(o, storage, ps, filename)
+	}
+	if err != nil {
+		ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "0", Status: "failed dash", Error: err.Error()}
+		if isOutOfSpace(err) {
+			ds.Status = "out of space"
+			err = outOfSpace(err, url, storage, segmentURLPrefix, o)
+		}
+		ps.Pub(ds, DownloadStatusChannel)
+
+		log.Debug.Printf("DownloadDASHManifest %v", err)
+		return err
+	}
+
+	ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded dash", Error: ""}
+	ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
+
+// downloadDASHManifest stores a fetched manifest and its segments
+func downloadDASHManifest(sourceURL *url.URL, content []byte, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client, o *options) error {
+	start := time.Now()
+	if _, err := dashSegments(content, segmentURLPrefix); err != nil {
+		return err
+	}
+	idf := idAndFile(sourceURL)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	if err := writeDASH(content, filename, storage, segmentURLPrefix); err != nil {
+		return err
+	}
+	ds := DownloadStatus{URL: sourceURL.String(), ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded index", Error: ""}
+	ps.Pub(ds, DownloadStatusChannel)
+
+	ranges := GetDASHByteRanges(content, segmentURLPrefix)
+	urls := []string{}
+	for _, url := range GetDASHSegmentURLS(content, segmentURLPrefix) {
+		if _, ok := ranges[url]; !ok {
+			urls = append(urls, url)
+		}
+	}
+	if err := downloadSegmentURLs(urls, nil, storage, segmentURLPrefix, ps, client, o); err != nil {
+		return err
+	}
+	if err := downloadRangedResources(ranges, storage, segmentURLPrefix, ps, o); err != nil {
+		return err
+	}
+	log.Debug.Printf("downloaded dash %s - %s", sourceURL, time.Since(start))
+	return nil
+}
+
+// RemoveDASHManifest removes a cached DASH manifest and its segments as
+// RemoveHLSPlaylist removes playlists
+func RemoveDASHManifest(url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	_, err := removeCached(url, storage, segmentURLPrefix, ps, newOptions(opts), dashObjects)
+	return err
+}
+
+// IsDASHManifestDownloaded checks if a dash manifest and all its segments
+// have downloaded, see Lookup for what is missing from one which has not
+func IsDASHManifestDownloaded(url string, storage Storage, segmentURLPrefix string, opts ...Option) bool {
+	report, err := Lookup(url, storage, segmentURLPrefix, opts...)
+	return err == nil && report.Complete()
+}
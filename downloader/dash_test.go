@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestDownloadDASHManifest(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/dash/movie_trd/manifest.mpd": `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT8S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4" codecs="avc1.4d401f">
+      <SegmentTemplate timescale="1" duration="4" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s"/>
+      <Representation id="low" bandwidth="800000" width="640" height="360"/>
+      <Representation id="high" bandwidth="2400000" width="1280" height="720"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4" codecs="mp4a.40.2">
+      <Representation id="audio" bandwidth="128000">
+        <BaseURL>audio/main.mp4</BaseURL>
+        <SegmentList duration="4">
+          <Initialization range="0-9"/>
+          <SegmentURL mediaRange="10-19"/>
+          <SegmentURL mediaRange="20-29"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>
+`,
+		"/dash/movie_trd/high/init.mp4":  "init",
+		"/dash/movie_trd/high/1.m4s":     "one",
+		"/dash/movie_trd/high/2.m4s":     "two",
+		"/dash/movie_trd/audio/main.mp4": strings.Repeat("a", 30) + strings.Repeat("b", 30),
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/dash/movie_trd/manifest.mpd"
+
+	if err := DownloadDASHManifest(url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("DownloadDASHManifest() error = %v", err)
+	}
+	files, _ := ioutil.ReadDir(folder)
+	if len(files) != 5 {
+		t.Errorf("cached %d files, want the manifest, 3 video segments and the audio resource", len(files))
+	}
+	if !IsDASHManifestDownloaded(url, NewFileStorage(folder), prefix) {
+		t.Errorf("IsDASHManifestDownloaded() = false")
+	}
+	audio, _ := ioutil.ReadFile(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/dash/movie_trd/audio/main.mp4"))))
+	if want := strings.Repeat("a", 30); string(audio) != want {
+		t.Errorf("cached audio = %s, want %s", audio, want)
+	}
+
+	proxy := NewProxy(NewFileStorage(folder), prefix)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", prefix+url, nil))
+	body := w.Body.String()
+	if w.Header().Get("Content-Type") != "application/dash+xml" || strings.Contains(body, `id="low"`) ||
+		!strings.Contains(body, `media="http://127.0.0.1:7071/cache?r=1&amp;file=`+origin.URL+`/dash/movie_trd/$RepresentationID$/$Number$.m4s"`) {
+		t.Errorf("proxy manifest response = %s", body)
+	}
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", prefix+origin.URL+"/dash/movie_trd/high/2.m4s", nil))
+	if w.Body.String() != "two" {
+		t.Errorf("proxy segment response = %d %s", w.Code, w.Body.String())
+	}
+
+	if err := RemoveDASHManifest(url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RemoveDASHManifest() error = %v", err)
+	}
+	if files, _ := ioutil.ReadDir(folder); len(files) != 0 {
+		t.Errorf("%d files left after RemoveDASHManifest()", len(files))
+	}
+}
+
+func TestDownloadDASHManifestSelection(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/dash/movie_trd/manifest.mpd": `<MPD mediaPresentationDuration="PT4S"><Period><AdaptationSet mimeType="video/mp4" codecs="avc1"><Representation id="v" bandwidth="1" width="2" height="2"><BaseURL>v.mp4</BaseURL></Representation></AdaptationSet></Period></MPD>`,
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	err = DownloadDASHManifest(origin.URL+"/dash/movie_trd/manifest.mpd", NewFileStorage(folder), "http://127.0.0.1:7071/cache?r=1&file=", pubsub.New(1), WithVariantSelector(AudioOnly(nil)))
+	if err != ErrNoRepresentation {
+		t.Errorf("DownloadDASHManifest() error = %v, want %v", err, ErrNoRepresentation)
+	}
+}
+
+func TestFetchDASHCanceled(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/dash/movie_trd/manifest.mpd": `<MPD mediaPresentationDuration="PT4S"><Period></Period></MPD>`,
+	})
+	defer origin.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := fetchDASH(ctx, mustParseURL(origin.URL+"/dash/movie_trd/manifest.mpd"), "manifest.mpd", newObjectSources()); err == nil {
+		t.Fatal("fetchDASH() with a canceled context succeeded")
+	}
+}
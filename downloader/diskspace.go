@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+
+	"github.com/osiloke/streaming/dash"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// sampledSegments is how many segments of unknown size are measured with a
+// HEAD request when estimating a playlist, the others are extrapolated
+const sampledSegments = 3
+
+// spaceError is a download the preflight found too large for the disk,
+// downloads return it as ErrOutOfSpace
+type spaceError struct {
+	needed, free int64
+}
+
+func (e *spaceError) Error() string {
+	return fmt.Sprintf("%v: needs about %d bytes, %d free", ErrOutOfSpace, e.needed, e.free)
+}
+
+// estimateMediaSize estimates the bytes a media playlist and its segments
+// take. Segments are measured by their byte range, their EXT-X-BITRATE or
+// the bandwidth of their variant, in that order, the size of the others is
+// extrapolated from the Content-Length of the first of them
+func estimateMediaSize(content []byte, bandwidth int64) (int64, error) {
+	p, err := hls.ParseMedia(content)
+	if err != nil {
+		return 0, err
+	}
+	size := float64(len(content))
+	var bitrate int64
+	var unknown []*hls.Segment
+	unknownDuration := 0.0
+	for _, s := range p.Segments {
+		if s.Bitrate > 0 {
+			// EXT-X-BITRATE applies until the next one
+			bitrate = s.Bitrate
+		}
+		switch {
+		case s.ByteRange != nil:
+			size += float64(s.ByteRange.Length)
+		case bitrate > 0:
+			size += float64(bitrate) * 1000 / 8 * s.Duration
+		case bandwidth > 0:
+			size += float64(bandwidth) / 8 * s.Duration
+		default:
+			unknown = append(unknown, s)
+			unknownDuration += s.Duration
+		}
+	}
+	if len(unknown) > sampledSegments {
+		unknown = unknown[:sampledSegments]
+	}
+	size += sampleRate(unknown) * unknownDuration
+	return int64(size), nil
+}
+
+// sampleRate measures the bytes per second of segments from the
+// Content-Length their origin answers HEAD requests with, segments it does
+// not send for are left out
+func sampleRate(segments []*hls.Segment) float64 {
+	var bytes int64
+	duration := 0.0
+	for _, s := range segments {
+		resp, err := http.Head(s.URI)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 || s.Duration <= 0 {
+			continue
+		}
+		bytes += resp.ContentLength
+		duration += s.Duration
+	}
+	if duration == 0 {
+		return 0
+	}
+	return float64(bytes) / duration
+}
+
+// estimateMasterSize estimates the bytes the fetched variant and rendition
+// playlists of a master playlist take, variants are measured with their
+// bandwidth
+func estimateMasterSize(fetched []string, contents map[string][]byte, variants []*hls.Variant) (int64, error) {
+	bandwidths := map[string]int64{}
+	for _, v := range variants {
+		bandwidths[v.URI] = v.Bandwidth
+		if v.AverageBandwidth > 0 {
+			bandwidths[v.URI] = v.AverageBandwidth
+		}
+	}
+	var size int64
+	for _, uri := range fetched {
+		n, err := estimateMediaSize(contents[uri], bandwidths[uri])
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+// estimateDASHSize estimates the bytes the selected representations of a
+// manifest take from their bandwidth and the duration of their period
+func estimateDASHSize(mpd *dash.MPD, content []byte) int64 {
+	size := float64(len(content))
+	for _, p := range mpd.Periods() {
+		duration := p.Duration()
+		if duration == 0 && len(mpd.Periods()) == 1 {
+			duration = mpd.Duration()
+		}
+		for _, a := range p.AdaptationSets() {
+			for _, r := range a.Representations() {
+				size += float64(r.Bandwidth()) / 8 * duration.Seconds()
+			}
+		}
+	}
+	return int64(size)
+}
+
+// preflight checks the file system of a local storage has room for the bytes
+// estimate finds besides the reserve of the WithDiskPreflight option.
+// Downloads are not refused when its free space is unknown
+func preflight(storage Storage, o *options, estimate func() (int64, error)) error {
+	if !o.preflight {
+		return nil
+	}
+	l, ok := storage.(localStorage)
+	if !ok {
+		return nil
+	}
+	needed, err := estimate()
+	if err != nil {
+		return err
+	}
+	free, err := freeSpace(l.Dir())
+	if err != nil {
+		log.Debug.Printf("preflight of %s skipped %v", l.Dir(), err)
+		return nil
+	}
+	if needed+o.reserve > free {
+		return &spaceError{needed: needed, free: free}
+	}
+	return nil
+}
+
+// isOutOfSpace reports whether err is a failed preflight or a write to a
+// full file system
+func isOutOfSpace(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *spaceError:
+			return true
+		case *os.PathError:
+			err = e.Err
+		case *os.LinkError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case *url.Error:
+			err = e.Err
+		default:
+			return err == ErrOutOfSpace || err == syscall.ENOSPC
+		}
+	}
+	return false
+}
+
+// outOfSpace rolls back a download of a source url which ran out of disk
+// space, deleting the objects it created and, when it created the playlist,
+// uncataloging the entry. Playlists stored before the download are kept, as
+// are the segments it skipped because they were stored. Nothing is deleted
+// when the preflight stopped the download before anything was stored. It
+// returns ErrOutOfSpace, or the error the rollback failed with
+func outOfSpace(err error, url string, storage Storage, segmentURLPrefix string, o *options) error {
+	if _, ok := err.(*spaceError); ok {
+		return ErrOutOfSpace
+	}
+	filename := o.key(segmentURLPrefix, mustParseURL(url))
+	names, err := deleteCreated(storage, o, filename)
+	if err != nil {
+		return err
+	}
+	if o.sources.created(filename) {
+		if err := uncatalog(o, url, segmentURLPrefix); err != nil {
+			return err
+		}
+	}
+	log.Debug.Printf("Rolled back %v objects of %s", len(names), url)
+	return ErrOutOfSpace
+}
+
+// deleteCreated deletes the objects the download of o created, along with
+// those of extra it created, and returns their names
+func deleteCreated(storage Storage, o *options, extra ...string) ([]string, error) {
+	names := []string{}
+	for _, name := range append(o.sources.names(), extra...) {
+		if !o.sources.created(name) {
+			continue
+		}
+		if err := storage.Delete(name); err != nil && !os.IsNotExist(err) {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
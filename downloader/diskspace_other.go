@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package downloader
+
+import "errors"
+
+var errFreeSpaceUnknown = errors.New("free space unknown on this platform")
+
+// freeSpace is unknown on this platform, downloads are not preflighted
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnknown
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package downloader
+
+import "syscall"
+
+// freeSpace is the number of bytes unprivileged users can still write to the
+// file system of dir
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,173 @@
+package downloader
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+)
+
+// fullStorage is a Storage whose disk fills up after limit objects
+type fullStorage struct {
+	Storage
+	mu    sync.Mutex
+	limit int
+}
+
+func (s *fullStorage) Put(name string, r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit == 0 {
+		return &os.PathError{Op: "write", Path: name, Err: syscall.ENOSPC}
+	}
+	s.limit--
+	return s.Storage.Put(name, r)
+}
+
+func Test_estimateMediaSize(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/segment-1.ts": strings.Repeat("a", 1000),
+		"/hls/track_trd.mp4/segment-2.ts": strings.Repeat("a", 3000),
+	})
+	defer origin.Close()
+	tests := []struct {
+		name      string
+		segments  string
+		bandwidth int64
+		want      int64
+	}{
+		{"byte ranges", "#EXT-X-BYTERANGE:1000@0\n#EXTINF:10,\nmain.mp4\n#EXT-X-BYTERANGE:500@1000\n#EXTINF:10,\nmain.mp4\n", 0, 1500},
+		{"bitrate", "#EXT-X-BITRATE:8\n#EXTINF:10,\nsegment-1.ts\n#EXTINF:5,\nsegment-2.ts\n", 0, 15000},
+		{"variant bandwidth", "#EXTINF:10,\nsegment-1.ts\n#EXTINF:5,\nsegment-2.ts\n", 800, 1500},
+		{"content length", "#EXTINF:10,\nsegment-1.ts\n#EXTINF:10,\nsegment-2.ts\n#EXTINF:20,\nsegment-3.ts\n#EXTINF:20,\nsegment-4.ts\n", 0, 12000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" + tt.segments + "#EXT-X-ENDLIST\n"
+			content, err := ResolveHLSUrls([]byte(raw), mustParseURL(origin.URL+"/hls/track_trd.mp4/index.m3u8"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := estimateMediaSize(content, tt.bandwidth)
+			if err != nil {
+				t.Fatalf("estimateMediaSize() error = %v", err)
+			}
+			if want := tt.want + int64(len(content)); got != want {
+				t.Errorf("estimateMediaSize() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDownloadHLSPlaylistOutOfSpace(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/huge_trd.mp4/index.m3u8":    "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXT-X-BITRATE:1000000000000\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n",
+		"/hls/huge_trd.mp4/segment.ts":    "a",
+		"/hls/track_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/key1\"\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\nsegment-2.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/segment-1.ts": "aaaa",
+		"/hls/track_trd.mp4/segment-2.ts": "bbbb",
+		"/keys/key1":                      "0123456789abcdef",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	tests := []struct {
+		name    string
+		url     string
+		storage Storage
+		opts    []Option
+	}{
+		{"preflight", origin.URL + "/hls/huge_trd.mp4/index.m3u8", NewFileStorage(folder), []Option{WithDiskPreflight(0)}},
+		{"reserve", origin.URL + "/hls/track_trd.mp4/index.m3u8", NewFileStorage(folder), []Option{WithDiskPreflight(1 << 62)}},
+		{"disk full mid download", origin.URL + "/hls/track_trd.mp4/index.m3u8", &fullStorage{Storage: NewMemoryStorage(), limit: 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := pubsub.New(10)
+			ch := ps.Sub(DownloadStatusChannel)
+			if err := DownloadHLSPlaylist(tt.url, tt.storage, prefix, ps, tt.opts...); err != ErrOutOfSpace {
+				t.Errorf("DownloadHLSPlaylist() error = %v, want ErrOutOfSpace", err)
+			}
+			for found := false; !found; {
+				select {
+				case msg := <-ch:
+					ds := msg.(DownloadStatus)
+					found = ds.Status == "out of space"
+					if ds.Status == "failed hls" || ds.Status == "downloaded hls" {
+						t.Fatalf("DownloadHLSPlaylist() published %v, want out of space", ds.Status)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("DownloadHLSPlaylist() published no out of space status")
+				}
+			}
+			objects, _ := tt.storage.List()
+			if len(objects) != 0 {
+				t.Errorf("objects left = %v, want none", objects)
+			}
+			if files, _ := ioutil.ReadDir(folder); len(files) != 0 {
+				t.Errorf("files left = %v, want none", files)
+			}
+		})
+	}
+}
+
+func TestDownloadHLSPlaylistOutOfSpaceKeepsStored(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\nsegment-2.ts\n"
+	stored := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8":   playlist + "#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/segment-1.ts": "aaaa",
+		"/hls/track_trd.mp4/segment-2.ts": "bbbb",
+	})
+	defer stored.Close()
+	// the same track with a segment more, objects are named by the last two
+	// path segments so both origins share them
+	longer := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8":   playlist + "#EXTINF:9,\nsegment-3.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/segment-1.ts": "aaaa",
+		"/hls/track_trd.mp4/segment-2.ts": "bbbb",
+		"/hls/track_trd.mp4/segment-3.ts": "cccc",
+	})
+	defer longer.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(folder + "/catalog.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	storage := &fullStorage{Storage: NewMemoryStorage(), limit: 3}
+	ps := pubsub.New(10)
+	if err := DownloadHLSPlaylist(stored.URL+"/hls/track_trd.mp4/index.m3u8", storage, prefix, ps, WithCatalog(catalog)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+
+	// the playlist is replaced, the disk fills up on the new segment
+	storage.limit = 1
+	url := longer.URL + "/hls/track_trd.mp4/index.m3u8"
+	if err := DownloadHLSPlaylist(url, storage, prefix, ps, WithCatalog(catalog)); err != ErrOutOfSpace {
+		t.Fatalf("DownloadHLSPlaylist() error = %v, want ErrOutOfSpace", err)
+	}
+	for _, path := range []string{"index.m3u8", "segment-1.ts", "segment-2.ts"} {
+		name := PrefixedHlsFilename(prefix, mustParseURL(stored.URL+"/hls/track_trd.mp4/"+path))
+		if _, err := storage.Stat(name); err != nil {
+			t.Errorf("%s stored before the download rolled back, error = %v", path, err)
+		}
+	}
+	if _, err := catalog.Entry(url, prefix); err != nil {
+		t.Errorf("Entry() of the playlist stored before error = %v", err)
+	}
+}
@@ -2,18 +2,18 @@ package downloader
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/cavaliercoder/grab"
 	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
 	"github.com/osiloke/streaming/log"
 )
 
@@ -50,6 +50,10 @@ type RemoveStatus struct {
 	Progress     string `json:"progress"`
 	Status       string `json:"status"`
 	Error        string `json:"error"`
+	// Freed and Deferred are the bytes a removal deleted and those whose
+	// deletion waits for readers to close
+	Freed    int64 `json:"freed,omitempty"`
+	Deferred int64 `json:"deferred,omitempty"`
 }
 
 func mustParseURL(urlSt string) *url.URL {
@@ -57,176 +61,325 @@ func mustParseURL(urlSt string) *url.URL {
 	return u
 }
 
-// GetHLSSegments get all paths related to an HLS url
-func GetHLSSegments(url *url.URL, folder, segmentURLPrefix string) ([]string, error) {
+// GetHLSSegments get the names of all objects related to an HLS url
+func GetHLSSegments(url *url.URL, storage Storage, segmentURLPrefix string, opts ...Option) ([]string, error) {
 	start := time.Now()
-	cleanURL := mustParseURL(strings.Replace(url.String(), segmentURLPrefix, "", -1))
-	hlsFilename := PrefixedHlsFilename(segmentURLPrefix, cleanURL)
-	dst := filepath.Join(folder, hlsFilename)
 	defer func() {
 		elapsed := time.Since(start)
 		log.Debug.Printf("GetHLSSegments - elapsed - %s", elapsed)
 	}()
-	f, err := os.Open(dst)
+	objects, err := hlsObjects(url, storage, segmentURLPrefix, newOptions(opts).keys)
 	if err != nil {
 		return nil, err
 	}
-	hlsBody, err := ioutil.ReadAll(f)
+	return objectNames(objects), nil
+}
+
+// hlsObjects lists the objects related to an HLS url with the urls they were
+// downloaded from, the playlist comes first
+func hlsObjects(url *url.URL, storage Storage, segmentURLPrefix string, keys KeyStrategy) ([]CatalogObject, error) {
+	cleanURL := mustParseURL(strings.Replace(url.String(), segmentURLPrefix, "", -1))
+	hlsFilename := keys.Key(segmentURLPrefix, cleanURL)
+	hlsBody, err := readObject(storage, hlsFilename)
 	if err != nil {
 		return nil, err
 	}
-	urls := []string{dst}
-	println(string(hlsBody))
-	for _, match := range re.FindAllString(string(hlsBody), -1) {
-		cleanURL := mustParseURL(strings.Replace(match, segmentURLPrefix, "", -1))
-		urls = append(urls, filepath.Join(folder, PrefixedHlsFilename(segmentURLPrefix, cleanURL)))
+	objects := []CatalogObject{{Name: hlsFilename, URL: cleanURL.String()}}
+	_, isMaster := parsePlaylist(hlsBody).(*hls.MasterPlaylist)
+	for _, segmentURL := range GetSegmentURLS(hlsBody, segmentURLPrefix) {
+		if isMaster {
+			variantObjects, err := hlsObjects(mustParseURL(segmentURL), storage, segmentURLPrefix, keys)
+			if os.IsNotExist(err) {
+				// a missing variant playlist is listed without its segments
+				variantObjects = []CatalogObject{{Name: keys.Key(segmentURLPrefix, mustParseURL(segmentURL)), URL: segmentURL}}
+			} else if err != nil {
+				return nil, err
+			}
+			objects = append(objects, variantObjects...)
+			continue
+		}
+		objects = append(objects, CatalogObject{Name: keys.Key(segmentURLPrefix, mustParseURL(segmentURL)), URL: segmentURL})
+	}
+	for _, keyURL := range GetKeyURLS(hlsBody, segmentURLPrefix) {
+		objects = append(objects, CatalogObject{Name: keys.Key(segmentURLPrefix, mustParseURL(keyURL)), URL: keyURL})
 	}
-	return urls, nil
+	return objects, nil
 }
 
-// DownloadHLSURL download a url to a file
-func DownloadHLSURL(url *url.URL, filename, folder, segmentURLPrefix string, ps *pubsub.PubSub) ([]byte, error) {
-	start := time.Now()
-	// done := make(chan int64)
-	resp, err := http.Get(url.String())
+func objectNames(objects []CatalogObject) []string {
+	names := make([]string, len(objects))
+	for i, o := range objects {
+		names[i] = o.Name
+	}
+	return names
+}
+
+// fetchHLS retrieves a playlist with every uri resolved to an absolute url,
+// the request is canceled with ctx
+func fetchHLS(ctx context.Context, url *url.URL) ([]byte, error) {
+	raw, finalURL, _, err := fetchRawHLS(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("unable to retrieve hls")
+	return ResolveHLSUrls(raw, finalURL)
+}
+
+// fetchValidHLS retrieves a playlist like fetchHLS and validates it as it was
+// served, following the ValidationMode option
+func fetchValidHLS(url *url.URL, segmentURLPrefix string, ps *pubsub.PubSub, o *options) ([]byte, error) {
+	raw, finalURL, header, err := fetchRawHLS(o.ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateHLS(url, raw, segmentURLPrefix, ps, o); err != nil {
+		return nil, err
+	}
+	o.sources.add(o.key(segmentURLPrefix, url), header)
+	return ResolveHLSUrls(raw, finalURL)
+}
+
+// fetchRawHLS retrieves a playlist unchanged with the url it was served from
+// and the response header
+func fetchRawHLS(ctx context.Context, url *url.URL) ([]byte, *url.URL, http.Header, error) {
+	req, err := http.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	defer resp.Body.Close()
-	dst := filepath.Join(folder, filename)
+	if resp.StatusCode != 200 {
+		return nil, nil, nil, errors.New("unable to retrieve hls")
+	}
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(resp.Body)
-	body := buf.Bytes()
-	proxiedBody, _ := ProxyHLSUrls(body, segmentURLPrefix)
-	err = ioutil.WriteFile(dst, proxiedBody, 0644)
+	// resolve against the final url so redirected playlists keep working
+	return buf.Bytes(), resp.Request.URL, resp.Header, nil
+}
+
+// writeHLS stores a playlist with its urls proxied through segmentURLPrefix
+func writeHLS(body []byte, filename string, storage Storage, segmentURLPrefix string) error {
+	proxiedBody, err := ProxyHLSUrls(body, segmentURLPrefix)
+	if err != nil {
+		return err
+	}
+	return storage.Put(filename, bytes.NewReader(proxiedBody))
+}
+
+// DownloadHLSURL download a url to a storage object
+func DownloadHLSURL(url *url.URL, filename string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub) ([]byte, error) {
+	start := time.Now()
+	body, err := fetchHLS(context.Background(), url)
+	if err != nil {
+		return nil, err
+	}
+	err = writeHLS(body, filename, storage, segmentURLPrefix)
 	if err != nil {
 		return nil, err
 	}
-	// done <- n
 	elapsed := time.Since(start)
-	log.Debug.Printf("downloaded %s to %s - %s", url, dst, elapsed)
+	log.Debug.Printf("downloaded %s to %s - %s", url, objectPath(storage, filename), elapsed)
 
 	return []byte(strings.TrimSpace(string(body))), err
 }
 
-// DownloadSegmentURLs takes an array of urls to be downloaded
-func DownloadSegmentURLs(urls []string, folder, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client) error {
+// DownloadSegmentURLs takes an array of urls to be downloaded, segments
+// already in storage are skipped. With the WithSegmentValidation option each
+// segment is checked before it is stored
+func DownloadSegmentURLs(urls []string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client, opts ...Option) error {
+	return downloadSegmentURLs(urls, nil, storage, segmentURLPrefix, ps, client, newOptions(opts))
+}
+
+// downloadSegmentURLs downloads urls, the segments in encrypted are only
+// checked for their size by segment validation
+func downloadSegmentURLs(urls []string, encrypted map[string]bool, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client, o *options) error {
+	folder, cleanup, err := stagingFolder(storage)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	ctx, cancel := context.WithCancel(o.ctx)
+	defer cancel()
 	reqs := make([]*grab.Request, 0)
 	for i := 0; i < len(urls); i++ {
-		filename := PrefixedHlsFilename(segmentURLPrefix, mustParseURL(urls[i]))
-		dst := filepath.Join(folder, filename)
-		if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		filename := o.key(segmentURLPrefix, mustParseURL(urls[i]))
+		o.use(filename)
+		if _, err := storage.Stat(filename); !os.IsNotExist(err) {
 			continue
 		}
-		req, err := grab.NewRequest(dst, urls[i])
+		req, err := grab.NewRequest(stagedPath(folder, filename), urls[i])
 		if err != nil {
 			return err
 		}
-		reqs = append(reqs, req)
+		reqs = append(reqs, req.WithContext(ctx))
 	}
 	respCh := client.DoBatch(4, reqs...)
 
 	for resp := range respCh {
 		idf := idAndFile(resp.Request.URL())
 		url := resp.Request.URL().String()
-		filename := PrefixedHlsFilename(segmentURLPrefix, mustParseURL(url))
-		dst := filepath.Join(folder, filename)
-		if err := resp.Err(); err != nil {
-			os.Remove(filename)
+		filename := o.key(segmentURLPrefix, mustParseURL(url))
+		dst := objectPath(storage, filename)
+		err := resp.Err()
+		if err == nil {
+			err = validateSegment(url, stagedPath(folder, filename), encrypted[url], segmentURLPrefix, ps, o)
+		}
+		if err == nil {
+			err = commitStaged(storage, folder, filename)
+		}
+		if err != nil {
+			os.Remove(stagedPath(folder, filename))
 			ps.Pub(DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: fmt.Sprintf("%v", resp.Progress()), Status: "error downloading segment", Error: err.Error()}, DownloadStatusChannel)
+			// stop the other downloads and discard what they staged
+			cancel()
+			for resp := range respCh {
+				resp.Err()
+				os.Remove(stagedPath(folder, o.key(segmentURLPrefix, resp.Request.URL())))
+			}
+			if _, ok := err.(*SegmentError); ok {
+				// a rejected segment rejects the whole download
+				if _, derr := deleteCreated(storage, o); derr != nil {
+					log.Debug.Printf("Discarding rejected download %v", derr)
+				}
+			}
 			return err
 		}
 		ds := DownloadStatus{URL: url, Prefix: segmentURLPrefix, TempFilename: dst, Progress: fmt.Sprintf("%v", resp.Progress()), Status: "downloaded segment", Error: ""}
-		completeSegmentDownload(&ds)
+		completeSegmentDownload(&ds, responseHeader(resp), o)
 		ps.Pub(ds, DownloadStatusChannel)
 	}
 	log.Debug.Printf("Downloaded %v segments\n", len(reqs))
 	return nil
 }
 
-// DownloadHLSPlaylist download an HLS playlist
-func DownloadHLSPlaylist(url, storage, segmentURLPrefix string, ps *pubsub.PubSub) error {
+// DownloadHLSPlaylist download an HLS playlist, for a master playlist the
+// variants picked by the VariantSelector option are downloaded. With the
+// WithValidation option every playlist is validated before anything is
+// downloaded, with the WithSegmentValidation option every segment is checked
+// once downloaded. With the WithTracker option a removal of the playlist waits for
+// the download or cancels it
+func DownloadHLSPlaylist(url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	o := newOptions(opts)
 	client := grab.NewClient()
 	sourceURL := mustParseURL(url)
 	idf := idAndFile(sourceURL)
-	filename := PrefixedHlsFilename(segmentURLPrefix, sourceURL)
-	content, err := DownloadHLSURL(sourceURL, filename, storage, segmentURLPrefix, ps)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	ctx, download, done := o.tracker.begin(context.Background(), filename)
+	defer done()
+	o.ctx, o.download = ctx, download
+	o.sources.keepStored(storage, filename)
+	content, err := fetchValidHLS(sourceURL, segmentURLPrefix, ps, o)
+	if err == nil {
+		var playlist hls.Playlist
+		playlist, err = hls.Parse(content)
+		if master, ok := playlist.(*hls.MasterPlaylist); ok {
+			err = downloadMasterPlaylist(sourceURL, master, storage, segmentURLPrefix, ps, client, o)
+		} else if err == nil {
+			err = preflight(storage, o, func() (int64, error) {
+				return estimateMediaSize(content, 0)
+			})
+			if err == nil {
+				err = downloadMediaPlaylist(sourceURL, content, storage, segmentURLPrefix, ps, client, o)
+			}
+		}
+	}
+	err = o.canceled(err)
+	if err == nil && o.catalog != nil {
+		err = catalogHLS(o, sourceURL, storage, segmentURLPrefix)
+	}
+	if err == nil {
+		err = enforceQuota(o, storage, ps, filename)
+	}
 	if err != nil {
 		ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "0", Status: "failed hls", Error: err.Error()}
+		if isOutOfSpace(err) {
+			ds.Status = "out of space"
+			err = outOfSpace(err, url, storage, segmentURLPrefix, o)
+		}
 		ps.Pub(ds, DownloadStatusChannel)
 
 		log.Debug.Printf("DownloadHLSPlaylist %v", err)
 		return err
 	}
-	ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded index", Error: ""}
+
+	ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded hls", Error: ""}
 	ps.Pub(ds, DownloadStatusChannel)
-	urls := GetSegmentURLS(content, segmentURLPrefix)
-	err = DownloadSegmentURLs(urls, storage, segmentURLPrefix, ps, client)
-	if err != nil {
-		ds = DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "0", Status: "failed hls", Error: err.Error()}
-		ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
 
+// downloadMediaPlaylist stores a fetched media playlist and its segments
+func downloadMediaPlaylist(sourceURL *url.URL, content []byte, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client, o *options) error {
+	idf := idAndFile(sourceURL)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	if err := writeHLS(content, filename, storage, segmentURLPrefix); err != nil {
 		return err
 	}
-
-	ds = DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded hls", Error: ""}
+	ds := DownloadStatus{URL: sourceURL.String(), ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded index", Error: ""}
 	ps.Pub(ds, DownloadStatusChannel)
-	return nil
+	return downloadMediaSegments(content, storage, segmentURLPrefix, ps, client, o)
 }
 
-// RemoveHLSPlaylist removes a cached HLS playlist
-func RemoveHLSPlaylist(url, storage, segmentURLPrefix string, ps *pubsub.PubSub) error {
-	urls, err := GetHLSSegments(mustParseURL(url), storage, segmentURLPrefix)
-	if err != nil {
-		if !strings.Contains(err.Error(), "no such file or directory") {
-			return err
-		}
-		return nil
+// downloadMediaSegments downloads the keys and segments of a media playlist
+func downloadMediaSegments(content []byte, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client, o *options) error {
+	if err := downloadKeyURLs(GetKeyURLS(content, segmentURLPrefix), storage, segmentURLPrefix, ps, o); err != nil {
+		return err
 	}
-	for _, url := range urls[1:] {
-		if err := os.Remove(url); err != nil {
-			if !strings.Contains(err.Error(), "no such file or directory") {
-				ds := RemoveStatus{URL: url, Prefix: segmentURLPrefix, TempFilename: "", Progress: "1", Status: "failed segment", Error: err.Error()}
-				ps.Pub(ds, RemoveStatusChannel)
-				return err
-			}
+	ranges := GetByteRanges(content, segmentURLPrefix)
+	urls := []string{}
+	for _, url := range GetSegmentURLS(content, segmentURLPrefix) {
+		if _, ok := ranges[url]; !ok {
+			urls = append(urls, url)
 		}
-		ds := RemoveStatus{URL: url, Prefix: segmentURLPrefix, TempFilename: "", Progress: "1", Status: "remove segment", Error: ""}
-		ps.Pub(ds, RemoveStatusChannel)
 	}
-	err = os.Remove(urls[0])
-	if err != nil {
-		if !strings.Contains(err.Error(), "no such file or directory") {
-			ds := RemoveStatus{URL: url, Prefix: segmentURLPrefix, TempFilename: "", Progress: "1", Status: "failed segment", Error: err.Error()}
-			ps.Pub(ds, RemoveStatusChannel)
-			return err
-		}
+	if err := downloadSegmentURLs(urls, encryptedSegments(content, segmentURLPrefix), storage, segmentURLPrefix, ps, client, o); err != nil {
+		return err
 	}
-	ds := RemoveStatus{URL: urls[0], Prefix: segmentURLPrefix, TempFilename: "", Progress: "1", Status: "remove index", Error: ""}
-	ps.Pub(ds, RemoveStatusChannel)
-	return nil
+	return downloadRangedResources(ranges, storage, segmentURLPrefix, ps, o)
 }
 
-// IsHSLPlaylistDownloaded checks if an hls file has downloaded
-func IsHSLPlaylistDownloaded(url, folder, segmentURLPrefix string) bool {
-	sourceURL := mustParseURL(url)
-	filename := hlsFilename(sourceURL)
-	dst := filepath.Join(folder, filename)
-	if _, err := os.Stat(dst); os.IsNotExist(err) {
-		return false
-	}
-	data, err := ioutil.ReadFile(dst)
+// catalogHLS records a downloaded HLS playlist in the WithCatalog catalog
+func catalogHLS(o *options, sourceURL *url.URL, storage Storage, segmentURLPrefix string) error {
+	objects, err := hlsObjects(sourceURL, storage, segmentURLPrefix, o.keys)
 	if err != nil {
-		return false
+		return err
 	}
-	urls := GetSegmentURLS(data, segmentURLPrefix)
-	for _, url := range urls {
-		if !segmentExists(url, folder) {
-			return false
-		}
+	return o.catalog.record(EntryHLS, sourceURL, objects, storage, segmentURLPrefix, o.sources, o.expires(sourceURL))
+}
+
+// responseHeader is the header of a grab response, if there was one
+func responseHeader(resp *grab.Response) http.Header {
+	if resp.HTTPResponse == nil {
+		return nil
 	}
-	return true
+	return resp.HTTPResponse.Header
+}
+
+// RemoveHLSPlaylist removes a cached HLS playlist and its objects, objects
+// other cached playlists and manifests reference are kept. They are found in
+// the WithCatalog catalog, whose entry is removed once every other object
+// is, or by reading every stored playlist without one. With the WithTracker
+// option downloads of the playlist are waited for, or canceled with the
+// WithCancelDownloads option, objects other downloads in progress store or
+// reuse are kept and objects being served are deleted once they are closed.
+// A "removed" RemoveStatus reports the bytes freed
+func RemoveHLSPlaylist(url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	_, err := removeCached(url, storage, segmentURLPrefix, ps, newOptions(opts), hlsObjects)
+	return err
+}
+
+// uncatalog removes the catalog entry of a removed url
+func uncatalog(o *options, url, segmentURLPrefix string) error {
+	if o.catalog == nil {
+		return nil
+	}
+	return o.catalog.remove(o.key(segmentURLPrefix, mustParseURL(url)))
+}
+
+// IsHSLPlaylistDownloaded checks if an hls file has downloaded, see Lookup
+// for what is missing from one which has not
+func IsHSLPlaylistDownloaded(url string, storage Storage, segmentURLPrefix string, opts ...Option) bool {
+	report, err := Lookup(url, storage, segmentURLPrefix, opts...)
+	return err == nil && report.Complete()
 }
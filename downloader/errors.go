@@ -3,3 +3,70 @@ package downloader
 import "errors"
 
 var ErrFailed = errors.New("failed")
+
+// ErrNoVariant is returned when no variant of a master playlist was selected
+var ErrNoVariant = errors.New("no variant selected from master playlist")
+
+// ErrNoRepresentation is returned when no representation of a DASH manifest
+// was selected
+var ErrNoRepresentation = errors.New("no representation selected from manifest")
+
+// ErrNotCataloged is returned when a Catalog has no entry for a url
+var ErrNotCataloged = errors.New("not in catalog")
+
+// ErrNoCatalog is returned when the WithQuota option is used without the
+// WithCatalog option
+var ErrNoCatalog = errors.New("a quota needs a catalog")
+
+// ErrExpired is returned for catalog entries whose source url expired
+var ErrExpired = errors.New("entitlement expired")
+
+// ErrOutOfSpace is returned by downloads which do not fit on the disk of
+// their storage
+var ErrOutOfSpace = errors.New("not enough disk space")
+
+// ErrNotCached is returned by Export for urls whose playlist is not stored
+var ErrNotCached = errors.New("not cached")
+
+// ErrIncomplete is returned by Export for urls with objects missing from the
+// cache
+var ErrIncomplete = errors.New("cache incomplete")
+
+// ErrBadPackage is returned for unknown package formats and packages Export
+// did not write
+var ErrBadPackage = errors.New("not an exported package")
+
+// ErrUnsupportedMedia is returned when cached media can not be remuxed to
+// the requested format
+var ErrUnsupportedMedia = errors.New("media can not be remuxed")
+
+// ErrEmptySegment is returned by segment validation for empty segments
+var ErrEmptySegment = errors.New("empty segment")
+
+// ErrHTMLSegment is returned by segment validation for segments which are
+// web pages, like the error pages of some origins
+var ErrHTMLSegment = errors.New("segment is a web page")
+
+// ErrNotRecorded is returned by RecordHLSPlaylist when the recording was
+// stopped before a playlist was recorded
+var ErrNotRecorded = errors.New("nothing recorded")
+
+// ErrDownloadCanceled is returned by downloads a removal canceled
+var ErrDownloadCanceled = errors.New("download canceled by removal")
+
+// RemoveError is returned when an object of a removed playlist or manifest
+// could not be deleted
+type RemoveError struct {
+	URL    string
+	Object string
+	Err    error
+}
+
+func (e *RemoveError) Error() string {
+	return "remove " + e.Object + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error deleting the object failed with
+func (e *RemoveError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,165 @@
+package downloader
+
+import (
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/log"
+)
+
+// EvictionPolicy orders catalog entries for eviction
+type EvictionPolicy interface {
+	// EvictBefore reports whether a is evicted before b
+	EvictBefore(a, b *CatalogEntry) bool
+}
+
+// EvictionPolicyFunc adapts a function to an EvictionPolicy
+type EvictionPolicyFunc func(a, b *CatalogEntry) bool
+
+// EvictBefore calls f(a, b)
+func (f EvictionPolicyFunc) EvictBefore(a, b *CatalogEntry) bool {
+	return f(a, b)
+}
+
+// lastUsed is the last access of an entry, or its download for entries never
+// played
+func lastUsed(e *CatalogEntry) time.Time {
+	if e.LastAccess.IsZero() {
+		return e.Downloaded
+	}
+	return e.LastAccess
+}
+
+// LeastRecentlyUsed evicts the entries accessed longest ago first
+func LeastRecentlyUsed() EvictionPolicy {
+	return EvictionPolicyFunc(func(a, b *CatalogEntry) bool {
+		return lastUsed(a).Before(lastUsed(b))
+	})
+}
+
+// LeastFrequentlyUsed evicts the entries played the fewest times first, ties
+// are broken by least recent use
+func LeastFrequentlyUsed() EvictionPolicy {
+	return EvictionPolicyFunc(func(a, b *CatalogEntry) bool {
+		if a.AccessCount != b.AccessCount {
+			return a.AccessCount < b.AccessCount
+		}
+		return lastUsed(a).Before(lastUsed(b))
+	})
+}
+
+// OldestFirst evicts the entries downloaded longest ago first
+func OldestFirst() EvictionPolicy {
+	return EvictionPolicyFunc(func(a, b *CatalogEntry) bool {
+		return a.Downloaded.Before(b.Downloaded)
+	})
+}
+
+// Evict removes whole entries of catalog from storage, in the order policy
+// evicts them, until the entries fit in quota bytes. Pinned entries and the
+// entries named in keep are never evicted, so they may leave the cache over
+// quota. Besides the RemoveStatus of every removed object an "evicted"
+// RemoveStatus is published for each evicted entry
+func Evict(catalog *Catalog, storage Storage, quota int64, policy EvictionPolicy, ps *pubsub.PubSub, keep ...string) ([]*CatalogEntry, error) {
+	return evict(storage, quota, policy, ps, newOptions([]Option{WithCatalog(catalog)}), keep)
+}
+
+// evict is Evict with the catalog, key strategy and tracker of o, the
+// removals wait for or cancel the downloads of the entries as o asks
+func evict(storage Storage, quota int64, policy EvictionPolicy, ps *pubsub.PubSub, o *options, keep []string) ([]*CatalogEntry, error) {
+	entries, err := o.catalog.Entries()
+	if err != nil {
+		return nil, err
+	}
+	size, err := cachedSize(storage, entries)
+	if err != nil {
+		return nil, err
+	}
+	kept := map[string]bool{}
+	for _, name := range keep {
+		kept[name] = true
+	}
+	candidates := []*CatalogEntry{}
+	for _, e := range entries {
+		if !e.Pinned && !kept[e.Name] {
+			candidates = append(candidates, e)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return policy.EvictBefore(candidates[i], candidates[j])
+	})
+	evicted := []*CatalogEntry{}
+	for _, e := range candidates {
+		if size <= quota {
+			break
+		}
+		idf := idAndFile(mustParseURL(e.URL))
+		freed, err := removeEntry(storage, e, ps, o)
+		if err != nil {
+			ds := RemoveStatus{URL: e.URL, ID: idf[0], Segment: idf[1], Prefix: e.Prefix, TempFilename: e.Name, Progress: "0", Status: "failed eviction", Error: err.Error()}
+			ps.Pub(ds, RemoveStatusChannel)
+			return evicted, err
+		}
+		size -= freed
+		evicted = append(evicted, e)
+		ds := RemoveStatus{URL: e.URL, ID: idf[0], Segment: idf[1], Prefix: e.Prefix, TempFilename: e.Name, Progress: "1", Status: "evicted", Error: ""}
+		ps.Pub(ds, RemoveStatusChannel)
+	}
+	log.Debug.Printf("Evicted %v entries, %v bytes cached\n", len(evicted), size)
+	return evicted, nil
+}
+
+// cachedSize is the number of bytes the objects of entries keep in storage.
+// Objects shared by several entries are counted once, and content a
+// referenceStorage shares between names is split between them
+func cachedSize(storage Storage, entries []*CatalogEntry) (int64, error) {
+	refs, shares := storage.(referenceStorage)
+	seen := map[string]bool{}
+	var size int64
+	for _, e := range entries {
+		for _, obj := range e.Objects {
+			if seen[obj.Name] {
+				continue
+			}
+			seen[obj.Name] = true
+			if !shares {
+				size += obj.Size
+				continue
+			}
+			n, err := refs.References(obj.Name)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			size += obj.Size / int64(n)
+		}
+	}
+	return size, nil
+}
+
+// removeEntry removes the objects of a catalog entry of o.catalog and the
+// entry itself, it returns the bytes deleted
+func removeEntry(storage Storage, e *CatalogEntry, ps *pubsub.PubSub, o *options) (int64, error) {
+	list := hlsObjects
+	if e.Kind == EntryDASH {
+		list = dashObjects
+	}
+	return removeCached(e.URL, storage, e.Prefix, ps, o, list)
+}
+
+// enforceQuota evicts entries after a download as the WithQuota option asks,
+// the entry just downloaded is kept
+func enforceQuota(o *options, storage Storage, ps *pubsub.PubSub, name string) error {
+	if o.quota <= 0 {
+		return nil
+	}
+	if o.catalog == nil {
+		return ErrNoCatalog
+	}
+	_, err := evict(storage, o.quota, o.eviction, ps, o, []string{name})
+	return err
+}
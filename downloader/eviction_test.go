@@ -0,0 +1,253 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	now := time.Now()
+	entries := []*CatalogEntry{
+		{Name: "a", Downloaded: now.Add(-3 * time.Hour), LastAccess: now, AccessCount: 1},
+		{Name: "b", Downloaded: now.Add(-2 * time.Hour), LastAccess: now.Add(-time.Hour), AccessCount: 5},
+		{Name: "c", Downloaded: now.Add(-90 * time.Minute)},
+	}
+	tests := []struct {
+		name   string
+		policy EvictionPolicy
+		want   []string
+	}{
+		{"LeastRecentlyUsed", LeastRecentlyUsed(), []string{"c", "b", "a"}},
+		{"LeastFrequentlyUsed", LeastFrequentlyUsed(), []string{"c", "a", "b"}},
+		{"OldestFirst", OldestFirst(), []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := append([]*CatalogEntry{}, entries...)
+			sort.Slice(sorted, func(i, j int) bool {
+				return tt.policy.EvictBefore(sorted[i], sorted[j])
+			})
+			got := []string{}
+			for _, e := range sorted {
+				got = append(got, e.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("eviction order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadHLSPlaylistQuota(t *testing.T) {
+	files := map[string]string{}
+	for _, track := range []string{"a", "b", "c"} {
+		files["/hls/"+track+"_trd.mp4/index.m3u8"] = "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n"
+		files["/hls/"+track+"_trd.mp4/segment.ts"] = strings.Repeat(track, 1000)
+	}
+	origin := testOrigin(files)
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	removed := ps.Sub(RemoveStatusChannel)
+	trackURL := func(track string) string {
+		return origin.URL + "/hls/" + track + "_trd.mp4/index.m3u8"
+	}
+	cached := func() []string {
+		entries, _ := catalog.Entries()
+		tracks := []string{}
+		for _, e := range entries {
+			tracks = append(tracks, e.TrackID)
+		}
+		return tracks
+	}
+	evicted := func() []string {
+		tracks := []string{}
+		for {
+			select {
+			case msg := <-removed:
+				if rs := msg.(RemoveStatus); rs.Status == "evicted" {
+					tracks = append(tracks, rs.ID)
+				}
+			default:
+				return tracks
+			}
+		}
+	}
+
+	for _, track := range []string{"a", "b"} {
+		if err := DownloadHLSPlaylist(trackURL(track), storage, prefix, ps, WithCatalog(catalog)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+	catalog.RecordAccess(trackURL("a"), prefix)
+	entry, _ := catalog.Entry(trackURL("a"), prefix)
+	quota := 2*entry.Size + 10
+
+	err = DownloadHLSPlaylist(trackURL("c"), storage, prefix, ps, WithCatalog(catalog), WithQuota(quota, LeastRecentlyUsed()))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	if got, want := evicted(), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("evicted = %v, want %v", got, want)
+	}
+	if got, want := cached(), []string{"a", "c"}; len(got) != len(want) {
+		t.Errorf("cached = %v, want %v", got, want)
+	}
+	if _, err := storage.Stat(PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/hls/b_trd.mp4/segment.ts"))); !os.IsNotExist(err) {
+		t.Errorf("evicted segment still stored, error = %v", err)
+	}
+
+	catalog.Pin(trackURL("a"), prefix)
+	err = DownloadHLSPlaylist(trackURL("b"), storage, prefix, ps, WithCatalog(catalog), WithQuota(1, OldestFirst()))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	if got, want := evicted(), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("evicted = %v, want %v", got, want)
+	}
+
+	err = DownloadHLSPlaylist(trackURL("c"), storage, prefix, ps, WithQuota(1, OldestFirst()))
+	if err != ErrNoCatalog {
+		t.Errorf("DownloadHLSPlaylist() error = %v, want %v", err, ErrNoCatalog)
+	}
+}
+
+func TestDownloadHLSPlaylistQuotaTracker(t *testing.T) {
+	files := map[string]string{}
+	for _, track := range []string{"a", "b", "c"} {
+		files["/hls/"+track+"_trd.mp4/index.m3u8"] = "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n"
+		files["/hls/"+track+"_trd.mp4/segment.ts"] = strings.Repeat(track, 1000)
+	}
+	origin := testOrigin(files)
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	removed := ps.Sub(RemoveStatusChannel)
+	tracker := NewTracker()
+	trackURL := func(track string) string {
+		return origin.URL + "/hls/" + track + "_trd.mp4/index.m3u8"
+	}
+	for _, track := range []string{"a", "b"} {
+		if err := DownloadHLSPlaylist(trackURL(track), storage, prefix, ps, WithCatalog(catalog)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+	entry, _ := catalog.Entry(trackURL("a"), prefix)
+	segment := PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/hls/a_trd.mp4/segment.ts"))
+	closeSegment := tracker.open(segment)
+
+	// the segment of a is served, evicting a frees too little so b goes too
+	err = DownloadHLSPlaylist(trackURL("c"), storage, prefix, ps, WithCatalog(catalog), WithTracker(tracker), WithQuota(2*entry.Size+10, OldestFirst()))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	ps.Unsub(removed, RemoveStatusChannel)
+	evicted := []string{}
+	for msg := range removed {
+		if rs := msg.(RemoveStatus); rs.Status == "evicted" {
+			evicted = append(evicted, rs.ID)
+		}
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted = %v, want %v", evicted, want)
+	}
+	if _, err := storage.Stat(segment); err != nil {
+		t.Errorf("served segment deleted, error = %v", err)
+	}
+	closeSegment()
+	if _, err := storage.Stat(segment); !os.IsNotExist(err) {
+		t.Errorf("served segment kept after its reader closed, error = %v", err)
+	}
+}
+
+func TestDownloadHLSPlaylistQuotaShared(t *testing.T) {
+	files := map[string]string{"/hls/shared.ts": strings.Repeat("s", 1000)}
+	origin := testOrigin(files)
+	defer origin.Close()
+	for _, track := range []string{"a", "b", "c"} {
+		files["/hls/"+track+"_trd.mp4/index.m3u8"] = "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\n" + origin.URL + "/hls/shared.ts\n#EXT-X-ENDLIST\n"
+	}
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	removed := ps.Sub(RemoveStatusChannel)
+	trackURL := func(track string) string {
+		return origin.URL + "/hls/" + track + "_trd.mp4/index.m3u8"
+	}
+	for _, track := range []string{"a", "b"} {
+		if err := DownloadHLSPlaylist(trackURL(track), storage, prefix, ps, WithCatalog(catalog)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+	stored, _ := storage.List()
+	var want int64
+	for _, info := range stored {
+		want += info.Size
+	}
+	if size, err := catalog.Size(); err != nil || size != want {
+		t.Errorf("Size() = %v, %v, want %v", size, err, want)
+	}
+
+	// the segment is stored once, so the three playlists fit
+	err = DownloadHLSPlaylist(trackURL("c"), storage, prefix, ps, WithCatalog(catalog), WithQuota(1500, OldestFirst()))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	// evicting a frees only its playlist, b has to go too
+	entry, _ := catalog.Entry(trackURL("a"), prefix)
+	playlist := entry.Size - 1000
+	err = DownloadHLSPlaylist(trackURL("c"), storage, prefix, ps, WithCatalog(catalog), WithQuota(1000+2*playlist-1, OldestFirst()))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	ps.Unsub(removed, RemoveStatusChannel)
+	evicted := []string{}
+	for msg := range removed {
+		if rs := msg.(RemoveStatus); rs.Status == "evicted" {
+			evicted = append(evicted, rs.ID)
+		}
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted = %v, want %v", evicted, want)
+	}
+}
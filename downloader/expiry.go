@@ -0,0 +1,158 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/log"
+)
+
+// ExpiryExtractor finds when access to a source url ends, usually from a
+// token signed into it
+type ExpiryExtractor interface {
+	// Expiry returns the expiry of u, ok is false when u has none
+	Expiry(u *url.URL) (expiry time.Time, ok bool)
+}
+
+// ExpiryExtractorFunc adapts a function to an ExpiryExtractor
+type ExpiryExtractorFunc func(u *url.URL) (time.Time, bool)
+
+// Expiry calls f(u)
+func (f ExpiryExtractorFunc) Expiry(u *url.URL) (time.Time, bool) {
+	return f(u)
+}
+
+// urlTokens lists the path segments and query values of u, the places tokens
+// are signed into
+func urlTokens(u *url.URL) []string {
+	tokens := strings.Split(u.Path, "/")
+	for _, values := range u.Query() {
+		tokens = append(tokens, values...)
+	}
+	return tokens
+}
+
+// JWTExpiry reads the exp claim of a JSON Web Token found in a path segment or
+// query value of the url
+func JWTExpiry() ExpiryExtractor {
+	return ExpiryExtractorFunc(func(u *url.URL) (time.Time, bool) {
+		for _, token := range urlTokens(u) {
+			parts := strings.Split(token, ".")
+			if len(parts) != 3 {
+				continue
+			}
+			payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+			if err != nil {
+				continue
+			}
+			var claims struct {
+				Exp *float64 `json:"exp"`
+			}
+			if json.Unmarshal(payload, &claims) != nil || claims.Exp == nil {
+				continue
+			}
+			return time.Unix(int64(*claims.Exp), 0), true
+		}
+		return time.Time{}, false
+	})
+}
+
+// AkamaiTokenExpiry reads the exp field of an Akamai style token, such as
+// st=1561454833~exp=1561458433~acl=/*~hmac=..., found in a query value of the
+// url
+func AkamaiTokenExpiry() ExpiryExtractor {
+	return ExpiryExtractorFunc(func(u *url.URL) (time.Time, bool) {
+		for _, values := range u.Query() {
+			for _, value := range values {
+				for _, field := range strings.Split(value, "~") {
+					if !strings.HasPrefix(field, "exp=") {
+						continue
+					}
+					if exp, err := strconv.ParseInt(strings.TrimPrefix(field, "exp="), 10, 64); err == nil {
+						return time.Unix(exp, 0), true
+					}
+				}
+			}
+		}
+		return time.Time{}, false
+	})
+}
+
+// EarliestExpiry is the earliest expiry any of extractors finds
+func EarliestExpiry(extractors ...ExpiryExtractor) ExpiryExtractor {
+	return ExpiryExtractorFunc(func(u *url.URL) (time.Time, bool) {
+		var earliest time.Time
+		for _, e := range extractors {
+			if expiry, ok := e.Expiry(u); ok && (earliest.IsZero() || expiry.Before(earliest)) {
+				earliest = expiry
+			}
+		}
+		return earliest, !earliest.IsZero()
+	})
+}
+
+// DefaultExpiry is the earliest of the JWTExpiry and AkamaiTokenExpiry of a
+// url
+func DefaultExpiry() ExpiryExtractor {
+	return EarliestExpiry(JWTExpiry(), AkamaiTokenExpiry())
+}
+
+// RemoveExpired removes every entry of catalog which expired before now from
+// storage. Pinned entries are removed too, an expired entitlement overrides
+// the pin. Besides the RemoveStatus of every removed object an "expired"
+// RemoveStatus is published for each removed entry, or "failed expiry" when
+// it could not be removed. With the WithTracker option the removals wait for,
+// or with WithCancelDownloads cancel, the downloads of the entries and defer
+// deleting the objects being served
+func RemoveExpired(catalog *Catalog, storage Storage, ps *pubsub.PubSub, opts ...Option) ([]*CatalogEntry, error) {
+	entries, err := catalog.Entries()
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(append([]Option{WithCatalog(catalog)}, opts...))
+	now := time.Now()
+	removed := []*CatalogEntry{}
+	var firstErr error
+	for _, e := range entries {
+		if !e.Expired(now) {
+			continue
+		}
+		idf := idAndFile(mustParseURL(e.URL))
+		if _, err := removeEntry(storage, e, ps, o); err != nil {
+			ds := RemoveStatus{URL: e.URL, ID: idf[0], Segment: idf[1], Prefix: e.Prefix, TempFilename: e.Name, Progress: "0", Status: "failed expiry", Error: err.Error()}
+			ps.Pub(ds, RemoveStatusChannel)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		removed = append(removed, e)
+		ds := RemoveStatus{URL: e.URL, ID: idf[0], Segment: idf[1], Prefix: e.Prefix, TempFilename: e.Name, Progress: "1", Status: "expired", Error: ""}
+		ps.Pub(ds, RemoveStatusChannel)
+	}
+	log.Debug.Printf("Removed %v expired entries\n", len(removed))
+	return removed, firstErr
+}
+
+// SweepExpired calls RemoveExpired with opts every interval until ctx is
+// done, it is meant to run in its own goroutine
+func SweepExpired(ctx context.Context, catalog *Catalog, storage Storage, ps *pubsub.PubSub, interval time.Duration, opts ...Option) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := RemoveExpired(catalog, storage, ps, opts...); err != nil {
+			log.Debug.Printf("SweepExpired %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/dash"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// Package formats of Export
+const (
+	PackageDir = "dir"
+	PackageTar = "tar"
+	PackageZip = "zip"
+)
+
+// packageManifestName is the file describing a package, it comes first in
+// tar packages
+const packageManifestName = "package.json"
+
+// packageManifest describes an exported package
+type packageManifest struct {
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+	// Playlist is the file of the playlist or manifest to play
+	Playlist string `json:"playlist"`
+	// Origins maps the top folders of the package to the origins of the urls
+	// stored in them
+	Origins map[string]string `json:"origins"`
+	// Files lists the files of the package in the order they are written,
+	// playlists last, with the urls they were downloaded from
+	Files []packageFile `json:"files"`
+}
+
+type packageFile struct {
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	Playlist bool   `json:"playlist,omitempty"`
+	size     int64
+	name     string
+}
+
+// folder is where the package stores u, a folder named after its origin
+// followed by its path
+func (m *packageManifest) folder(u string) string {
+	source := mustParseURL(u)
+	if source == nil || !source.IsAbs() {
+		return u
+	}
+	origin := strings.Replace(source.Host, ":", "_", -1)
+	m.Origins[origin] = source.Scheme + "://" + source.Host
+	p := path.Clean("/" + source.Path)
+	if strings.HasSuffix(source.Path, "/") && p != "/" {
+		p += "/"
+	}
+	return origin + p
+}
+
+// sourceURL is the url a uri of the package file from refers to
+func (m *packageManifest) sourceURL(from, uri string) string {
+	if isAbsoluteURL(uri) {
+		return uri
+	}
+	p := path.Join(path.Dir(from), uri)
+	if strings.HasSuffix(uri, "/") {
+		p += "/"
+	}
+	for _, f := range m.Files {
+		if f.Path == p {
+			return f.URL
+		}
+	}
+	parts := strings.SplitN(p, "/", 2)
+	if origin, ok := m.Origins[parts[0]]; ok && len(parts) == 2 {
+		return origin + "/" + parts[1]
+	}
+	return uri
+}
+
+// relativePath is the uri of the package file to from the package file from
+func relativePath(from, to string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(from)), filepath.FromSlash(to))
+	if err != nil {
+		return to
+	}
+	rel = filepath.ToSlash(rel)
+	if strings.HasSuffix(to, "/") && !strings.HasSuffix(rel, "/") {
+		rel += "/"
+	}
+	return rel
+}
+
+// validPackagePath reports whether p names a file inside a package
+func validPackagePath(p string) bool {
+	return p != "" && p != "." && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// rewritePlaylist replaces every uri of an HLS playlist or DASH manifest
+// with fn(uri)
+func rewritePlaylist(kind string, data []byte, fn func(uri string) string) ([]byte, error) {
+	if kind == EntryDASH {
+		mpd, err := dash.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		mpd.Rewrite(fn)
+		return mpd.Encode(), nil
+	}
+	playlist, err := hls.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	playlist.Rewrite(fn)
+	return playlist.Encode(), nil
+}
+
+// Export writes the cached playlist or manifest of a source url with every
+// object it references to a package at path which plays offline, in the
+// PackageDir, PackageTar or PackageZip format. Objects are stored in folders
+// named after their origin and path and the playlists are rewritten to refer
+// to them by relative paths, the playlist to play is index.m3u8, or
+// manifest.mpd for DASH. A package.json file lists the source url of every
+// file so Import can cache them again. Urls are looked up with the
+// WithKeyStrategy option, ErrNotCached or ErrIncomplete is returned when
+// the url is not cached completely
+func Export(url string, storage Storage, segmentURLPrefix, dst, format string, opts ...Option) error {
+	o := newOptions(opts)
+	report, err := Lookup(url, storage, segmentURLPrefix, opts...)
+	if err != nil {
+		return err
+	}
+	if !report.PlaylistPresent {
+		return ErrNotCached
+	}
+	if !report.Complete() {
+		return ErrIncomplete
+	}
+	m, data, err := exportManifest(url, storage, segmentURLPrefix, o)
+	if err != nil {
+		return err
+	}
+	pkg, err := createPackage(dst, format)
+	if err != nil {
+		return err
+	}
+	err = pkg.add(packageManifestName, int64(len(data)), bytes.NewReader(data))
+	for i := 0; err == nil && i < len(m.Files); i++ {
+		err = exportFile(pkg, m, m.Files[i], storage, segmentURLPrefix)
+	}
+	if cerr := pkg.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug.Printf("Exported %v files of %s to %s", len(m.Files), url, dst)
+	return nil
+}
+
+// exportManifest lists the files of the package of a source url and encodes
+// the manifest
+func exportManifest(url string, storage Storage, segmentURLPrefix string, o *options) (*packageManifest, []byte, error) {
+	sourceURL := mustParseURL(url)
+	objects, err := cachedObjects(sourceURL, storage, segmentURLPrefix, o.keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := readObject(storage, objects[0].Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &packageManifest{URL: url, Kind: EntryDASH, Playlist: "manifest.mpd", Origins: map[string]string{}}
+	playlists := map[string]bool{objects[0].URL: true}
+	if bytes.HasPrefix(root, []byte("#EXTM3U")) {
+		m.Kind, m.Playlist = EntryHLS, "index.m3u8"
+		if _, ok := parsePlaylist(root).(*hls.MasterPlaylist); ok {
+			for _, u := range GetSegmentURLS(root, segmentURLPrefix) {
+				playlists[u] = true
+			}
+		}
+	}
+	used := map[string]bool{packageManifestName: true, m.Playlist: true}
+	seen := map[string]bool{}
+	files, playlistFiles := []packageFile{}, []packageFile{}
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
+		if seen[obj.URL] {
+			// byte ranges of a resource share its file
+			continue
+		}
+		seen[obj.URL] = true
+		info, err := storage.Stat(obj.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		f := packageFile{Path: m.Playlist, URL: obj.URL, Playlist: playlists[obj.URL], size: info.Size, name: obj.Name}
+		if i > 0 {
+			f.Path = uniquePath(m.folder(obj.URL), used)
+		}
+		if f.Playlist {
+			playlistFiles = append(playlistFiles, f)
+		} else {
+			files = append(files, f)
+		}
+	}
+	// objects were walked backwards so the root playlist comes last
+	m.Files = append(files, playlistFiles...)
+	data, err := json.MarshalIndent(m, "", "  ")
+	return m, data, err
+}
+
+// uniquePath is p, or p with a number before its extension when another file
+// of the package already has it
+func uniquePath(p string, used map[string]bool) string {
+	unique := p
+	ext := path.Ext(p)
+	for n := 1; used[unique]; n++ {
+		unique = strings.TrimSuffix(p, ext) + "-" + strconv.Itoa(n) + ext
+	}
+	used[unique] = true
+	return unique
+}
+
+// exportFile adds a file to a package, playlists are rewritten to refer to
+// the files of the package
+func exportFile(pkg packageWriter, m *packageManifest, f packageFile, storage Storage, segmentURLPrefix string) error {
+	if !f.Playlist {
+		obj, err := storage.Get(f.name)
+		if err != nil {
+			return err
+		}
+		defer obj.Close()
+		return pkg.add(f.Path, f.size, obj)
+	}
+	data, err := readObject(storage, f.name)
+	if err != nil {
+		return err
+	}
+	paths := map[string]string{}
+	for _, other := range m.Files {
+		paths[other.URL] = other.Path
+	}
+	data, err = rewritePlaylist(m.Kind, data, func(uri string) string {
+		source := strings.TrimPrefix(uri, segmentURLPrefix)
+		if !isAbsoluteURL(source) {
+			return uri
+		}
+		target, ok := paths[source]
+		if !ok {
+			// a folder or template of a manifest
+			target = m.folder(source)
+		}
+		return relativePath(f.Path, target)
+	})
+	if err != nil {
+		return err
+	}
+	return pkg.add(f.Path, int64(len(data)), bytes.NewReader(data))
+}
+
+// Import caches the files of a package Export wrote, a folder, tar or zip
+// file, under the keys of the WithKeyStrategy option for segmentURLPrefix.
+// Playlists get their source urls back and are proxied through
+// segmentURLPrefix like downloaded ones. Every file must be the playlist or
+// manifest of the package or referenced by one. Stored objects are only
+// replaced when they belong to the cached entry of the package and no other
+// entry shares them, the others are kept as they are. A failed import
+// deletes the objects it created. With the WithCatalog option the entry is
+// cataloged. An "imported" DownloadStatus is published and the source url of
+// the package is returned
+func Import(src string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	pkg, err := openPackage(src)
+	if err != nil {
+		return "", err
+	}
+	defer pkg.Close()
+	m := &packageManifest{}
+	if err := readPackageFile(pkg, packageManifestName, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(m)
+	}); err != nil {
+		return "", err
+	}
+	sourceURL := mustParseURL(m.URL)
+	if sourceURL == nil || len(m.Files) == 0 || (m.Kind != EntryHLS && m.Kind != EntryDASH) {
+		return "", ErrBadPackage
+	}
+	hasPlaylist := false
+	for _, f := range m.Files {
+		if mustParseURL(f.URL) == nil || !validPackagePath(f.Path) {
+			return "", ErrBadPackage
+		}
+		hasPlaylist = hasPlaylist || (f.Playlist && f.URL == m.URL)
+	}
+	if !hasPlaylist {
+		return "", ErrBadPackage
+	}
+	replaceable, err := replaceableObjects(sourceURL, storage, segmentURLPrefix, o)
+	if err != nil {
+		return "", err
+	}
+	err = importFiles(pkg, m, storage, segmentURLPrefix, o, replaceable)
+	if err == nil && o.catalog != nil {
+		var objects []CatalogObject
+		objects, err = cachedObjects(sourceURL, storage, segmentURLPrefix, o.keys)
+		if err == nil {
+			err = o.catalog.record(m.Kind, sourceURL, objects, storage, segmentURLPrefix, o.sources, o.expires(sourceURL))
+		}
+	}
+	if err != nil {
+		if _, derr := deleteCreated(storage, o); derr != nil {
+			log.Debug.Printf("Rolling back import of %s %v", m.URL, derr)
+		}
+		return "", err
+	}
+	idf := idAndFile(sourceURL)
+	ds := DownloadStatus{URL: m.URL, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: o.key(segmentURLPrefix, sourceURL), Progress: "1", Status: "imported", Error: ""}
+	ps.Pub(ds, DownloadStatusChannel)
+	return m.URL, nil
+}
+
+// replaceableObjects lists the stored objects an import of sourceURL may
+// replace, those of its cached entry which no other entry shares
+func replaceableObjects(sourceURL *url.URL, storage Storage, segmentURLPrefix string, o *options) (map[string]bool, error) {
+	replaceable := map[string]bool{}
+	objects, err := cachedObjects(sourceURL, storage, segmentURLPrefix, o.keys)
+	if os.IsNotExist(err) {
+		return replaceable, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	shared, err := sharedObjects(objects, storage, segmentURLPrefix, o)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objects {
+		replaceable[obj.Name] = !shared[obj.Name]
+	}
+	return replaceable, nil
+}
+
+// importFiles stores the files of a package in the order they were written,
+// playlists last, and checks the playlists reference every file. Keys are
+// stored again with keyPerm once the playlists tell them apart
+func importFiles(pkg packageReader, m *packageManifest, storage Storage, segmentURLPrefix string, o *options, replaceable map[string]bool) error {
+	referenced := map[string]bool{m.URL: true}
+	keys := map[string]bool{}
+	for _, f := range m.Files {
+		name := o.key(segmentURLPrefix, mustParseURL(f.URL))
+		if _, ok := o.sources.get(name); !ok {
+			o.sources.keepStored(storage, name)
+		}
+		o.sources.add(name, nil)
+		// objects stored for other entries are not replaced
+		replace := o.sources.created(name) || replaceable[name]
+		err := readPackageFile(pkg, f.Path, func(r io.Reader) error {
+			if !f.Playlist {
+				if !replace {
+					return nil
+				}
+				return storage.Put(name, r)
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			data, err = rewritePlaylist(m.Kind, data, func(uri string) string {
+				return m.sourceURL(f.Path, uri)
+			})
+			if err != nil {
+				return err
+			}
+			if m.Kind == EntryDASH {
+				for _, u := range GetDASHSegmentURLS(data, "") {
+					referenced[u] = true
+				}
+			} else {
+				for _, u := range GetSegmentURLS(data, "") {
+					referenced[u] = true
+				}
+				for _, u := range GetKeyURLS(data, "") {
+					referenced[u], keys[u] = true, true
+				}
+			}
+			if !replace {
+				return nil
+			}
+			if m.Kind == EntryDASH {
+				return writeDASH(data, name, storage, segmentURLPrefix)
+			}
+			return writeHLS(data, name, storage, segmentURLPrefix)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range m.Files {
+		if !referenced[f.URL] {
+			return ErrBadPackage
+		}
+	}
+	if _, ok := storage.(modeStorage); !ok {
+		return nil
+	}
+	for u := range keys {
+		name := o.key(segmentURLPrefix, mustParseURL(u))
+		if !o.sources.created(name) && !replaceable[name] {
+			continue
+		}
+		data, err := readObject(storage, name)
+		if err == nil {
+			err = putMode(storage, name, bytes.NewReader(data), keyPerm)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageWriter writes the files of a package
+type packageWriter interface {
+	add(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+// createPackage creates a package at dst in format
+func createPackage(dst, format string) (packageWriter, error) {
+	switch format {
+	case PackageDir:
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return nil, err
+		}
+		return dirPackage(dst), nil
+	case PackageTar, PackageZip:
+		f, err := os.Create(dst)
+		if err != nil {
+			return nil, err
+		}
+		if format == PackageTar {
+			return &tarPackage{f: f, w: tar.NewWriter(f)}, nil
+		}
+		return &zipPackage{f: f, w: zip.NewWriter(f)}, nil
+	}
+	return nil, ErrBadPackage
+}
+
+type dirPackage string
+
+func (d dirPackage) add(name string, size int64, r io.Reader) error {
+	p := filepath.Join(string(d), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (d dirPackage) Close() error {
+	return nil
+}
+
+type tarPackage struct {
+	f *os.File
+	w *tar.Writer
+}
+
+func (t *tarPackage) add(name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := t.w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.w, r)
+	return err
+}
+
+func (t *tarPackage) Close() error {
+	err := t.w.Close()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type zipPackage struct {
+	f *os.File
+	w *zip.Writer
+}
+
+func (z *zipPackage) add(name string, size int64, r io.Reader) error {
+	w, err := z.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipPackage) Close() error {
+	err := z.w.Close()
+	if cerr := z.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// packageReader reads the files of a package
+type packageReader interface {
+	open(name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// readPackageFile passes file name of a package to read
+func readPackageFile(pkg packageReader, name string, read func(r io.Reader) error) error {
+	r, err := pkg.open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return read(r)
+}
+
+// openPackage opens a package folder, tar or zip file
+func openPackage(src string) (packageReader, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return dirPackage(src), nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err == nil && string(magic) == "PK\x03\x04" {
+		f.Close()
+		r, err := zip.OpenReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return zipReader{r}, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &tarReader{f: f, r: tar.NewReader(f)}, nil
+}
+
+func (d dirPackage) open(name string) (io.ReadCloser, error) {
+	if !validPackagePath(name) {
+		return nil, ErrBadPackage
+	}
+	return os.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// tarReader reads the files of a tar package in the order Export wrote them
+type tarReader struct {
+	f *os.File
+	r *tar.Reader
+}
+
+func (t *tarReader) open(name string) (io.ReadCloser, error) {
+	for {
+		hdr, err := t.r.Next()
+		if err == io.EOF {
+			return nil, notExist("open", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if hdr.Name != name {
+			return nil, ErrBadPackage
+		}
+		return ioutil.NopCloser(t.r), nil
+	}
+}
+
+func (t *tarReader) Close() error {
+	return t.f.Close()
+}
+
+type zipReader struct {
+	*zip.ReadCloser
+}
+
+func (z zipReader) open(name string) (io.ReadCloser, error) {
+	for _, f := range z.File {
+		if f.Name == name {
+			return f.Open()
+		}
+	}
+	return nil, notExist("open", name)
+}
@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestExportImport(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/master.m3u8":      "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nlow/index.m3u8\n",
+		"/hls/track_trd.mp4/low/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\n/other/segment-1.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/low/segment-1.ts": "aaaa",
+		"/other/segment-1.ts":                 "bb",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/master.m3u8"
+	storage := NewMemoryStorage()
+	if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	host := strings.Replace(mustParseURL(origin.URL).Host, ":", "_", -1)
+	tests := []struct {
+		name   string
+		format string
+		keys   KeyStrategy
+	}{
+		{"dir", PackageDir, LastPathSegmentsKey()},
+		{"tar", PackageTar, FullURLKey()},
+		{"zip", PackageZip, LastPathSegmentsKey()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(folder, "package."+tt.format)
+			if err := Export(url, storage, prefix, dst, tt.format); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			if tt.format == PackageDir {
+				data, _ := ioutil.ReadFile(filepath.Join(dst, host, "hls/track_trd.mp4/low/index.m3u8"))
+				if !bytes.Contains(data, []byte("\nsegment-1.ts\n")) || !bytes.Contains(data, []byte("\n../../../other/segment-1.ts\n")) {
+					t.Errorf("exported playlist = %s, want relative segments", data)
+				}
+				if _, err := os.Stat(filepath.Join(dst, "index.m3u8")); err != nil {
+					t.Errorf("exported package has no index.m3u8, error = %v", err)
+				}
+			}
+
+			imported := NewMemoryStorage()
+			ps := pubsub.New(10)
+			ch := ps.Sub(DownloadStatusChannel)
+			got, err := Import(dst, imported, prefix, ps, WithKeyStrategy(tt.keys))
+			if err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+			if got != url {
+				t.Errorf("Import() = %v, want %v", got, url)
+			}
+			select {
+			case msg := <-ch:
+				if ds := msg.(DownloadStatus); ds.Status != "imported" {
+					t.Errorf("Import() published %v, want imported", ds.Status)
+				}
+			case <-time.After(time.Second):
+				t.Error("Import() published no status")
+			}
+			report, err := Lookup(url, imported, prefix, WithKeyStrategy(tt.keys))
+			if err != nil || !report.Complete() || report.Segments != 3 {
+				t.Errorf("Lookup() after Import() = %+v, %v, want complete", report, err)
+			}
+		})
+	}
+}
+
+func TestExportNotCached(t *testing.T) {
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	err = Export("http://127.0.0.1:1/hls/track_trd.mp4/index.m3u8", NewMemoryStorage(), "", folder, PackageDir)
+	if err != ErrNotCached {
+		t.Errorf("Export() error = %v, want ErrNotCached", err)
+	}
+	if _, err := Import(folder, NewMemoryStorage(), "", pubsub.New(1)); err == nil {
+		t.Error("Import() of an empty folder succeeded, want an error")
+	}
+}
+
+func TestImportCraftedPackage(t *testing.T) {
+	segment := "/hls/other_trd.mp4/segment.ts"
+	origin := testOrigin(map[string]string{
+		"/hls/other_trd.mp4/index.m3u8": "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n",
+		segment:                         "aaaa",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	storage := NewMemoryStorage()
+	other := origin.URL + "/hls/other_trd.mp4/index.m3u8"
+	if err := DownloadHLSPlaylist(other, storage, prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	otherPlaylist, _ := readObject(storage, PrefixedHlsFilename(prefix, mustParseURL(other)))
+	url := origin.URL + "/hls/evil_trd.mp4/index.m3u8"
+	write := func(name, data string) {
+		path := filepath.Join(folder, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a/index.m3u8", "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n")
+	write("a/segment.ts", "evil")
+	write("a/new.ts", "evil")
+	write("a/other.m3u8", "#EXTM3U\n")
+	tests := []struct {
+		name    string
+		files   string
+		wantErr error
+	}{
+		{
+			"segment of another entry",
+			`{"path": "a/segment.ts", "url": "` + origin.URL + segment + `"}`,
+			nil,
+		},
+		{
+			"files the playlist does not reference",
+			`{"path": "a/new.ts", "url": "` + origin.URL + `/hls/evil_trd.mp4/new.ts"}, {"path": "a/other.m3u8", "url": "` + other + `"}`,
+			ErrBadPackage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(packageManifestName, `{"url": "`+url+`", "kind": "hls", "playlist": "a/index.m3u8", "origins": {}, "files": [`+tt.files+`, {"path": "a/index.m3u8", "url": "`+url+`", "playlist": true}]}`)
+			before, _ := storage.List()
+			_, err := Import(folder, storage, prefix, pubsub.New(1))
+			if err != tt.wantErr {
+				t.Fatalf("Import() error = %v, want %v", err, tt.wantErr)
+			}
+			if data, _ := readObject(storage, PrefixedHlsFilename(prefix, mustParseURL(origin.URL+segment))); string(data) != "aaaa" {
+				t.Errorf("segment of another entry = %q, want kept", data)
+			}
+			if data, _ := readObject(storage, PrefixedHlsFilename(prefix, mustParseURL(other))); !bytes.Equal(data, otherPlaylist) {
+				t.Errorf("playlist of another entry = %s, want kept", data)
+			}
+			if after, _ := storage.List(); err != nil && len(after) != len(before) {
+				t.Errorf("failed Import() left %v objects, want %v", len(after), len(before))
+			}
+		})
+	}
+}
@@ -4,82 +4,129 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
-	"regexp"
 	"strings"
-	"time"
-)
 
-var re2 = regexp.MustCompile(`((?:(?:https?|ftp|file)))`)
+	"github.com/osiloke/streaming/hls"
+)
 
 func hashKey(data string) string {
 	sha := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(sha[:])
 }
 
+func isAbsoluteURL(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.IsAbs()
+}
+
+// ResolveHLSUrls makes every uri in an hls playlist absolute using the url the
+// playlist was retrieved from
+func ResolveHLSUrls(hlsRaw []byte, playlistURL *url.URL) ([]byte, error) {
+	playlist, err := hls.Parse(hlsRaw)
+	if err != nil {
+		return nil, err
+	}
+	hls.ResolveURIs(playlist, playlistURL)
+	return playlist.Encode(), nil
+}
+
 // ProxyHLSUrls replace hls urls
 func ProxyHLSUrls(hlsRaw []byte, proxyServerURL string) ([]byte, error) {
-	s := re2.ReplaceAllString(string(hlsRaw), fmt.Sprintf(`%s$1`, proxyServerURL))
-	return []byte(s), nil
+	playlist, err := hls.Parse(hlsRaw)
+	if err != nil {
+		return nil, err
+	}
+	playlist.Rewrite(func(uri string) string {
+		if !isAbsoluteURL(uri) || strings.HasPrefix(uri, proxyServerURL) {
+			return uri
+		}
+		return proxyServerURL + uri
+	})
+	return playlist.Encode(), nil
 }
 
-var re = regexp.MustCompile(`(http|ftp|https)://([\w_-]+(?:(?:\.[\w_-]+)+))([\w.,@?^=%&:/~+#-]*[\w@?^=%&/~+#-])?`)
+// lastPathSegments is the folder and file the path of url ends with, the
+// folder is empty for paths without one
+func lastPathSegments(url *url.URL) (string, string) {
+	pt := strings.Split(url.Path, "/")
+	if len(pt) < 2 {
+		return "", pt[0]
+	}
+	return pt[len(pt)-2], pt[len(pt)-1]
+}
 
 func idAndFile(url *url.URL) []string {
-	pt := strings.Split(url.Path, "/")
-	id := pt[len(pt)-2]
-	hlspart := pt[len(pt)-1]
+	id, hlspart := lastPathSegments(url)
 	return []string{strings.Split(id, "_trd")[0], hlspart}
 }
 func hlsFilename(url *url.URL) string {
-	pt := strings.Split(url.Path, "/")
-	filename := fmt.Sprintf("%s%s", pt[len(pt)-2], pt[len(pt)-1])
+	dir, file := lastPathSegments(url)
+	filename := fmt.Sprintf("%s%s", dir, file)
 	return hashKey(filename)
 }
 
 // PrefixedHlsFilename generate hashed url with prefix
 func PrefixedHlsFilename(prefix string, url *url.URL) string {
-	pt := strings.Split(url.Path, "/")
-	filename := fmt.Sprintf("%s%s", pt[len(pt)-2], pt[len(pt)-1])
+	dir, file := lastPathSegments(url)
+	filename := fmt.Sprintf("%s%s", dir, file)
 	return hashKey(prefix + filename)
 }
 
-// GetSegmentURLS get all segment urls
+// parsePlaylist parses hlsRaw, returning nil when it is not a playlist
+func parsePlaylist(hlsRaw []byte) hls.Playlist {
+	playlist, err := hls.Parse(hlsRaw)
+	if err != nil {
+		return nil
+	}
+	return playlist
+}
+
+// GetSegmentURLS get all segment urls, including EXT-X-MAP initialization
+// segments and EXT-X-PART partial segments, each url is only listed once and
+// segmentURLPrefix is stripped from proxied urls. For a master playlist the
+// variant and rendition playlist urls are returned
 func GetSegmentURLS(hlsRaw []byte, segmentURLPrefix string) []string {
-	s := re.FindAllString(string(hlsRaw), -1)
 	found := make([]string, 0)
-	for _, v := range s {
-		found = append(found, v)
+	switch p := parsePlaylist(hlsRaw).(type) {
+	case *hls.MediaPlaylist:
+		seen := map[string]bool{}
+		add := func(uri string) {
+			uri = strings.TrimPrefix(uri, segmentURLPrefix)
+			if !seen[uri] {
+				seen[uri] = true
+				found = append(found, uri)
+			}
+		}
+		for _, s := range p.Segments {
+			if s.Map != nil {
+				add(s.Map.URI)
+			}
+			for _, part := range s.Parts {
+				add(part.URI)
+			}
+			add(s.URI)
+		}
+		for _, part := range p.Parts {
+			add(part.URI)
+		}
+	case *hls.MasterPlaylist:
+		for _, v := range p.Variants {
+			found = append(found, strings.TrimPrefix(v.URI, segmentURLPrefix))
+		}
+		for _, m := range p.Media {
+			if m.URI != "" {
+				found = append(found, strings.TrimPrefix(m.URI, segmentURLPrefix))
+			}
+		}
 	}
 	return found
 }
 
-func randate() time.Time {
-	min := time.Date(1987, 1, 0, 0, 0, 0, 0, time.UTC).Unix()
-	max := time.Now().Unix()
-	delta := max - min
-
-	sec := rand.Int63n(delta) + min
-	return time.Unix(sec, 0)
-}
-
-func completeSegmentDownload(ds *DownloadStatus) {
-	base := filepath.Dir(ds.TempFilename)
-	filename := PrefixedHlsFilename(ds.Prefix, mustParseURL(ds.URL))
-	name := filepath.Join(base, filename)
-	// os.Rename(ds.TempFilename, name)
-	currenttime := randate()
-	os.Chtimes(name, currenttime, currenttime)
-}
-
-func segmentExists(url, folder string) bool {
-	filename := hlsFilename(mustParseURL(url))
-	dst := filepath.Join(folder, filename)
-	if _, err := os.Stat(dst); os.IsNotExist(err) {
-		return false
-	}
-	return true
+// completeSegmentDownload notes the validators the origin sent for a
+// downloaded segment
+func completeSegmentDownload(ds *DownloadStatus, header http.Header, o *options) {
+	filename := o.key(ds.Prefix, mustParseURL(ds.URL))
+	o.sources.add(filename, header)
 }
@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// keyPerm keeps cached keys private to the user running the downloader
+const keyPerm = 0600
+
+// GetKeyURLS get the urls of the keys used to decrypt a media playlist,
+// segmentURLPrefix is stripped from proxied urls. Keys which can not be
+// fetched over http (e.g. skd:// for FairPlay) are left out
+func GetKeyURLS(hlsRaw []byte, segmentURLPrefix string) []string {
+	found := make([]string, 0)
+	playlist, ok := parsePlaylist(hlsRaw).(*hls.MediaPlaylist)
+	if !ok {
+		return found
+	}
+	seen := map[string]bool{}
+	for _, s := range playlist.Segments {
+		for _, key := range s.Keys {
+			if key.Method == hls.KeyMethodNone || key.URI == "" {
+				continue
+			}
+			keyURL := strings.TrimPrefix(key.URI, segmentURLPrefix)
+			if u := mustParseURL(keyURL); u == nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			if !seen[keyURL] {
+				seen[keyURL] = true
+				found = append(found, keyURL)
+			}
+		}
+	}
+	return found
+}
+
+// DownloadKeyURLs downloads the encryption keys of a playlist, keys are only
+// readable by their owner
+func DownloadKeyURLs(urls []string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	return downloadKeyURLs(urls, storage, segmentURLPrefix, ps, newOptions(opts))
+}
+
+func downloadKeyURLs(urls []string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, o *options) error {
+	for _, url := range urls {
+		if err := o.ctx.Err(); err != nil {
+			return err
+		}
+		keyURL := mustParseURL(url)
+		filename := o.key(segmentURLPrefix, keyURL)
+		dst := objectPath(storage, filename)
+		o.use(filename)
+		if _, err := storage.Stat(filename); !os.IsNotExist(err) {
+			continue
+		}
+		idf := idAndFile(keyURL)
+		header, err := downloadKey(o.ctx, url, filename, storage)
+		if err != nil {
+			ps.Pub(DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: "0", Status: "error downloading key", Error: err.Error()}, DownloadStatusChannel)
+			return err
+		}
+		o.sources.add(filename, header)
+		ps.Pub(DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: "1", Status: "downloaded key", Error: ""}, DownloadStatusChannel)
+	}
+	log.Debug.Printf("Downloaded %v keys\n", len(urls))
+	return nil
+}
+
+// downloadKey stores a key readable only by its owner and returns the
+// response header of its origin
+func downloadKey(ctx context.Context, url, filename string, storage Storage) (http.Header, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("unable to retrieve key")
+	}
+	key, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Header, putMode(storage, filename, bytes.NewReader(key), keyPerm)
+}
@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestGetKeyURLS(t *testing.T) {
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	hlsRaw := []byte(`#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-KEY:METHOD=AES-128,URI="` + prefix + `https://keys.example.com/a/key1"
+#EXTINF:10,
+segment-1.ts
+#EXTINF:10,
+segment-2.ts
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/a/key2"
+#EXT-X-KEY:METHOD=SAMPLE-AES,URI="skd://fairplay",KEYFORMAT="com.apple.streamingkeydelivery"
+#EXTINF:10,
+segment-3.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:10,
+segment-4.ts
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/a/key1"
+#EXTINF:10,
+segment-5.ts
+`)
+	want := []string{"https://keys.example.com/a/key1", "https://keys.example.com/a/key2"}
+	if got := GetKeyURLS(hlsRaw, prefix); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetKeyURLS() = %v, want %v", got, want)
+	}
+}
+
+func TestDownloadHLSPlaylistKeys(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8": `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/track/key1"
+#EXTINF:10,
+segment-1.ts
+#EXT-X-KEY:METHOD=AES-128,URI="/keys/track/key2",IV=0x1
+#EXTINF:10,
+segment-2.ts
+#EXT-X-ENDLIST
+`,
+		"/hls/track_trd.mp4/segment-1.ts": "one",
+		"/hls/track_trd.mp4/segment-2.ts": "two",
+		"/keys/track/key1":                "0123456789abcdef",
+		"/keys/track/key2":                "fedcba9876543210",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+
+	if err := DownloadHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	for _, key := range []string{"/keys/track/key1", "/keys/track/key2"} {
+		dst := filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(origin.URL+key)))
+		info, err := os.Stat(dst)
+		if err != nil {
+			t.Fatalf("key %s not cached: %v", key, err)
+		}
+		if perm := info.Mode().Perm(); perm != keyPerm {
+			t.Errorf("key %s permissions = %v, want %v", key, perm, os.FileMode(keyPerm))
+		}
+	}
+	files, err := GetHLSSegments(mustParseURL(url), NewFileStorage(folder), prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Errorf("GetHLSSegments() = %v, want playlist, 2 segments and 2 keys", files)
+	}
+	if err := RemoveHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if left, _ := ioutil.ReadDir(folder); len(left) != 0 {
+		t.Errorf("RemoveHLSPlaylist() left %d files", len(left))
+	}
+}
+
+func TestDownloadKeyURLs(t *testing.T) {
+	origin := testOrigin(map[string]string{"/keys/track/key1": "0123456789abcdef"})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/keys/track/key1"
+	contents := NewFileStorage(filepath.Join(folder, "contents"))
+	os.Mkdir(contents.Dir(), 0755)
+	storage, err := OpenContentStorage(contents, filepath.Join(folder, "content.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := downloadKey(ctx, url, "key1", storage); err == nil {
+		t.Errorf("downloadKey() with a canceled context error = nil")
+	}
+
+	if err := DownloadKeyURLs([]string{url}, storage, prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("DownloadKeyURLs() error = %v", err)
+	}
+	files, _ := ioutil.ReadDir(contents.Dir())
+	if len(files) != 1 {
+		t.Fatalf("stored %d contents, want the key", len(files))
+	}
+	if perm := files[0].Mode().Perm(); perm != keyPerm {
+		t.Errorf("key content permissions = %v, want %v", perm, os.FileMode(keyPerm))
+	}
+}
@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// KeyStrategy names the stored object of a url cached with a segment url
+// prefix. Urls given the same key are the same object of the cache, so a
+// strategy leaving out per-session tokens lets a track requested again with
+// a fresh token be found in the cache
+type KeyStrategy interface {
+	Key(segmentURLPrefix string, u *url.URL) string
+}
+
+// KeyStrategyFunc adapts a function to a KeyStrategy
+type KeyStrategyFunc func(segmentURLPrefix string, u *url.URL) string
+
+// Key calls f(segmentURLPrefix, u)
+func (f KeyStrategyFunc) Key(segmentURLPrefix string, u *url.URL) string {
+	return f(segmentURLPrefix, u)
+}
+
+// LastPathSegmentsKey keys urls by their last two path segments as
+// PrefixedHlsFilename does, it is the default so caches written before key
+// strategies existed stay valid. Unrelated urls ending in the same two
+// segments share a key
+func LastPathSegmentsKey() KeyStrategy {
+	return KeyStrategyFunc(PrefixedHlsFilename)
+}
+
+// FullURLKey keys urls by the whole url
+func FullURLKey() KeyStrategy {
+	return KeyStrategyFunc(func(segmentURLPrefix string, u *url.URL) string {
+		return hashKey(segmentURLPrefix + u.String())
+	})
+}
+
+// WithoutQueryKey keys urls by the url without its query and fragment
+func WithoutQueryKey() KeyStrategy {
+	return KeyStrategyFunc(func(segmentURLPrefix string, u *url.URL) string {
+		return hashKey(segmentURLPrefix + u.Scheme + "://" + u.Host + u.EscapedPath())
+	})
+}
+
+// JWTPattern matches path segments or query values which are JSON Web
+// Tokens
+var JWTPattern = regexp.MustCompile(`^eyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*$`)
+
+// TokenPatterns selects the parts of urls StripTokensKey leaves out
+type TokenPatterns struct {
+	// Path matches the path segments left out
+	Path []*regexp.Regexp
+	// Query matches the lower case names of the query parameters left out
+	Query []*regexp.Regexp
+}
+
+// DefaultTokenPatterns leaves out JWT path segments and the query
+// parameters usually carrying CDN tokens
+func DefaultTokenPatterns() TokenPatterns {
+	return TokenPatterns{
+		Path:  []*regexp.Regexp{JWTPattern},
+		Query: []*regexp.Regexp{regexp.MustCompile(`^(ut|token|hdnts|__token__|exp|expires|signature|sig|policy|key-pair-id|x-amz-.*)$`)},
+	}
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, p := range patterns {
+		if p.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripTokensKey keys urls by the whole url without the path segments and
+// query parameters patterns matches
+func StripTokensKey(patterns TokenPatterns) KeyStrategy {
+	return KeyStrategyFunc(func(segmentURLPrefix string, u *url.URL) string {
+		segments := []string{}
+		for _, segment := range strings.Split(u.EscapedPath(), "/") {
+			if !matchesAny(patterns.Path, segment) {
+				segments = append(segments, segment)
+			}
+		}
+		query := u.Query()
+		for name := range query {
+			if matchesAny(patterns.Query, strings.ToLower(name)) {
+				query.Del(name)
+			}
+		}
+		key := u.Scheme + "://" + u.Host + strings.Join(segments, "/")
+		if len(query) > 0 {
+			// Encode sorts the parameters so their order does not matter
+			key += "?" + query.Encode()
+		}
+		return hashKey(segmentURLPrefix + key)
+	})
+}
@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestKeyStrategies(t *testing.T) {
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	track := "https://a.com/hls/eyJhbGciOiJIUzI1NiJ9.eyJleHAiOjE1NjE0NTg1NTJ9.c2ln/IbPuk_trd.mp4/index.m3u8?ut=st=1~exp=2~hmac=3&v=1"
+	freshToken := "https://a.com/hls/eyJhbGciOiJIUzI1NiJ9.eyJleHAiOjE1NjE0NjAwMDB9.b3RoZXI/IbPuk_trd.mp4/index.m3u8?v=1&ut=st=4~exp=5~hmac=6"
+	otherQuery := "https://a.com/hls/eyJhbGciOiJIUzI1NiJ9.eyJleHAiOjE1NjE0NTg1NTJ9.c2ln/IbPuk_trd.mp4/index.m3u8?ut=st=4~exp=5~hmac=6&v=1"
+	otherTrack := "https://b.com/other/IbPuk_trd.mp4/index.m3u8"
+	tests := []struct {
+		name     string
+		keys     KeyStrategy
+		url      string
+		sameAs   string
+		wantSame bool
+	}{
+		{"last path segments collide", LastPathSegmentsKey(), track, otherTrack, true},
+		{"full url with fresh query", FullURLKey(), track, otherQuery, false},
+		{"without query with fresh query", WithoutQueryKey(), track, otherQuery, true},
+		{"without query with fresh token", WithoutQueryKey(), track, freshToken, false},
+		{"strip tokens with fresh token", StripTokensKey(DefaultTokenPatterns()), track, freshToken, true},
+		{"strip tokens other track", StripTokensKey(DefaultTokenPatterns()), track, otherTrack, false},
+		{"custom", KeyStrategyFunc(func(prefix string, _ *url.URL) string { return prefix }), track, otherTrack, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.keys.Key(prefix, mustParseURL(tt.url))
+			other := tt.keys.Key(prefix, mustParseURL(tt.sameAs))
+			if (got == other) != tt.wantSame {
+				t.Errorf("Key() = %v and %v, want same %v", got, other, tt.wantSame)
+			}
+		})
+	}
+	if got, want := LastPathSegmentsKey().Key(prefix, mustParseURL(track)), PrefixedHlsFilename(prefix, mustParseURL(track)); got != want {
+		t.Errorf("LastPathSegmentsKey() = %v, want %v", got, want)
+	}
+}
+
+func TestDownloadHLSPlaylistKeyStrategy(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/eyJhIjoxfQ.eyJleHAiOjF9.c2ln/track_trd.mp4/index.m3u8": "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n",
+		"/hls/eyJhIjoxfQ.eyJleHAiOjF9.c2ln/track_trd.mp4/segment.ts": "a",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	keys := StripTokensKey(DefaultTokenPatterns())
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"), WithKeyStrategy(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	source := origin.URL + "/hls/eyJhIjoxfQ.eyJleHAiOjF9.c2ln/track_trd.mp4/index.m3u8?token=1"
+	fresh := origin.URL + "/hls/eyJhIjoxfQ.eyJleHAiOjJ9.b3RoZXI/track_trd.mp4/index.m3u8?token=2"
+
+	if err := DownloadHLSPlaylist(source, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	if _, err := storage.Stat(keys.Key(prefix, mustParseURL(fresh))); err != nil {
+		t.Errorf("playlist not stored by its key, error = %v", err)
+	}
+	if _, err := catalog.Entry(fresh, prefix); err != nil {
+		t.Errorf("Entry() with a fresh token error = %v", err)
+	}
+	w := httptest.NewRecorder()
+	NewProxy(storage, prefix, WithCatalog(catalog)).ServeHTTP(w, httptest.NewRequest("GET", prefix+fresh, nil))
+	if w.Code != 200 {
+		t.Errorf("proxy response with a fresh token = %d, want 200", w.Code)
+	}
+	if err := RemoveHLSPlaylist(fresh, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if objects, _ := storage.List(); len(objects) != 0 {
+		t.Errorf("objects left after RemoveHLSPlaylist() = %v", objects)
+	}
+}
@@ -0,0 +1,338 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// RecordHLSPlaylist records a live HLS playlist. The media playlist is
+// reloaded every target duration and newly published segments are downloaded
+// into an accumulating cached playlist. Recording stops when ctx is done, when
+// the duration set with WithMaxDuration has been recorded or when the origin
+// ends the stream, the cached playlist is then closed as a VOD playlist. For a
+// master playlist the first variant picked by the VariantSelector is recorded.
+// Low-Latency HLS origins are reloaded with blocking requests and their
+// partial segments are recorded as they are published, so the cached playlist
+// can be relayed close to the live edge until it is closed. With the
+// WithTracker option a removal of the playlist waits for the recording or
+// cancels it
+func RecordHLSPlaylist(ctx context.Context, url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) error {
+	sourceURL := mustParseURL(url)
+	idf := idAndFile(sourceURL)
+	o := newOptions(opts)
+	r := &liveRecorder{
+		sourceURL: sourceURL,
+		mediaURL:  sourceURL,
+		storage:   storage,
+		prefix:    segmentURLPrefix,
+		filename:  o.key(segmentURLPrefix, sourceURL),
+		ps:        ps,
+		client:    grab.NewClient(),
+		o:         o,
+	}
+	downloadCtx, download, done := o.tracker.begin(context.Background(), r.filename)
+	defer done()
+	o.ctx, o.download = downloadCtx, download
+	o.sources.keepStored(storage, r.filename)
+	recordCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		// a removal canceling the download stops the recording
+		select {
+		case <-downloadCtx.Done():
+			stop()
+		case <-recordCtx.Done():
+		}
+	}()
+	err := o.canceled(r.record(recordCtx))
+	if err == nil && (r.playlist == nil || len(r.playlist.Segments) == 0) {
+		// stopped before anything was recorded
+		err = ErrNotRecorded
+	}
+	if err == nil && r.o.catalog != nil {
+		err = catalogHLS(r.o, sourceURL, storage, segmentURLPrefix)
+	}
+	if err == nil {
+		err = enforceQuota(r.o, storage, ps, r.filename)
+	}
+	if err != nil {
+		ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: r.filename, Progress: r.progress(), Status: "failed hls", Error: err.Error()}
+		if isOutOfSpace(err) {
+			ds.Status = "out of space"
+			err = outOfSpace(err, url, storage, segmentURLPrefix, o)
+		}
+		ps.Pub(ds, DownloadStatusChannel)
+
+		log.Debug.Printf("RecordHLSPlaylist %v", err)
+		return err
+	}
+	ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: r.filename, Progress: r.progress(), Status: "downloaded hls", Error: ""}
+	ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
+
+type liveRecorder struct {
+	sourceURL *url.URL
+	mediaURL  *url.URL
+	storage   Storage
+	prefix    string
+	filename  string
+	ps        *pubsub.PubSub
+	client    *grab.Client
+	o         *options
+
+	// playlist accumulates every recorded segment
+	playlist     *hls.MediaPlaylist
+	nextSequence uint64
+	duration     float64
+	keys         []*hls.Key
+	initMap      *hls.Map
+
+	// reloadURL requests a blocking reload of mediaURL when supported
+	reloadURL *url.URL
+	// partSequence is the media sequence number of the segment whose
+	// partial segments have been recorded, partCount how many of them
+	partSequence uint64
+	partCount    int
+	// parts holds the resources recorded for partial segments
+	parts map[string]bool
+}
+
+func (r *liveRecorder) progress() string {
+	return fmt.Sprintf("%.3f", r.duration)
+}
+
+func (r *liveRecorder) record(ctx context.Context) error {
+	for {
+		if ctx.Err() != nil {
+			break
+		}
+		p, err := r.fetch(ctx)
+		if err != nil && ctx.Err() != nil {
+			// stopped while reloading
+			break
+		}
+		if err != nil {
+			return err
+		}
+		added, err := r.update(p)
+		if err != nil {
+			return err
+		}
+		if p.EndList || r.limitReached() {
+			break
+		}
+		if r.reloadURL != nil && added > 0 {
+			// the origin holds the next reload until there is something new
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(reloadDelay(p, added)):
+		}
+	}
+	return r.finish()
+}
+
+// fetch retrieves the media playlist being recorded, a blocking reload is
+// given up when ctx is done
+func (r *liveRecorder) fetch(ctx context.Context) (*hls.MediaPlaylist, error) {
+	reloadURL := r.mediaURL
+	if r.reloadURL != nil {
+		reloadURL = r.reloadURL
+	}
+	content, err := fetchHLS(ctx, reloadURL)
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := hls.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	if master, ok := playlist.(*hls.MasterPlaylist); ok {
+		if r.mediaURL != r.sourceURL {
+			return nil, errors.New("variant is not a media playlist")
+		}
+		variants := r.o.variants.SelectVariants(master.Variants)
+		if len(variants) == 0 {
+			return nil, ErrNoVariant
+		}
+		r.mediaURL = mustParseURL(variants[0].URI)
+		return r.fetch(ctx)
+	}
+	return playlist.(*hls.MediaPlaylist), nil
+}
+
+// reloadDelay is the time to wait before reloading a live playlist as
+// described in RFC 8216 section 6.3.4
+func reloadDelay(p *hls.MediaPlaylist, added int) time.Duration {
+	delay := time.Duration(p.TargetDuration) * time.Second
+	if added == 0 {
+		delay /= 2
+	}
+	if delay <= 0 {
+		delay = time.Second
+	}
+	return delay
+}
+
+func (r *liveRecorder) limitReached() bool {
+	return r.o.maxDuration > 0 && r.duration >= r.o.maxDuration.Seconds()
+}
+
+// update downloads the segments and partial segments of p which have not been
+// recorded yet and stores the accumulated playlist, it returns the number of
+// new segments and partial segments
+func (r *liveRecorder) update(p *hls.MediaPlaylist) (int, error) {
+	p.ResolveByteRanges()
+	started := r.playlist != nil
+	if !started {
+		r.playlist = &hls.MediaPlaylist{
+			Version:               p.Version,
+			TargetDuration:        p.TargetDuration,
+			MediaSequence:         p.MediaSequence,
+			DiscontinuitySequence: p.DiscontinuitySequence,
+			IndependentSegments:   p.IndependentSegments,
+			PlaylistType:          hls.PlaylistTypeEvent,
+		}
+		r.nextSequence = p.MediaSequence
+		r.parts = map[string]bool{}
+	}
+	if p.ServerControl != nil && p.ServerControl.CanBlockReload && !p.EndList {
+		r.reloadURL = blockingReloadURL(r.mediaURL, p)
+	} else {
+		r.reloadURL = nil
+	}
+	last := p.MediaSequence + uint64(len(p.Segments))
+	// the origin restarted its sequence numbers or skipped segments we never
+	// saw, either way the timeline is broken before the first new segment
+	reset := started && last < r.nextSequence
+	gap := started && p.MediaSequence > r.nextSequence
+
+	added := []*hls.Segment{}
+	var keys []*hls.Key
+	var initMap *hls.Map
+	for i, s := range p.Segments {
+		if len(s.Keys) > 0 {
+			keys = s.Keys
+		}
+		if s.Map != nil {
+			initMap = s.Map
+		}
+		if !reset && p.MediaSequence+uint64(i) < r.nextSequence {
+			continue
+		}
+		if r.limitReached() {
+			break
+		}
+		seg := *s
+		seg.Keys, seg.Map = nil, nil
+		if !reflect.DeepEqual(keys, r.keys) {
+			seg.Keys, r.keys = keys, keys
+		}
+		if initMap != nil && !reflect.DeepEqual(initMap, r.initMap) {
+			seg.Map, r.initMap = initMap, initMap
+		}
+		if len(added) == 0 && (reset || gap) && len(r.playlist.Segments) > 0 {
+			seg.Discontinuity = true
+		}
+		added = append(added, &seg)
+		r.duration += seg.Duration
+	}
+	if reset || last > r.nextSequence {
+		r.nextSequence = last
+	}
+	parts := r.newParts(p, last, reset)
+	if len(added) == 0 && len(parts) == 0 {
+		return 0, nil
+	}
+	if p.TargetDuration > r.playlist.TargetDuration {
+		r.playlist.TargetDuration = p.TargetDuration
+	}
+	for _, s := range added {
+		if r.parts[s.URI] {
+			// the segment resource was only partly fetched from its parts
+			r.storage.Delete(r.o.key(r.prefix, mustParseURL(s.URI)))
+			delete(r.parts, s.URI)
+		}
+	}
+	window := &hls.MediaPlaylist{TargetDuration: p.TargetDuration, Segments: added, Parts: parts}
+	if err := downloadMediaSegments(window.Encode(), r.storage, r.prefix, r.ps, r.client, r.o); err != nil {
+		return 0, err
+	}
+	for _, part := range parts {
+		r.parts[part.URI] = true
+	}
+	r.playlist.Segments = append(r.playlist.Segments, added...)
+	r.playlist.Parts = p.Parts
+	if p.PartInf != nil && !r.limitReached() {
+		r.playlist.PartInf = p.PartInf
+		r.playlist.ServerControl = &hls.ServerControl{CanBlockReload: true}
+		if p.ServerControl != nil {
+			r.playlist.ServerControl.HoldBack = p.ServerControl.HoldBack
+			r.playlist.ServerControl.PartHoldBack = p.ServerControl.PartHoldBack
+		}
+		if p.Version > r.playlist.Version {
+			r.playlist.Version = p.Version
+		}
+	}
+	if err := writeHLS(r.playlist.Encode(), r.filename, r.storage, r.prefix); err != nil {
+		return 0, err
+	}
+	idf := idAndFile(r.sourceURL)
+	ds := DownloadStatus{URL: r.sourceURL.String(), ID: idf[0], Segment: idf[1], Prefix: r.prefix, TempFilename: r.filename, Progress: r.progress(), Status: "recording hls", Error: ""}
+	r.ps.Pub(ds, DownloadStatusChannel)
+	return len(added) + len(parts), nil
+}
+
+// newParts returns the partial segments of the segment p is still publishing
+// which have not been recorded yet
+func (r *liveRecorder) newParts(p *hls.MediaPlaylist, last uint64, reset bool) []*hls.Part {
+	if r.limitReached() {
+		return nil
+	}
+	if reset || last != r.partSequence {
+		r.partSequence, r.partCount = last, 0
+	}
+	if len(p.Parts) <= r.partCount {
+		return nil
+	}
+	parts := p.Parts[r.partCount:]
+	r.partCount = len(p.Parts)
+	return parts
+}
+
+// finish closes the accumulated playlist as a VOD playlist, partial segments
+// are dropped as every complete segment has been recorded. Nothing is written
+// when no complete segment was recorded
+func (r *liveRecorder) finish() error {
+	if r.playlist == nil {
+		return nil
+	}
+	stripParts(r.playlist)
+	for _, s := range r.playlist.Segments {
+		delete(r.parts, s.URI)
+	}
+	if len(r.playlist.Segments) == 0 && len(r.parts) > 0 {
+		// the playlist written for partial segments goes with them
+		r.storage.Delete(r.filename)
+	}
+	for uri := range r.parts {
+		r.storage.Delete(r.o.key(r.prefix, mustParseURL(uri)))
+	}
+	if len(r.playlist.Segments) == 0 {
+		return nil
+	}
+	r.playlist.PlaylistType = hls.PlaylistTypeVOD
+	r.playlist.EndList = true
+	return writeHLS(r.playlist.Encode(), r.filename, r.storage, r.prefix)
+}
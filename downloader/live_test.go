@@ -0,0 +1,243 @@
+package downloader
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+)
+
+// liveOrigin serves a different playlist on every reload, the last one is
+// repeated, and any other path as a segment
+func liveOrigin(playlists ...string) *httptest.Server {
+	var mu sync.Mutex
+	reloads := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".m3u8") {
+			w.Write([]byte(r.URL.Path))
+			return
+		}
+		mu.Lock()
+		i := reloads
+		if i >= len(playlists) {
+			i = len(playlists) - 1
+		}
+		reloads++
+		mu.Unlock()
+		w.Write([]byte(playlists[i]))
+	}))
+}
+
+func recordedPlaylist(t *testing.T, folder, prefix, url string) *hls.MediaPlaylist {
+	data, err := ioutil.ReadFile(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(url))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := hls.ParseMedia(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func segmentNames(p *hls.MediaPlaylist) []string {
+	names := []string{}
+	for _, s := range p.Segments {
+		name := s.URI[strings.LastIndex(s.URI, "/")+1:]
+		if s.Discontinuity {
+			name = "|" + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestRecordHLSPlaylist(t *testing.T) {
+	origin := liveOrigin(
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:10\n#EXTINF:1,\na.ts\n#EXTINF:1,\nb.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:11\n#EXTINF:1,\nb.ts\n#EXTINF:1,\nc.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1,\nx.ts\n",
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1,\nx.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1,\ny.ts\n#EXT-X-ENDLIST\n",
+	)
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/live/radio_trd/index.m3u8"
+
+	if err := RecordHLSPlaylist(context.Background(), url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RecordHLSPlaylist() error = %v", err)
+	}
+	p := recordedPlaylist(t, folder, prefix, url)
+	if got, want := strings.Join(segmentNames(p), ","), "a.ts,b.ts,c.ts,|x.ts,|y.ts"; got != want {
+		t.Errorf("recorded segments = %s, want %s", got, want)
+	}
+	if !p.EndList || p.PlaylistType != hls.PlaylistTypeVOD || p.MediaSequence != 10 {
+		t.Errorf("recorded playlist is not a VOD = %s", p.Encode())
+	}
+	files, _ := GetHLSSegments(mustParseURL(url), NewFileStorage(folder), prefix)
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(folder, f)); err != nil {
+			t.Errorf("missing recorded file %v", err)
+		}
+	}
+}
+
+func TestRecordHLSPlaylistStops(t *testing.T) {
+	origin := liveOrigin(
+		"#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:1,\na.ts\n#EXTINF:1,\nb.ts\n#EXTINF:1,\nc.ts\n",
+	)
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/live/radio_trd/index.m3u8"
+
+	err = RecordHLSPlaylist(context.Background(), url, NewFileStorage(folder), prefix, pubsub.New(1), WithMaxDuration(2*time.Second))
+	if err != nil {
+		t.Fatalf("RecordHLSPlaylist() error = %v", err)
+	}
+	if got := segmentNames(recordedPlaylist(t, folder, prefix, url)); len(got) != 2 {
+		t.Errorf("recorded segments = %v, want 2", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	url = origin.URL + "/live/other_trd/index.m3u8"
+	if err := RecordHLSPlaylist(ctx, url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RecordHLSPlaylist() error = %v", err)
+	}
+	if p := recordedPlaylist(t, folder, prefix, url); !p.EndList || len(p.Segments) != 3 {
+		t.Errorf("cancelled recording = %s", p.Encode())
+	}
+}
+
+func TestRecordHLSPlaylistLowLatency(t *testing.T) {
+	header := "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-VERSION:6\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.6\n#EXT-X-PART-INF:PART-TARGET=0.5\n#EXT-X-MEDIA-SEQUENCE:1\n"
+	origin := liveOrigin(
+		header+"#EXTINF:1,\na.mp4\n#EXT-X-PART:DURATION=0.5,URI=\"b.mp4\",BYTERANGE=\"3@0\"\n",
+		header+"#EXTINF:1,\na.mp4\n#EXT-X-PART:DURATION=0.5,URI=\"b.mp4\",BYTERANGE=\"3@0\"\n#EXT-X-PART:DURATION=0.5,URI=\"b.mp4\",BYTERANGE=\"3\"\n#EXTINF:1,\nb.mp4\n#EXT-X-PART:DURATION=0.5,URI=\"c.0.mp4\"\n",
+		header+"#EXTINF:1,\na.mp4\n#EXTINF:1,\nb.mp4\n#EXTINF:1,\nc.mp4\n#EXT-X-ENDLIST\n",
+	)
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/live/radio_trd/index.m3u8"
+
+	start := time.Now()
+	if err := RecordHLSPlaylist(context.Background(), url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RecordHLSPlaylist() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("blocking reloads waited %v", elapsed)
+	}
+	p := recordedPlaylist(t, folder, prefix, url)
+	if got, want := strings.Join(segmentNames(p), ","), "a.mp4,b.mp4,c.mp4"; got != want {
+		t.Errorf("recorded segments = %s, want %s", got, want)
+	}
+	if p.ServerControl != nil || p.PartInf != nil || len(p.Parts) > 0 || len(p.Segments[1].Parts) > 0 {
+		t.Errorf("recorded VOD keeps partial segments = %s", p.Encode())
+	}
+	cached, err := ioutil.ReadFile(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/live/radio_trd/b.mp4"))))
+	if err != nil || string(cached) != "/live/radio_trd/b.mp4" {
+		t.Errorf("segment written from parts = %q, %v", cached, err)
+	}
+	if _, err := os.Stat(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/live/radio_trd/c.0.mp4")))); !os.IsNotExist(err) {
+		t.Errorf("partial segment file kept after recording, %v", err)
+	}
+}
+
+func TestRecordHLSPlaylistStopped(t *testing.T) {
+	header := "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-VERSION:6\n#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n#EXT-X-PART-INF:PART-TARGET=0.5\n#EXT-X-MEDIA-SEQUENCE:1\n"
+	held := make(chan struct{})
+	defer close(held)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !strings.HasSuffix(r.URL.Path, ".m3u8"):
+			w.Write([]byte(r.URL.Path))
+		case r.URL.Query().Get("_HLS_msn") != "":
+			// a blocking reload the origin never answers
+			select {
+			case <-r.Context().Done():
+			case <-held:
+			}
+		default:
+			w.Write([]byte(header + "#EXTINF:1,\na.mp4\n"))
+		}
+	}))
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/live/radio_trd/index.m3u8"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ps := pubsub.New(10)
+	ch := ps.Sub(DownloadStatusChannel)
+	if err := RecordHLSPlaylist(ctx, url, NewFileStorage(folder), prefix, ps); err != ErrNotRecorded {
+		t.Errorf("RecordHLSPlaylist() stopped before recording error = %v, want %v", err, ErrNotRecorded)
+	}
+	ps.Unsub(ch, DownloadStatusChannel)
+	for c := range ch {
+		if status := c.(DownloadStatus); status.Status == "downloaded hls" {
+			t.Errorf("published %v without recording", status.Status)
+		}
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := RecordHLSPlaylist(ctx, url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RecordHLSPlaylist() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stopping waited %v for the blocking reload", elapsed)
+	}
+	if p := recordedPlaylist(t, folder, prefix, url); !p.EndList || len(p.Segments) != 1 {
+		t.Errorf("stopped recording = %s", p.Encode())
+	}
+}
+
+func TestRecordHLSPlaylistEmpty(t *testing.T) {
+	origin := liveOrigin("#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:1\n")
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/live/radio_trd/index.m3u8"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := RecordHLSPlaylist(ctx, url, NewFileStorage(folder), prefix, pubsub.New(1)); err != ErrNotRecorded {
+		t.Errorf("RecordHLSPlaylist() without segments error = %v, want %v", err, ErrNotRecorded)
+	}
+	if _, err := os.Stat(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(url)))); !os.IsNotExist(err) {
+		t.Errorf("stored a playlist without segments, stat error = %v", err)
+	}
+}
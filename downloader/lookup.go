@@ -0,0 +1,175 @@
+package downloader
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/log"
+)
+
+// CacheReport describes how much of a playlist or manifest is cached
+type CacheReport struct {
+	URL string `json:"url"`
+	// Name is the object the playlist or manifest is stored as
+	Name            string `json:"name"`
+	PlaylistPresent bool   `json:"playlistPresent"`
+	// Segments counts the objects the playlist references, variant playlists
+	// of a master playlist and their segments included, SegmentsPresent
+	// those which are stored
+	Segments        int `json:"segments"`
+	SegmentsPresent int `json:"segmentsPresent"`
+	// Missing lists the urls of the referenced objects which are not stored
+	Missing []string `json:"missing"`
+	// Size is the number of bytes stored for the playlist and its objects
+	Size int64 `json:"size"`
+}
+
+// Complete reports whether the playlist and every object it references are
+// stored
+func (r *CacheReport) Complete() bool {
+	return r.PlaylistPresent && r.SegmentsPresent == r.Segments
+}
+
+// Lookup reports how much of the HLS playlist or DASH manifest of a source
+// url is cached, objects are looked up with the names the downloads give
+// them following the WithKeyStrategy option
+func Lookup(url string, storage Storage, segmentURLPrefix string, opts ...Option) (*CacheReport, error) {
+	o := newOptions(opts)
+	sourceURL := mustParseURL(url)
+	report := &CacheReport{URL: url, Name: o.key(segmentURLPrefix, sourceURL), Missing: []string{}}
+	objects, err := cachedObjects(sourceURL, storage, segmentURLPrefix, o.keys)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.PlaylistPresent = true
+	for i, obj := range objects {
+		info, err := storage.Stat(obj.Name)
+		if i > 0 {
+			report.Segments++
+		}
+		if os.IsNotExist(err) {
+			report.Missing = append(report.Missing, obj.URL)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		report.Size += info.Size
+		if i > 0 {
+			report.SegmentsPresent++
+		}
+	}
+	return report, nil
+}
+
+// cachedObjects lists the objects of a stored HLS playlist or DASH manifest,
+// the playlist comes first
+func cachedObjects(sourceURL *url.URL, storage Storage, segmentURLPrefix string, keys KeyStrategy) ([]CatalogObject, error) {
+	data, err := readObject(storage, keys.Key(segmentURLPrefix, sourceURL))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("#EXTM3U")) {
+		return hlsObjects(sourceURL, storage, segmentURLPrefix, keys)
+	}
+	return dashObjects(sourceURL, storage, segmentURLPrefix, keys)
+}
+
+// historicalKeys are the key schemes caches were written with before key
+// strategies existed, PrefixedHlsFilename and the unprefixed hlsFilename
+// before it
+var historicalKeys = []KeyStrategy{
+	LastPathSegmentsKey(),
+	KeyStrategyFunc(func(_ string, u *url.URL) string { return hlsFilename(u) }),
+}
+
+// Migrate renames the objects of the playlist or manifest of a source url
+// stored under a historical name, PrefixedHlsFilename or the unprefixed name
+// of earlier releases, to the name the WithKeyStrategy option gives them.
+// Objects already stored under their new name are kept and the old ones
+// deleted, the playlist is renamed last so an interrupted migration can be
+// run again. With the WithCatalog option the catalog entry is renamed too,
+// the catalog should then be opened with the same key strategy. A "migrated"
+// DownloadStatus is published when anything was renamed, the returned report
+// is the Lookup of the url afterwards
+func Migrate(url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) (*CacheReport, error) {
+	o := newOptions(opts)
+	sourceURL := mustParseURL(url)
+	idf := idAndFile(sourceURL)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	renamed := map[string]string{}
+	for _, old := range historicalKeys {
+		if old.Key(segmentURLPrefix, sourceURL) == filename {
+			continue
+		}
+		objects, err := cachedObjects(sourceURL, storage, segmentURLPrefix, old)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = moveObjects(storage, objects, segmentURLPrefix, o, renamed)
+		}
+		if err == nil && o.catalog != nil {
+			err = o.catalog.rename(objects[0].Name, renamed)
+		}
+		if err != nil {
+			ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "0", Status: "failed migration", Error: err.Error()}
+			ps.Pub(ds, DownloadStatusChannel)
+			return nil, err
+		}
+	}
+	if len(renamed) > 0 {
+		log.Debug.Printf("Migrated %v objects of %v\n", len(renamed), url)
+		ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "migrated", Error: ""}
+		ps.Pub(ds, DownloadStatusChannel)
+	}
+	return Lookup(url, storage, segmentURLPrefix, opts...)
+}
+
+// moveObjects renames objects to their names under o, noting the new names
+// in renamed. They are moved in reverse so segments go before the variant
+// playlists listing them and the playlist goes last, missing objects are
+// skipped
+func moveObjects(storage Storage, objects []CatalogObject, segmentURLPrefix string, o *options, renamed map[string]string) error {
+	for i := len(objects) - 1; i >= 0; i-- {
+		obj := objects[i]
+		name := o.key(segmentURLPrefix, mustParseURL(obj.URL))
+		if err := moveObject(storage, obj.Name, name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		renamed[obj.Name] = name
+	}
+	return nil
+}
+
+// moveObject renames object from to to, when to is already stored it is kept
+// and from is deleted
+func moveObject(storage Storage, from, to string) error {
+	if from == to {
+		return nil
+	}
+	if _, err := storage.Stat(to); err == nil {
+		return storage.Delete(from)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if l, ok := storage.(localStorage); ok {
+		return os.Rename(filepath.Join(l.Dir(), from), filepath.Join(l.Dir(), to))
+	}
+	obj, err := storage.Get(from)
+	if err != nil {
+		return err
+	}
+	err = storage.Put(to, obj)
+	obj.Close()
+	if err != nil {
+		return err
+	}
+	return storage.Delete(from)
+}
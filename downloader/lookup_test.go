@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestLookup(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\nsegment-2.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/segment-1.ts": "aaaa",
+		"/hls/track_trd.mp4/segment-2.ts": "bb",
+	})
+	defer origin.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+	tests := []struct {
+		name     string
+		keys     KeyStrategy
+		remove   string
+		lookup   string
+		want     CacheReport
+		complete bool
+	}{
+		{"complete", LastPathSegmentsKey(), "", url, CacheReport{PlaylistPresent: true, Segments: 2, SegmentsPresent: 2, Missing: []string{}}, true},
+		{"full url key", FullURLKey(), "", url, CacheReport{PlaylistPresent: true, Segments: 2, SegmentsPresent: 2, Missing: []string{}}, true},
+		{"missing segment", LastPathSegmentsKey(), "/hls/track_trd.mp4/segment-2.ts", url, CacheReport{PlaylistPresent: true, Segments: 2, SegmentsPresent: 1, Missing: []string{origin.URL + "/hls/track_trd.mp4/segment-2.ts"}}, false},
+		{"not cached", LastPathSegmentsKey(), "", origin.URL + "/hls/other_trd.mp4/index.m3u8", CacheReport{Missing: []string{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemoryStorage()
+			if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1), WithKeyStrategy(tt.keys)); err != nil {
+				t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+			}
+			if tt.remove != "" {
+				storage.Delete(tt.keys.Key(prefix, mustParseURL(origin.URL+tt.remove)))
+			}
+			got, err := Lookup(tt.lookup, storage, prefix, WithKeyStrategy(tt.keys))
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			if got.Name != tt.keys.Key(prefix, mustParseURL(tt.lookup)) {
+				t.Errorf("Lookup() Name = %v, want the key of the url", got.Name)
+			}
+			if tt.want.PlaylistPresent {
+				tt.want.Size = got.Size
+				if got.Size <= 2 {
+					t.Errorf("Lookup() Size = %v, want the playlist and segments", got.Size)
+				}
+			}
+			tt.want.URL, tt.want.Name = tt.lookup, got.Name
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Lookup() = %+v, want %+v", *got, tt.want)
+			}
+			if got := IsHSLPlaylistDownloaded(tt.lookup, storage, prefix, WithKeyStrategy(tt.keys)); got != tt.complete {
+				t.Errorf("IsHSLPlaylistDownloaded() = %v, want %v", got, tt.complete)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/master.m3u8":      "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nlow/index.m3u8\n",
+		"/hls/track_trd.mp4/low/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\nsegment-2.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/low/segment-1.ts": "aaaa",
+		"/hls/track_trd.mp4/low/segment-2.ts": "bb",
+	})
+	defer origin.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/master.m3u8"
+	tests := []struct {
+		name string
+		old  KeyStrategy
+		keys KeyStrategy
+	}{
+		{"prefixed to full url", LastPathSegmentsKey(), FullURLKey()},
+		{"unprefixed to prefixed", historicalKeys[1], LastPathSegmentsKey()},
+		{"unprefixed to full url", historicalKeys[1], FullURLKey()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder, err := ioutil.TempDir("", "streaming")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(folder)
+			catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"), WithKeyStrategy(tt.old))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer catalog.Close()
+			storage := NewFileStorage(filepath.Join(folder, "cache"))
+			os.Mkdir(storage.Dir(), 0755)
+			if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1), WithCatalog(catalog)); err != nil {
+				t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+			}
+			if IsHSLPlaylistDownloaded(url, storage, prefix, WithKeyStrategy(tt.keys)) {
+				t.Fatalf("IsHSLPlaylistDownloaded() = true before migrating")
+			}
+
+			ps := pubsub.New(1)
+			ch := ps.Sub(DownloadStatusChannel)
+			report, err := Migrate(url, storage, prefix, ps, WithCatalog(catalog), WithKeyStrategy(tt.keys))
+			if err != nil {
+				t.Fatalf("Migrate() error = %v", err)
+			}
+			if !report.Complete() || report.Segments != 3 {
+				t.Errorf("Migrate() = %+v, want a complete variant and 2 segments", report)
+			}
+			select {
+			case msg := <-ch:
+				if ds := msg.(DownloadStatus); ds.Status != "migrated" {
+					t.Errorf("Migrate() published %v, want migrated", ds.Status)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("Migrate() published nothing")
+			}
+			objects, _ := storage.List()
+			if len(objects) != 4 {
+				t.Errorf("objects after Migrate() = %v, want 4", objects)
+			}
+			if _, err := catalog.entryNamed(tt.old.Key(prefix, mustParseURL(url))); err != ErrNotCataloged {
+				t.Errorf("old catalog entry error = %v, want ErrNotCataloged", err)
+			}
+			entry, err := catalog.entryNamed(report.Name)
+			if err != nil {
+				t.Fatalf("migrated catalog entry error = %v", err)
+			}
+			problems, err := verifyEntry(storage, entry)
+			if err != nil || len(problems) != 0 {
+				t.Errorf("migrated catalog entry problems = %v, %v", problems, err)
+			}
+
+			if _, err := Migrate(url, storage, prefix, pubsub.New(1), WithCatalog(catalog), WithKeyStrategy(tt.keys)); err != nil {
+				t.Errorf("Migrate() again error = %v", err)
+			}
+		})
+	}
+}
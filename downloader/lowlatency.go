@@ -0,0 +1,88 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/osiloke/streaming/hls"
+)
+
+// deliveryDirectives matches the query parameters a player adds to request a
+// blocking playlist reload or a playlist delta update
+var deliveryDirectives = regexp.MustCompile(`[?&]_HLS_(msn|part|skip)=[^&]*`)
+
+// blockingRequest is a blocking playlist reload asked for by a player
+type blockingRequest struct {
+	msn  uint64
+	part int
+}
+
+// stripDeliveryDirectives removes the delivery directives from a source url,
+// the blocking reload they describe is returned when _HLS_msn is present
+func stripDeliveryDirectives(source string) (string, *blockingRequest) {
+	params := map[string]string{}
+	stripped := deliveryDirectives.ReplaceAllStringFunc(source, func(m string) string {
+		kv := strings.SplitN(m[1:], "=", 2)
+		params[kv[0]] = kv[1]
+		return ""
+	})
+	if strings.Contains(source, "?") && !strings.Contains(stripped, "?") {
+		// the query started with a directive
+		stripped = strings.Replace(stripped, "&", "?", 1)
+	}
+	msn, err := strconv.ParseUint(params["_HLS_msn"], 10, 64)
+	if err != nil {
+		return stripped, nil
+	}
+	req := &blockingRequest{msn: msn, part: -1}
+	if part, err := strconv.Atoi(params["_HLS_part"]); err == nil && part >= 0 {
+		req.part = part
+	}
+	return stripped, req
+}
+
+// satisfiedBy reports whether p contains the segment, or partial segment,
+// asked for by a blocking reload
+func (b *blockingRequest) satisfiedBy(p *hls.MediaPlaylist) bool {
+	if p.EndList {
+		return true
+	}
+	next := p.MediaSequence + uint64(len(p.Segments))
+	if b.msn < next {
+		return true
+	}
+	return b.msn == next && b.part >= 0 && b.part < len(p.Parts)
+}
+
+// blockingReloadURL asks the origin to hold the reload of mediaURL until the
+// segment, or partial segment, following p is available
+func blockingReloadURL(mediaURL *url.URL, p *hls.MediaPlaylist) *url.URL {
+	next := p.MediaSequence + uint64(len(p.Segments))
+	directives := fmt.Sprintf("_HLS_msn=%d", next)
+	if p.PartInf != nil {
+		directives += fmt.Sprintf("&_HLS_part=%d", len(p.Parts))
+	}
+	u := *mediaURL
+	if u.RawQuery != "" {
+		u.RawQuery += "&" + directives
+	} else {
+		u.RawQuery = directives
+	}
+	return &u
+}
+
+// stripParts removes partial segments and the tags only used to relay them
+// from p
+func stripParts(p *hls.MediaPlaylist) {
+	for _, s := range p.Segments {
+		s.Parts = nil
+	}
+	p.Parts = nil
+	p.PartInf = nil
+	p.ServerControl = nil
+	p.PreloadHints = nil
+	p.RenditionReports = nil
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_stripDeliveryDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+		block  *blockingRequest
+	}{
+		{"none", "https://a.com/live/index.m3u8?token=1", "https://a.com/live/index.m3u8?token=1", nil},
+		{"msn and part", "https://a.com/live/index.m3u8?token=1&_HLS_msn=5&_HLS_part=2", "https://a.com/live/index.m3u8?token=1", &blockingRequest{5, 2}},
+		{"msn first", "https://a.com/live/index.m3u8?_HLS_msn=5&token=1", "https://a.com/live/index.m3u8?token=1", &blockingRequest{5, -1}},
+		{"skip only", "https://a.com/live/index.m3u8?_HLS_skip=YES", "https://a.com/live/index.m3u8", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, block := stripDeliveryDirectives(tt.source)
+			if got != tt.want {
+				t.Errorf("stripDeliveryDirectives() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(block, tt.block) {
+				t.Errorf("stripDeliveryDirectives() block = %+v, want %+v", block, tt.block)
+			}
+		})
+	}
+}
+
+func TestProxyBlockingReload(t *testing.T) {
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := "https://a.com/live/radio_trd/index.m3u8"
+	header := "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-PART-INF:PART-TARGET=0.5\n#EXT-X-MEDIA-SEQUENCE:1\n#EXTINF:1,\na.mp4\n"
+	if err := writeHLS([]byte(header), PrefixedHlsFilename(prefix, mustParseURL(url)), NewFileStorage(folder), prefix); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		writeHLS([]byte(header+"#EXT-X-PART:DURATION=0.5,URI=\"b.0.mp4\"\n"), PrefixedHlsFilename(prefix, mustParseURL(url)), NewFileStorage(folder), prefix)
+	}()
+
+	proxy := NewProxy(NewFileStorage(folder), prefix)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", prefix+url+"?_HLS_msn=2&_HLS_part=0", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "b.0.mp4") {
+		t.Errorf("blocking reload response = %d %s", w.Code, w.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", prefix+url+"?_HLS_msn=9", nil).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unavailable blocking reload response = %d", w.Code)
+	}
+}
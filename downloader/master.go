@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+)
+
+// VariantSelector picks the variants of a master playlist to download
+type VariantSelector interface {
+	SelectVariants(variants []*hls.Variant) []*hls.Variant
+}
+
+// VariantSelectorFunc adapts a function to a VariantSelector
+type VariantSelectorFunc func(variants []*hls.Variant) []*hls.Variant
+
+// SelectVariants calls f(variants)
+func (f VariantSelectorFunc) SelectVariants(variants []*hls.Variant) []*hls.Variant {
+	return f(variants)
+}
+
+// AllVariants selects every variant
+func AllVariants() VariantSelector {
+	return VariantSelectorFunc(func(variants []*hls.Variant) []*hls.Variant {
+		return variants
+	})
+}
+
+// HighestBandwidth selects the variant with the highest bandwidth
+func HighestBandwidth() VariantSelector {
+	return VariantSelectorFunc(func(variants []*hls.Variant) []*hls.Variant {
+		return pickVariant(variants, func(v, best *hls.Variant) bool {
+			return v.Bandwidth > best.Bandwidth
+		})
+	})
+}
+
+// LowestBandwidth selects the variant with the lowest bandwidth
+func LowestBandwidth() VariantSelector {
+	return VariantSelectorFunc(func(variants []*hls.Variant) []*hls.Variant {
+		return pickVariant(variants, func(v, best *hls.Variant) bool {
+			return v.Bandwidth < best.Bandwidth
+		})
+	})
+}
+
+// ClosestBandwidth selects the variant whose bandwidth is closest to bps
+func ClosestBandwidth(bps int64) VariantSelector {
+	distance := func(v *hls.Variant) int64 {
+		if v.Bandwidth > bps {
+			return v.Bandwidth - bps
+		}
+		return bps - v.Bandwidth
+	}
+	return VariantSelectorFunc(func(variants []*hls.Variant) []*hls.Variant {
+		return pickVariant(variants, func(v, best *hls.Variant) bool {
+			return distance(v) < distance(best)
+		})
+	})
+}
+
+// ByCodec keeps the variants which list a codec starting with codec (e.g.
+// "mp4a" or "avc1") and passes them on to next, a nil next selects them all
+func ByCodec(codec string, next VariantSelector) VariantSelector {
+	return filterVariants(func(v *hls.Variant) bool {
+		for _, c := range variantCodecs(v) {
+			if strings.HasPrefix(c, codec) {
+				return true
+			}
+		}
+		return false
+	}, next)
+}
+
+// AudioOnly keeps the variants which carry no video and passes them on to
+// next, a nil next selects them all
+func AudioOnly(next VariantSelector) VariantSelector {
+	return filterVariants(func(v *hls.Variant) bool {
+		if v.Resolution != nil {
+			return false
+		}
+		for _, c := range variantCodecs(v) {
+			if !isAudioCodec(c) {
+				return false
+			}
+		}
+		return true
+	}, next)
+}
+
+var audioCodecs = []string{"mp4a", "ac-3", "ec-3", "ac-4", "opus", "flac", "alac", "mp3"}
+
+func isAudioCodec(codec string) bool {
+	for _, prefix := range audioCodecs {
+		if strings.HasPrefix(codec, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func variantCodecs(v *hls.Variant) []string {
+	codecs := []string{}
+	for _, c := range strings.Split(v.Codecs, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			codecs = append(codecs, c)
+		}
+	}
+	return codecs
+}
+
+func pickVariant(variants []*hls.Variant, better func(v, best *hls.Variant) bool) []*hls.Variant {
+	var best *hls.Variant
+	for _, v := range variants {
+		if best == nil || better(v, best) {
+			best = v
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return []*hls.Variant{best}
+}
+
+func filterVariants(keep func(v *hls.Variant) bool, next VariantSelector) VariantSelector {
+	return VariantSelectorFunc(func(variants []*hls.Variant) []*hls.Variant {
+		kept := []*hls.Variant{}
+		for _, v := range variants {
+			if keep(v) {
+				kept = append(kept, v)
+			}
+		}
+		if next == nil {
+			return kept
+		}
+		return next.SelectVariants(kept)
+	})
+}
+
+// downloadMasterPlaylist downloads the selected variants of a master playlist
+// with their alternate renditions and stores a master playlist which only
+// references them
+func downloadMasterPlaylist(sourceURL *url.URL, master *hls.MasterPlaylist, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, client *grab.Client, o *options) error {
+	variants := o.variants.SelectVariants(master.Variants)
+	if len(variants) == 0 {
+		return ErrNoVariant
+	}
+	renditions := selectRenditions(master.Media, variants, o.renditions)
+	playlistURIs := []string{}
+	for _, v := range variants {
+		playlistURIs = append(playlistURIs, v.URI)
+	}
+	for _, m := range renditions {
+		if m.URI != "" {
+			playlistURIs = append(playlistURIs, m.URI)
+		}
+	}
+	// fetch every playlist first so none is downloaded when one is rejected
+	contents := map[string][]byte{}
+	fetched := []string{}
+	for _, uri := range playlistURIs {
+		if _, ok := contents[uri]; ok {
+			continue
+		}
+		content, err := fetchValidHLS(mustParseURL(uri), segmentURLPrefix, ps, o)
+		if err != nil {
+			return err
+		}
+		o.use(o.key(segmentURLPrefix, mustParseURL(uri)))
+		o.sources.keepStored(storage, o.key(segmentURLPrefix, mustParseURL(uri)))
+		if _, err := hls.ParseMedia(content); err != nil {
+			return err
+		}
+		contents[uri] = content
+		fetched = append(fetched, uri)
+	}
+	err := preflight(storage, o, func() (int64, error) {
+		return estimateMasterSize(fetched, contents, variants)
+	})
+	if err != nil {
+		return err
+	}
+	for _, uri := range fetched {
+		if err := downloadMediaPlaylist(mustParseURL(uri), contents[uri], storage, segmentURLPrefix, ps, client, o); err != nil {
+			return err
+		}
+	}
+	pruneVariantGroups(variants, renditions)
+	master.Variants = variants
+	master.IFrameVariants = nil
+	master.Media = renditions
+
+	idf := idAndFile(sourceURL)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	if err := writeHLS(master.Encode(), filename, storage, segmentURLPrefix); err != nil {
+		return err
+	}
+	ds := DownloadStatus{URL: sourceURL.String(), ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "downloaded master", Error: ""}
+	ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
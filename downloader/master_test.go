@@ -0,0 +1,100 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+)
+
+func testVariants() []*hls.Variant {
+	return []*hls.Variant{
+		{URI: "low.m3u8", Bandwidth: 64000, Codecs: "mp4a.40.5"},
+		{URI: "mid.m3u8", Bandwidth: 800000, Codecs: "avc1.4d401f,mp4a.40.2", Resolution: &hls.Resolution{Width: 640, Height: 360}},
+		{URI: "high.m3u8", Bandwidth: 2000000, Codecs: "hvc1.1.6.L93.90,mp4a.40.2", Resolution: &hls.Resolution{Width: 1280, Height: 720}},
+		{URI: "aac.m3u8", Bandwidth: 256000, Codecs: "mp4a.40.2"},
+	}
+}
+
+func TestVariantSelectors(t *testing.T) {
+	variants := testVariants()
+	tests := []struct {
+		name     string
+		selector VariantSelector
+		want     []*hls.Variant
+	}{
+		{"highest", HighestBandwidth(), []*hls.Variant{variants[2]}},
+		{"lowest", LowestBandwidth(), []*hls.Variant{variants[0]}},
+		{"closest", ClosestBandwidth(700000), []*hls.Variant{variants[1]}},
+		{"codec", ByCodec("avc1", nil), []*hls.Variant{variants[1]}},
+		{"audio only", AudioOnly(HighestBandwidth()), []*hls.Variant{variants[3]}},
+		{"no match", ByCodec("vp09", HighestBandwidth()), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.SelectVariants(variants); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SelectVariants() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadHLSPlaylistMaster(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/master.m3u8": `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=64000,CODECS="mp4a.40.5"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=256000,CODECS="mp4a.40.2"
+high/index.m3u8
+`,
+		"/hls/track_trd.mp4/low/index.m3u8": `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+segment-1.ts
+#EXT-X-ENDLIST
+`,
+		"/hls/track_trd.mp4/low/segment-1.ts": "low",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/master.m3u8"
+
+	err = DownloadHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1), WithVariantSelector(LowestBandwidth()))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	master, err := ioutil.ReadFile(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(url))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{origin.URL + "/hls/track_trd.mp4/low/index.m3u8"}
+	if got := GetSegmentURLS(master, prefix); !reflect.DeepEqual(got, want) {
+		t.Errorf("cached master variants = %v, want %v", got, want)
+	}
+	files, err := GetHLSSegments(mustParseURL(url), NewFileStorage(folder), prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("GetHLSSegments() = %v, want master, variant and segment", files)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(folder, f)); err != nil {
+			t.Errorf("missing cached file %v", err)
+		}
+	}
+
+	err = DownloadHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1), WithVariantSelector(ByCodec("avc1", nil)))
+	if err != ErrNoVariant {
+		t.Errorf("DownloadHLSPlaylist() error = %v, want %v", err, ErrNoVariant)
+	}
+}
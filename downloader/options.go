@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// Option configures DownloadHLSPlaylist
+type Option func(*options)
+
+type options struct {
+	variants    VariantSelector
+	renditions  []RenditionFilter
+	maxDuration time.Duration
+	validation  ValidationMode
+	catalog     *Catalog
+	quota       int64
+	eviction    EvictionPolicy
+	expiry      ExpiryExtractor
+	keys        KeyStrategy
+	tracker     *Tracker
+	// segmentValidation handles the findings of checking downloaded segments
+	segmentValidation ValidationMode
+	// preflight checks the disk has room for a download and reserve bytes
+	preflight bool
+	reserve   int64
+	// cancelDownloads makes removals cancel the downloads of what they remove
+	cancelDownloads bool
+	// ctx is canceled when a removal cancels the download
+	ctx context.Context
+	// download is the download the WithTracker tracker follows
+	download *trackedDownload
+	// sources collects the metadata of the objects downloaded
+	sources *objectSources
+}
+
+// expires is when the entitlement to sourceURL ends, zero without the
+// WithExpiry option or when the url has no expiry
+func (o *options) expires(sourceURL *url.URL) time.Time {
+	if o.expiry == nil {
+		return time.Time{}
+	}
+	expiry, _ := o.expiry.Expiry(sourceURL)
+	return expiry
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		variants: HighestBandwidth(),
+		sources:  newObjectSources(),
+		ctx:      context.Background(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.keys == nil && o.catalog != nil {
+		o.keys = o.catalog.keys
+	}
+	if o.keys == nil {
+		o.keys = LastPathSegmentsKey()
+	}
+	return o
+}
+
+// use notes objects the download stores or reuses with the WithTracker
+// tracker, removals leave them in place until the download is over
+func (o *options) use(names ...string) {
+	o.tracker.use(o.download, names...)
+}
+
+// canceled is ErrDownloadCanceled once a removal canceled the download, err
+// otherwise
+func (o *options) canceled(err error) error {
+	if o.ctx.Err() != nil {
+		return ErrDownloadCanceled
+	}
+	return err
+}
+
+// key names the stored object of u
+func (o *options) key(segmentURLPrefix string, u *url.URL) string {
+	return o.keys.Key(segmentURLPrefix, u)
+}
+
+// WithVariantSelector sets the policy used to pick variants from a master
+// playlist, the default is HighestBandwidth
+func WithVariantSelector(selector VariantSelector) Option {
+	return func(o *options) {
+		o.variants = selector
+	}
+}
+
+// WithRenditions limits the alternate renditions downloaded with a variant to
+// those matching one of filters, by default every rendition the variant
+// references is downloaded
+func WithRenditions(filters ...RenditionFilter) Option {
+	return func(o *options) {
+		o.renditions = append(o.renditions, filters...)
+	}
+}
+
+// WithMaxDuration stops RecordHLSPlaylist once d of media has been recorded
+func WithMaxDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.maxDuration = d
+	}
+}
+
+// WithValidation validates playlists before DownloadHLSPlaylist downloads
+// anything, findings are handled as mode describes
+func WithValidation(mode ValidationMode) Option {
+	return func(o *options) {
+		o.validation = mode
+	}
+}
+
+// WithSegmentValidation checks every segment after it was downloaded, web
+// pages and broken transport streams saved as segments are handled as mode
+// describes. A rejected segment fails the download, which deletes everything
+// it stored
+func WithSegmentValidation(mode ValidationMode) Option {
+	return func(o *options) {
+		o.segmentValidation = mode
+	}
+}
+
+// WithCatalog records downloads in c and removes the entries of removed
+// playlists and manifests from it
+func WithCatalog(c *Catalog) Option {
+	return func(o *options) {
+		o.catalog = c
+	}
+}
+
+// WithQuota evicts entries of the WithCatalog catalog, in the order policy
+// evicts them, after each download until the cache fits in quota bytes
+func WithQuota(quota int64, policy EvictionPolicy) Option {
+	return func(o *options) {
+		o.quota = quota
+		o.eviction = policy
+	}
+}
+
+// WithExpiry records the expiry extractor finds in the source url with the
+// WithCatalog entry of a download, a Proxy given the same catalog refuses to
+// serve the entry once it has expired and RemoveExpired removes it
+func WithExpiry(extractor ExpiryExtractor) Option {
+	return func(o *options) {
+		o.expiry = extractor
+	}
+}
+
+// WithKeyStrategy names stored objects with keys, the default is the key
+// strategy of the WithCatalog catalog or LastPathSegmentsKey without one.
+// Everything done with a cache has to use the same strategy
+func WithKeyStrategy(keys KeyStrategy) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithDiskPreflight estimates the size of a download before anything is
+// stored and refuses it with ErrOutOfSpace when the disk of a local storage
+// would have less than reserve bytes free afterwards
+func WithDiskPreflight(reserve int64) Option {
+	return func(o *options) {
+		o.preflight = true
+		o.reserve = reserve
+	}
+}
+
+// WithTracker follows downloads and the objects a Proxy serves with t, a
+// removal given the same Tracker waits for the downloads of what it removes
+// and defers deleting objects being served until they are closed
+func WithTracker(t *Tracker) Option {
+	return func(o *options) {
+		o.tracker = t
+	}
+}
+
+// WithCancelDownloads makes a removal with the WithTracker option cancel the
+// downloads of what it removes instead of waiting for them to finish
+func WithCancelDownloads() Option {
+	return func(o *options) {
+		o.cancelDownloads = true
+	}
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+)
+
+// blockingPollInterval is how often a held playlist reload checks the cache
+const blockingPollInterval = 50 * time.Millisecond
+
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".ts":   "video/mp2t",
+	".aac":  "audio/aac",
+	".mp4":  "video/mp4",
+	".m4s":  "video/iso.segment",
+	".m4a":  "audio/mp4",
+	".vtt":  "text/vtt",
+}
+
+// Proxy serves cached playlists, segments and keys to players. Requests are
+// made for segmentURLPrefix followed by the source url, as written in the
+// cached playlists, and Range requests are honoured so byte range segments
+// are served from their single stored resource. Blocking playlist reloads of
+// playlists being recorded are held until the cache has the segment, or
+// partial segment, asked for. With the WithCatalog option every object served
+// is recorded as accessed in the catalog, and objects whose entries have all
+// expired are refused with 410 Gone. With the WithTracker option removals
+// defer deleting the objects being served until they are closed
+type Proxy struct {
+	storage          Storage
+	segmentURLPrefix string
+	prefixURI        string
+	catalog          *Catalog
+	keys             KeyStrategy
+	tracker          *Tracker
+}
+
+// NewProxy creates a Proxy for a cache storage
+func NewProxy(storage Storage, segmentURLPrefix string, opts ...Option) *Proxy {
+	prefixURI := segmentURLPrefix
+	if u := mustParseURL(segmentURLPrefix); u != nil {
+		prefixURI = u.RequestURI()
+		if strings.HasSuffix(segmentURLPrefix, "?") {
+			prefixURI += "?"
+		}
+	}
+	o := newOptions(opts)
+	return &Proxy{storage: storage, segmentURLPrefix: segmentURLPrefix, prefixURI: prefixURI, catalog: o.catalog, keys: o.keys, tracker: o.tracker}
+}
+
+// sourceURL extracts the source url from a proxied request
+func (p *Proxy) sourceURL(r *http.Request) (string, bool) {
+	uri := r.URL.RequestURI()
+	if !strings.HasPrefix(uri, p.prefixURI) {
+		return "", false
+	}
+	return strings.TrimPrefix(uri, p.prefixURI), true
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	source, ok := p.sourceURL(r)
+	source, block := stripDeliveryDirectives(source)
+	sourceURL := mustParseURL(source)
+	if !ok || sourceURL == nil || !strings.Contains(sourceURL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	filename := p.keys.Key(p.segmentURLPrefix, sourceURL)
+	if block != nil && !p.await(r.Context(), filename, block) {
+		http.Error(w, "playlist update not available", http.StatusServiceUnavailable)
+		return
+	}
+	if p.catalog != nil {
+		expired, err := p.catalog.expired(filename, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if expired {
+			http.Error(w, ErrExpired.Error(), http.StatusGone)
+			return
+		}
+	}
+	closed := p.tracker.open(filename)
+	defer closed()
+	f, err := p.storage.Get(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if p.catalog != nil {
+		if err := p.catalog.recordServed(filename); err != nil {
+			log.Debug.Printf("Proxy recording access of %s %v", source, err)
+		}
+	}
+	if contentType, ok := contentTypes[path.Ext(sourceURL.Path)]; ok {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// await polls the cached playlist filename until it satisfies a blocking reload,
+// it gives up after three target durations as RFC 8216bis section 6.2.5.2
+// allows
+func (p *Proxy) await(ctx context.Context, filename string, block *blockingRequest) bool {
+	var deadline time.Time
+	for {
+		data, err := readObject(p.storage, filename)
+		if err != nil {
+			// missing playlists are answered without blocking
+			return true
+		}
+		playlist, err := hls.ParseMedia(data)
+		if err != nil || block.satisfiedBy(playlist) {
+			return true
+		}
+		if deadline.IsZero() {
+			target := time.Duration(playlist.TargetDuration) * time.Second
+			if target <= 0 {
+				target = time.Second
+			}
+			deadline = time.Now().Add(3 * target)
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(blockingPollInterval):
+		}
+	}
+}
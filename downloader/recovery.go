@@ -0,0 +1,170 @@
+package downloader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/log"
+)
+
+// Recover cleans a storage after a crash and is meant to run at startup,
+// before any download into the storage starts. The partial files left in a
+// local storage, or what is left in the staging folder of a storage with one,
+// are discarded with a "discarded partial" RemoveStatus. Other storages stage
+// in the system temporary folder, which processes share, only its staging
+// folders older than the process are discarded for them. Cached playlists and manifests
+// referencing objects which are not stored were only half downloaded, they
+// are discarded with a "discarded playlist" RemoveStatus so they are
+// downloaded again, the objects they have are kept and skipped then. With the
+// WithCatalog option their entries are removed too, and cataloged playlists
+// are read with the prefix they were downloaded with, the others with
+// segmentURLPrefix. The names of the discarded playlists and manifests are
+// returned
+func Recover(storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	if err := discardPartials(storage, ps); err != nil {
+		return nil, err
+	}
+	objects, err := storage.List()
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, info := range objects {
+		stored[info.Name] = true
+	}
+	prefixes := map[string]string{}
+	if o.catalog != nil {
+		entries, err := o.catalog.Entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			prefixes[e.Name] = e.Prefix
+		}
+	}
+	discarded := []string{}
+	for _, info := range objects {
+		prefix, ok := prefixes[info.Name]
+		if !ok {
+			prefix = segmentURLPrefix
+		}
+		incomplete, err := isIncomplete(storage, info.Name, prefix, o.keys, stored)
+		if err != nil {
+			return discarded, err
+		}
+		if !incomplete {
+			continue
+		}
+		if err := storage.Delete(info.Name); err != nil && !os.IsNotExist(err) {
+			return discarded, err
+		}
+		if o.catalog != nil {
+			if err := o.catalog.remove(info.Name); err != nil {
+				return discarded, err
+			}
+		}
+		discarded = append(discarded, info.Name)
+		ds := RemoveStatus{Prefix: prefix, TempFilename: objectPath(storage, info.Name), Progress: "1", Status: "discarded playlist", Error: ""}
+		ps.Pub(ds, RemoveStatusChannel)
+	}
+	log.Debug.Printf("Recovered storage, discarded %v incomplete playlists\n", len(discarded))
+	return discarded, nil
+}
+
+// processStart is when the process started, the staging folders of the
+// system temporary folder made since may be in use
+var processStart = time.Now()
+
+// discardPartials removes the partial files of a local storage, what the
+// staging folder of a storage with one holds, or the staging folders of the
+// system temporary folder made before the process started
+func discardPartials(storage Storage, ps *pubsub.PubSub) error {
+	var pattern string
+	shared := false
+	if l, ok := storage.(localStorage); ok {
+		pattern = filepath.Join(l.Dir(), "*"+partialSuffix)
+	} else if s, ok := storage.(stagingStorage); ok {
+		pattern = filepath.Join(s.StagingDir(), "*")
+	} else {
+		pattern, shared = filepath.Join(os.TempDir(), stagingPrefix+"*"), true
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if shared {
+			if info, err := os.Stat(path); err != nil || !info.ModTime().Before(processStart) {
+				continue
+			}
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		ds := RemoveStatus{TempFilename: path, Progress: "1", Status: "discarded partial", Error: ""}
+		ps.Pub(ds, RemoveStatusChannel)
+	}
+	return nil
+}
+
+// playlistReferences reads object name and, when it is a playlist or
+// manifest, lists the names of the objects it references
+func playlistReferences(storage Storage, name, segmentURLPrefix string, keys KeyStrategy) (bool, []string, error) {
+	obj, err := storage.Get(name)
+	if err != nil {
+		return false, nil, err
+	}
+	defer obj.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(obj, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, nil, err
+	}
+	head = head[:n]
+	isHLS := bytes.HasPrefix(head, []byte("#EXTM3U"))
+	if !isHLS && !bytes.Contains(head, []byte("<MPD")) {
+		return false, nil, nil
+	}
+	rest, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return false, nil, err
+	}
+	body := append(head, rest...)
+	var urls []string
+	if isHLS {
+		urls = append(GetSegmentURLS(body, segmentURLPrefix), GetKeyURLS(body, segmentURLPrefix)...)
+	} else {
+		urls = GetDASHSegmentURLS(body, segmentURLPrefix)
+	}
+	names := []string{}
+	for _, url := range urls {
+		u := mustParseURL(url)
+		if u == nil || !strings.Contains(u.Path, "/") {
+			continue
+		}
+		names = append(names, keys.Key(segmentURLPrefix, u))
+	}
+	return true, names, nil
+}
+
+// isIncomplete reports whether object name is a playlist or manifest
+// referencing an object missing from stored
+func isIncomplete(storage Storage, name, segmentURLPrefix string, keys KeyStrategy, stored map[string]bool) (bool, error) {
+	_, names, err := playlistReferences(storage, name, segmentURLPrefix, keys)
+	if err != nil {
+		return false, err
+	}
+	for _, ref := range names {
+		if !stored[ref] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestRecover(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\nsegment-2.ts\n#EXT-X-ENDLIST\n"
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/index.m3u8":   playlist,
+		"/hls/a_trd.mp4/segment-1.ts": "a1",
+		"/hls/a_trd.mp4/segment-2.ts": "a2",
+		"/hls/b_trd.mp4/index.m3u8":   playlist,
+		"/hls/b_trd.mp4/segment-1.ts": "b1",
+		"/hls/b_trd.mp4/segment-2.ts": "b2",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewFileStorage(filepath.Join(folder, "cache"))
+	if err := os.Mkdir(storage.Dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	name := func(path string) string {
+		return PrefixedHlsFilename(prefix, mustParseURL(origin.URL+path))
+	}
+	for _, track := range []string{"a", "b"} {
+		if err := DownloadHLSPlaylist(origin.URL+"/hls/"+track+"_trd.mp4/index.m3u8", storage, prefix, ps, WithCatalog(catalog)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+	partials, _ := filepath.Glob(filepath.Join(storage.Dir(), "*"+partialSuffix))
+	if len(partials) != 0 {
+		t.Errorf("partial files left after download = %v", partials)
+	}
+
+	// a crash while b was downloading its second segment
+	storage.Delete(name("/hls/b_trd.mp4/segment-2.ts"))
+	partial := filepath.Join(storage.Dir(), name("/hls/b_trd.mp4/segment-2.ts")+partialSuffix)
+	if err := ioutil.WriteFile(partial, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if objects, _ := storage.List(); len(objects) != 5 {
+		t.Errorf("List() = %v, want 5 objects without the partial file", objects)
+	}
+
+	got, err := Recover(storage, prefix, ps, WithCatalog(catalog))
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if want := []string{name("/hls/b_trd.mp4/index.m3u8")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Recover() = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("partial file not discarded, error = %v", err)
+	}
+	if _, err := storage.Stat(name("/hls/b_trd.mp4/index.m3u8")); !os.IsNotExist(err) {
+		t.Errorf("half downloaded playlist not discarded, error = %v", err)
+	}
+	if _, err := catalog.Entry(origin.URL+"/hls/b_trd.mp4/index.m3u8", prefix); err != ErrNotCataloged {
+		t.Errorf("Entry() error = %v, want %v", err, ErrNotCataloged)
+	}
+	if _, err := storage.Stat(name("/hls/a_trd.mp4/index.m3u8")); err != nil {
+		t.Errorf("complete playlist discarded, error = %v", err)
+	}
+	if data, _ := readObject(storage, name("/hls/b_trd.mp4/segment-1.ts")); string(data) != "b1" {
+		t.Errorf("complete segment = %q, want kept", data)
+	}
+}
+
+func TestRecoverPrefixes(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXT-X-ENDLIST\n",
+		"/hls/a_trd.mp4/segment-1.ts": "a1",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefixes := []string{"http://127.0.0.1:7071/cache?r=1&file=", "http://127.0.0.1:7072/cache?r=1&file="}
+	ps := pubsub.New(100)
+	url := origin.URL + "/hls/a_trd.mp4/index.m3u8"
+	for _, prefix := range prefixes {
+		if err := DownloadHLSPlaylist(url, storage, prefix, ps, WithCatalog(catalog)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+
+	// both playlists are complete when read with their own prefix
+	got, err := Recover(storage, prefixes[0], ps, WithCatalog(catalog))
+	if err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Recover() = %v, want nothing discarded", got)
+	}
+	for _, prefix := range prefixes {
+		if _, err := catalog.Entry(url, prefix); err != nil {
+			t.Errorf("Entry() of %s error = %v", prefix, err)
+		}
+	}
+}
+
+func TestRecoverStaging(t *testing.T) {
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	storage, err := OpenContentStorage(NewMemoryStorage(), filepath.Join(folder, "content.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	// staging folders of another process, one left by a crash before this
+	// one started
+	inUse, err := ioutil.TempDir("", stagingPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(inUse)
+	crashed, err := ioutil.TempDir("", stagingPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(crashed)
+	before := processStart.Add(-time.Hour)
+	if err := os.Chtimes(crashed, before, before); err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(storage.StagingDir(), stagingPrefix+"1")
+	if err := ioutil.WriteFile(partial, []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ps := pubsub.New(10)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+
+	if _, err := Recover(storage, prefix, ps); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("staged file not discarded, error = %v", err)
+	}
+	for _, path := range []string{inUse, crashed} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Recover() of a storage with its own staging folder discarded %s, error = %v", path, err)
+		}
+	}
+
+	if _, err := Recover(NewMemoryStorage(), prefix, ps); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if _, err := os.Stat(inUse); err != nil {
+		t.Errorf("staging folder made since the process started discarded, error = %v", err)
+	}
+	if _, err := os.Stat(crashed); !os.IsNotExist(err) {
+		t.Errorf("staging folder older than the process not discarded, error = %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"net/url"
+	"os"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/log"
+)
+
+// objectLister lists the objects of a stored playlist or manifest, the
+// playlist comes first
+type objectLister func(url *url.URL, storage Storage, segmentURLPrefix string, keys KeyStrategy) ([]CatalogObject, error)
+
+// removeCached removes the playlist or manifest of a source url with the
+// objects list finds, as RemoveHLSPlaylist describes, and returns the bytes
+// it deleted. The playlist is deleted last so an interrupted removal can be
+// run again
+func removeCached(url string, storage Storage, segmentURLPrefix string, ps *pubsub.PubSub, o *options, list objectLister) (int64, error) {
+	sourceURL := mustParseURL(url)
+	idf := idAndFile(sourceURL)
+	filename := o.key(segmentURLPrefix, sourceURL)
+	if n := o.tracker.await(filename, o.cancelDownloads); n > 0 {
+		log.Debug.Printf("Removing %s after %v downloads\n", url, n)
+	}
+	objects, err := list(sourceURL, storage, segmentURLPrefix, o.keys)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return 0, err
+		}
+		return 0, uncatalog(o, url, segmentURLPrefix)
+	}
+	shared, err := sharedObjects(objects, storage, segmentURLPrefix, o)
+	if err != nil {
+		return 0, err
+	}
+	var freed, deferred int64
+	ordered := append(append([]CatalogObject{}, objects[1:]...), objects[0])
+	for i, obj := range ordered {
+		name := obj.Name
+		ds := RemoveStatus{URL: obj.URL, Prefix: segmentURLPrefix, TempFilename: objectPath(storage, name), Progress: "1", Status: "remove segment", Error: ""}
+		if i == len(ordered)-1 {
+			ds.Status = "remove index"
+		}
+		if shared[name] || o.tracker.using(name) {
+			ds.Status = "shared segment"
+			ps.Pub(ds, RemoveStatusChannel)
+			continue
+		}
+		size, err := releasedSize(storage, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		remove := func() error { return storage.Delete(name) }
+		if err == nil && o.tracker.deferDelete(name, filename, func() error {
+			_, err := o.tracker.deleteUnused(name, remove)
+			return err
+		}) {
+			deferred += size
+			ds.Status = "deferred segment"
+			ps.Pub(ds, RemoveStatusChannel)
+			continue
+		}
+		inUse := false
+		if err == nil {
+			// a download may have started using the object meanwhile
+			inUse, err = o.tracker.deleteUnused(name, remove)
+		}
+		if inUse {
+			ds.Status = "shared segment"
+			ps.Pub(ds, RemoveStatusChannel)
+			continue
+		}
+		if os.IsNotExist(err) {
+			// removed meanwhile by someone else
+			continue
+		}
+		if err != nil {
+			ds.Progress, ds.Status, ds.Error = "0", "failed segment", err.Error()
+			ps.Pub(ds, RemoveStatusChannel)
+			return freed, &RemoveError{URL: obj.URL, Object: name, Err: err}
+		}
+		freed += size
+		ps.Pub(ds, RemoveStatusChannel)
+	}
+	if err := uncatalog(o, url, segmentURLPrefix); err != nil {
+		return freed, err
+	}
+	log.Debug.Printf("Removed %s, %v bytes freed, %v bytes deferred\n", url, freed, deferred)
+	ds := RemoveStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: filename, Progress: "1", Status: "removed", Error: "", Freed: freed, Deferred: deferred}
+	ps.Pub(ds, RemoveStatusChannel)
+	return freed, nil
+}
+
+// releasedSize is the number of bytes deleting object name frees, nothing
+// when a referenceStorage keeps its content for other names
+func releasedSize(storage Storage, name string) (int64, error) {
+	info, err := storage.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	if refs, ok := storage.(referenceStorage); ok {
+		n, err := refs.References(name)
+		if err != nil {
+			return 0, err
+		}
+		if n > 1 {
+			return 0, nil
+		}
+	}
+	return info.Size, nil
+}
+
+// sharedObjects finds the objects of a playlist or manifest which other
+// cached playlists and manifests reference. The WithCatalog catalog knows
+// them, without one every other stored playlist and manifest is read
+func sharedObjects(objects []CatalogObject, storage Storage, segmentURLPrefix string, o *options) (map[string]bool, error) {
+	shared := map[string]bool{}
+	entry := objects[0].Name
+	if o.catalog != nil {
+		for _, obj := range objects {
+			s, err := o.catalog.shared(obj.Name, entry)
+			if err != nil {
+				return nil, err
+			}
+			shared[obj.Name] = s
+		}
+		return shared, nil
+	}
+	own := map[string]bool{}
+	for _, obj := range objects {
+		own[obj.Name] = true
+	}
+	stored, err := storage.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range stored {
+		if own[info.Name] {
+			continue
+		}
+		isPlaylist, names, err := playlistReferences(storage, info.Name, segmentURLPrefix, o.keys)
+		if os.IsNotExist(err) || (err == nil && !isPlaylist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if own[name] {
+				shared[name] = true
+			}
+		}
+	}
+	return shared, nil
+}
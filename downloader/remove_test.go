@@ -0,0 +1,203 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+)
+
+// removedStatus waits for the "removed" summary of a removal, collecting the
+// statuses published before it
+func removedStatus(t *testing.T, ch chan interface{}) (RemoveStatus, []string) {
+	statuses := []string{}
+	for {
+		select {
+		case msg := <-ch:
+			ds := msg.(RemoveStatus)
+			if ds.Status == "removed" {
+				return ds, statuses
+			}
+			statuses = append(statuses, ds.Status)
+		case <-time.After(time.Second):
+			t.Fatalf("no removed status after %v", statuses)
+			return RemoveStatus{}, nil
+		}
+	}
+}
+
+func TestRemoveHLSPlaylistReferences(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/index.m3u8": "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXTINF:9,\n/shared/intro.ts\n#EXT-X-ENDLIST\n",
+		"/hls/b_trd.mp4/index.m3u8": "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\n/shared/intro.ts\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n",
+		"/hls/a_trd.mp4/segment.ts": "aaaa",
+		"/hls/b_trd.mp4/segment.ts": "bbbbbb",
+		"/shared/intro.ts":          "intro",
+	})
+	defer origin.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	a := origin.URL + "/hls/a_trd.mp4/index.m3u8"
+	b := origin.URL + "/hls/b_trd.mp4/index.m3u8"
+	keys := LastPathSegmentsKey()
+	tests := []struct {
+		name string
+		// serving is the object a reader is open on during the removal
+		serving      string
+		wantStatuses []string
+		wantKept     []string
+		wantDeferred int64
+	}{
+		{"shared segment kept", "", []string{"remove segment", "shared segment", "remove index"}, []string{"/shared/intro.ts"}, 0},
+		{"served segment deferred", "/hls/a_trd.mp4/segment.ts", []string{"deferred segment", "shared segment", "remove index"}, []string{"/shared/intro.ts", "/hls/a_trd.mp4/segment.ts"}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemoryStorage()
+			tracker := NewTracker()
+			for _, url := range []string{a, b} {
+				if err := DownloadHLSPlaylist(url, storage, prefix, pubsub.New(1), WithTracker(tracker)); err != nil {
+					t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+				}
+			}
+			playlist, _ := storage.Stat(keys.Key(prefix, mustParseURL(a)))
+			closed := func() {}
+			if tt.serving != "" {
+				closed = tracker.open(keys.Key(prefix, mustParseURL(origin.URL+tt.serving)))
+			}
+
+			ps := pubsub.New(10)
+			ch := ps.Sub(RemoveStatusChannel)
+			if err := RemoveHLSPlaylist(a, storage, prefix, ps, WithTracker(tracker)); err != nil {
+				t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+			}
+			summary, statuses := removedStatus(t, ch)
+			if strings.Join(statuses, ",") != strings.Join(tt.wantStatuses, ",") {
+				t.Errorf("RemoveHLSPlaylist() statuses = %v, want %v", statuses, tt.wantStatuses)
+			}
+			if want := playlist.Size + 4 - tt.wantDeferred; summary.Freed != want || summary.Deferred != tt.wantDeferred {
+				t.Errorf("RemoveHLSPlaylist() freed %v deferred %v, want %v and %v", summary.Freed, summary.Deferred, want, tt.wantDeferred)
+			}
+			for _, kept := range tt.wantKept {
+				if _, err := storage.Stat(keys.Key(prefix, mustParseURL(origin.URL+kept))); err != nil {
+					t.Errorf("%s not kept, error = %v", kept, err)
+				}
+			}
+			closed()
+			if report, _ := Lookup(b, storage, prefix); !report.Complete() {
+				t.Errorf("other playlist after removal = %+v, want complete", report)
+			}
+			if objects, _ := storage.List(); len(objects) != 3 {
+				t.Errorf("objects left after the reader closed = %v, want the other playlist", objects)
+			}
+		})
+	}
+}
+
+func TestRemoveHLSPlaylistActiveDownload(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n"))
+			return
+		}
+		// the segment is never served so the download only ends when canceled
+		requested <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer origin.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+	storage := NewMemoryStorage()
+	tracker := NewTracker()
+	downloaded := make(chan error, 1)
+	go func() {
+		downloaded <- DownloadHLSPlaylist(url, storage, prefix, pubsub.New(10), WithTracker(tracker))
+	}()
+	select {
+	case <-requested:
+	case <-time.After(time.Second):
+		t.Fatal("segment never requested")
+	}
+
+	ps := pubsub.New(10)
+	ch := ps.Sub(RemoveStatusChannel)
+	if err := RemoveHLSPlaylist(url, storage, prefix, ps, WithTracker(tracker), WithCancelDownloads()); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if err := <-downloaded; err != ErrDownloadCanceled {
+		t.Errorf("DownloadHLSPlaylist() error = %v, want ErrDownloadCanceled", err)
+	}
+	removedStatus(t, ch)
+	if objects, _ := storage.List(); len(objects) != 0 {
+		t.Errorf("objects left after RemoveHLSPlaylist() = %v", objects)
+	}
+}
+
+func TestRemoveHLSPlaylistSharedWithDownload(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hls/a_trd.mp4/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\n../shared.ts\n#EXT-X-ENDLIST\n"))
+		case "/hls/b_trd.mp4/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\n../shared.ts\n#EXTINF:9,\nsegment.ts\n#EXT-X-ENDLIST\n"))
+		case "/hls/shared.ts":
+			w.Write([]byte("shared"))
+		default:
+			// b is held on its own segment after reusing the shared one
+			requested <- struct{}{}
+			<-release
+			w.Write([]byte("b"))
+		}
+	}))
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	storage := NewMemoryStorage()
+	tracker := NewTracker()
+	aURL := origin.URL + "/hls/a_trd.mp4/index.m3u8"
+	if err := DownloadHLSPlaylist(aURL, storage, prefix, pubsub.New(10), WithCatalog(catalog)); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	downloaded := make(chan error, 1)
+	go func() {
+		downloaded <- DownloadHLSPlaylist(origin.URL+"/hls/b_trd.mp4/index.m3u8", storage, prefix, pubsub.New(10), WithCatalog(catalog), WithTracker(tracker))
+	}()
+	select {
+	case <-requested:
+	case <-time.After(time.Second):
+		t.Fatal("segment never requested")
+	}
+
+	ps := pubsub.New(10)
+	ch := ps.Sub(RemoveStatusChannel)
+	// b is not cataloged before it is complete
+	if err := RemoveHLSPlaylist(aURL, storage, prefix, ps, WithCatalog(catalog), WithTracker(tracker)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	_, statuses := removedStatus(t, ch)
+	close(release)
+	if err := <-downloaded; err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	shared := PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/hls/shared.ts"))
+	if _, err := storage.Stat(shared); err != nil {
+		t.Errorf("segment of a download in progress deleted after %v, error = %v", statuses, err)
+	}
+}
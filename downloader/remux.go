@@ -0,0 +1,503 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/log"
+	"github.com/osiloke/streaming/mp4"
+	"github.com/osiloke/streaming/ts"
+)
+
+// Formats of RemuxHLSPlaylist
+const (
+	RemuxTS  = "ts"
+	RemuxMP4 = "mp4"
+	RemuxM4A = "m4a"
+)
+
+// RemuxHLSPlaylist joins the cached segments of an HLS url into a single file
+// at dst. With RemuxTS the transport stream segments are concatenated, their
+// continuity counters and timestamps made continuous across
+// discontinuities. RemuxMP4 remuxes their H.264 video and AAC audio into an
+// MP4 file and RemuxM4A their AAC audio into an M4A file, fragmented MP4
+// segments are joined to their initialization section for RemuxMP4.
+// AES-128 encrypted segments are decrypted with their cached keys.
+// For master playlists the first cached variant the WithVariantSelector
+// option selects is remuxed, alternative renditions are left out. Urls are
+// looked up with the WithKeyStrategy option, ErrNotCached or ErrIncomplete
+// is returned when the url is not cached completely and ErrUnsupportedMedia
+// for media which can not be remuxed to format. A "remuxed segment"
+// DownloadStatus is published for every segment
+func RemuxHLSPlaylist(url string, storage Storage, segmentURLPrefix, dst, format string, ps *pubsub.PubSub, opts ...Option) error {
+	sourceURL := mustParseURL(url)
+	idf := idAndFile(sourceURL)
+	err := remuxHLS(url, storage, segmentURLPrefix, dst, format, ps, opts)
+	if err != nil {
+		os.Remove(dst)
+		ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: "0", Status: "failed remux", Error: err.Error()}
+		ps.Pub(ds, DownloadStatusChannel)
+		return err
+	}
+	ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: dst, Progress: "1", Status: "remuxed", Error: ""}
+	ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
+
+func remuxHLS(url string, storage Storage, segmentURLPrefix, dst, format string, ps *pubsub.PubSub, opts []Option) error {
+	if format != RemuxTS && format != RemuxMP4 && format != RemuxM4A {
+		return ErrUnsupportedMedia
+	}
+	o := newOptions(opts)
+	report, err := Lookup(url, storage, segmentURLPrefix, opts...)
+	if err != nil {
+		return err
+	}
+	if !report.PlaylistPresent {
+		return ErrNotCached
+	}
+	if !report.Complete() {
+		return ErrIncomplete
+	}
+	playlist, err := cachedMediaPlaylist(report.Name, storage, segmentURLPrefix, o)
+	if err != nil {
+		return err
+	}
+	r := &segmentReader{playlist: playlist, storage: storage, prefix: segmentURLPrefix, o: o, ps: ps, dst: dst}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	fragmented := len(playlist.Segments) > 0 && playlist.Segments[0].Map != nil
+	switch {
+	case fragmented && format == RemuxMP4:
+		err = r.joinFragments(f)
+	case fragmented:
+		err = ErrUnsupportedMedia
+	case format == RemuxTS:
+		err = r.joinTS(f)
+	default:
+		err = r.muxMP4(f, format == RemuxM4A)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		log.Debug.Printf("Remuxed %v segments of %s to %s", len(playlist.Segments), url, dst)
+	}
+	return err
+}
+
+// cachedMediaPlaylist reads the cached media playlist stored as name, or
+// the first cached variant of a master playlist which the variant selector
+// picks, with the proxy prefix stripped from its uris
+func cachedMediaPlaylist(name string, storage Storage, segmentURLPrefix string, o *options) (*hls.MediaPlaylist, error) {
+	body, err := readObject(storage, name)
+	if err != nil {
+		return nil, err
+	}
+	playlist := parsePlaylist(body)
+	if master, ok := playlist.(*hls.MasterPlaylist); ok {
+		playlist = nil
+		for _, v := range o.variants.SelectVariants(master.Variants) {
+			variantURL := mustParseURL(strings.TrimPrefix(v.URI, segmentURLPrefix))
+			if body, err = readObject(storage, o.key(segmentURLPrefix, variantURL)); err == nil {
+				playlist = parsePlaylist(body)
+				break
+			}
+		}
+	}
+	media, ok := playlist.(*hls.MediaPlaylist)
+	if !ok {
+		return nil, ErrNotCached
+	}
+	media.Rewrite(func(uri string) string {
+		return strings.TrimPrefix(uri, segmentURLPrefix)
+	})
+	media.ResolveByteRanges()
+	return media, nil
+}
+
+// segmentReader reads the cached segments of a media playlist
+type segmentReader struct {
+	playlist *hls.MediaPlaylist
+	storage  Storage
+	prefix   string
+	o        *options
+	ps       *pubsub.PubSub
+	dst      string
+}
+
+// each passes the decrypted data of every segment to fn, gaps are skipped
+func (r *segmentReader) each(fn func(s *hls.Segment, data []byte) error) error {
+	var key *hls.Key
+	for i, s := range r.playlist.Segments {
+		if len(s.Keys) > 0 {
+			key = segmentKey(s.Keys)
+		}
+		if s.Gap {
+			continue
+		}
+		data, err := r.read(s.URI, s.ByteRange)
+		if err == nil {
+			data, err = r.decrypt(data, key, r.playlist.MediaSequence+uint64(i))
+		}
+		if err == nil {
+			err = fn(s, data)
+		}
+		if err != nil {
+			return err
+		}
+		ds := DownloadStatus{URL: s.URI, Prefix: r.prefix, TempFilename: r.dst, Progress: fmt.Sprintf("%v", float64(i+1)/float64(len(r.playlist.Segments))), Status: "remuxed segment", Error: ""}
+		r.ps.Pub(ds, DownloadStatusChannel)
+	}
+	return nil
+}
+
+// read reads a cached object, or the byte range of it
+func (r *segmentReader) read(uri string, br *hls.ByteRange) ([]byte, error) {
+	data, err := readObject(r.storage, r.o.key(r.prefix, mustParseURL(uri)))
+	if err != nil || br == nil {
+		return data, err
+	}
+	if br.Offset+br.Length > int64(len(data)) {
+		return nil, ErrIncomplete
+	}
+	return data[br.Offset : br.Offset+br.Length], nil
+}
+
+// decrypt decrypts a segment of the given media sequence number
+func (r *segmentReader) decrypt(data []byte, key *hls.Key, sequence uint64) ([]byte, error) {
+	if key == nil || key.Method == hls.KeyMethodNone {
+		return data, nil
+	}
+	if key.Method != hls.KeyMethodAES128 {
+		return nil, ErrUnsupportedMedia
+	}
+	secret, err := readObject(r.storage, r.o.key(r.prefix, mustParseURL(key.URI)))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if key.IV != "" {
+		if iv, err = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(key.IV, "0x"), "0X")); err != nil || len(iv) != aes.BlockSize {
+			return nil, errBadCipherText
+		}
+	} else {
+		binary.BigEndian.PutUint64(iv[8:], sequence)
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errBadCipherText
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errBadCipherText
+	}
+	return plain[:len(plain)-padding], nil
+}
+
+// joinFragments writes the initialization section of fragmented MP4
+// segments followed by the segments
+func (r *segmentReader) joinFragments(w io.Writer) error {
+	var init *hls.Map
+	return r.each(func(s *hls.Segment, data []byte) error {
+		if init == nil {
+			init = s.Map
+			section, err := r.read(init.URI, init.ByteRange)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(section); err != nil {
+				return err
+			}
+		} else if s.Map != nil && !sameMap(s.Map, init) {
+			// the segments can not share one initialization section
+			return ErrUnsupportedMedia
+		}
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func sameMap(a, b *hls.Map) bool {
+	if a.URI != b.URI || (a.ByteRange == nil) != (b.ByteRange == nil) {
+		return false
+	}
+	return a.ByteRange == nil || *a.ByteRange == *b.ByteRange
+}
+
+// joinTS concatenates transport stream segments
+func (r *segmentReader) joinTS(w io.Writer) error {
+	j := &tsJoiner{w: w, counters: map[uint16]uint8{}}
+	return r.each(func(s *hls.Segment, data []byte) error {
+		return j.join(data, s.Duration, s.Discontinuity)
+	})
+}
+
+// muxMP4 remuxes the H.264 and AAC streams of transport stream segments into
+// an MP4 file, only the audio with audioOnly
+func (r *segmentReader) muxMP4(f *os.File, audioOnly bool) error {
+	pr, pw := io.Pipe()
+	joined := make(chan error, 1)
+	go func() {
+		err := r.joinTS(pw)
+		pw.CloseWithError(err)
+		joined <- err
+	}()
+	err := muxMP4(pr, f, audioOnly)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if jerr := <-joined; jerr != nil && jerr != io.ErrClosedPipe {
+		return jerr
+	}
+	return err
+}
+
+func muxMP4(r io.Reader, f *os.File, audioOnly bool) error {
+	brand, compatible := "isom", []string{"isom", "iso2", "avc1", "mp41"}
+	if audioOnly {
+		brand, compatible = "M4A ", []string{"M4A ", "isom", "iso2", "mp41"}
+	}
+	w, err := mp4.NewWriter(f, brand, compatible...)
+	if err != nil {
+		return err
+	}
+	d := ts.NewDemuxer(r)
+	var video, audio *mp4.Track
+	var videoClock, audioClock timestampClock
+	var audioStart, audioFrames int64
+	for {
+		pes, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !pes.Header.HasPTS {
+			continue
+		}
+		switch {
+		case pes.StreamType == ts.StreamTypeH264 && !audioOnly:
+			s := mp4.ParseAVCAccessUnit(pes.Data)
+			if video == nil {
+				// the track starts at the first IDR picture with its
+				// parameter sets
+				if !s.Sync || s.SPS == nil || s.PPS == nil {
+					continue
+				}
+				width, height, err := mp4.SPSDimensions(s.SPS)
+				if err != nil {
+					continue
+				}
+				video = w.AddTrack(mp4.HandlerVideo, ts.ClockRate)
+				video.Width, video.Height = width, height
+				video.SampleEntry = mp4.AVCSampleEntry(s.SPS, s.PPS, width, height)
+			}
+			if len(s.Data) == 0 {
+				continue
+			}
+			dts := pes.Header.PTS
+			if pes.Header.HasDTS {
+				dts = pes.Header.DTS
+			}
+			cto := ts.TimestampDiff(pes.Header.PTS, dts)
+			if err := w.WriteSample(video, s.Data, videoClock.unwrap(dts), int32(cto), s.Sync); err != nil {
+				return err
+			}
+		case pes.StreamType == ts.StreamTypeAAC:
+			frames, _ := mp4.SplitADTS(pes.Data)
+			for _, frame := range frames {
+				if audio == nil {
+					audio = w.AddTrack(mp4.HandlerAudio, uint32(frame.SampleRate))
+					audio.SampleEntry = mp4.AACSampleEntry(frame.Config, frame.Channels, frame.SampleRate)
+					audio.DefaultDuration = mp4.AACFrameSamples
+					audioStart = audioClock.unwrap(pes.Header.PTS) * int64(frame.SampleRate) / ts.ClockRate
+				}
+				dts := audioStart + audioFrames*mp4.AACFrameSamples
+				if err := w.WriteSample(audio, frame.Data, dts, 0, true); err != nil {
+					return err
+				}
+				audioFrames++
+			}
+		}
+	}
+	if video == nil && audio == nil {
+		return ErrUnsupportedMedia
+	}
+	return w.Close()
+}
+
+// timestampClock unwraps the 33 bit timestamps of a stream
+type timestampClock struct {
+	last    int64
+	started bool
+}
+
+func (c *timestampClock) unwrap(t int64) int64 {
+	if !c.started {
+		c.last, c.started = t, true
+		return t
+	}
+	c.last += ts.TimestampDiff(t, ts.WrapTimestamp(c.last))
+	return c.last
+}
+
+// tsJoiner concatenates transport stream segments into one stream. The
+// elementary streams of later segments are moved to the PIDs of the first
+// segment, continuity counters continue from the previous segment and
+// timestamps are shifted to follow it across discontinuities
+type tsJoiner struct {
+	w        io.Writer
+	program  *ts.PMT
+	counters map[uint16]uint8
+	// offset is added to the timestamps of the current segment
+	offset int64
+	// end is where the previous segment ends on the joined timeline
+	end     int64
+	started bool
+}
+
+func (j *tsJoiner) join(data []byte, duration float64, discontinuity bool) error {
+	program, err := ts.NewDemuxer(bytes.NewReader(data)).Program()
+	if err != nil {
+		return err
+	}
+	pids := j.mapPIDs(program)
+	if start, ok := firstTimestamp(data, program); ok {
+		length := int64(duration * ts.ClockRate)
+		drift := ts.TimestampDiff(start+j.offset, j.end)
+		if j.started && (discontinuity || drift > length+ts.ClockRate || drift < -length-ts.ClockRate) {
+			j.offset = ts.TimestampDiff(j.end, start)
+		}
+		j.started = true
+		j.end = ts.WrapTimestamp(start + j.offset + length)
+	}
+	for i := 0; i+ts.PacketSize <= len(data); i += ts.PacketSize {
+		p := ts.Packet(data[i : i+ts.PacketSize])
+		if p[0] != ts.SyncByte {
+			return ts.ErrSync
+		}
+		pid, ok := pids[p.PID()]
+		if !ok {
+			continue
+		}
+		_, isStream := program.Stream(p.PID())
+		p.SetPID(pid)
+		cc, seen := j.counters[pid]
+		switch {
+		case p.HasPayload() && seen:
+			cc = (cc + 1) & 0x0f
+		case p.HasPayload():
+			cc = p.ContinuityCounter()
+		}
+		p.SetContinuityCounter(cc)
+		j.counters[pid] = cc
+		if j.offset != 0 {
+			if pcr, ok := p.PCR(); ok {
+				p.SetPCR(shiftPCR(pcr, j.offset))
+			}
+			if isStream && p.PayloadUnitStart() {
+				ts.ShiftPESTimestamps(p.Payload(), j.offset)
+			}
+		}
+		if _, err := j.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapPIDs maps the PIDs of a segment to the PIDs they are written on, PIDs
+// which are not mapped are dropped. The PAT and PMT of a segment are only
+// kept when its program is laid out like the one of the first segment
+func (j *tsJoiner) mapPIDs(program *ts.PMT) map[uint16]uint16 {
+	if j.program == nil {
+		j.program = program
+	}
+	pids := map[uint16]uint16{}
+	if sameProgram(program, j.program) {
+		pids[ts.PIDPAT], pids[program.PID], pids[program.PCRPID] = ts.PIDPAT, program.PID, program.PCRPID
+		for _, s := range program.Streams {
+			pids[s.PID] = s.PID
+		}
+		return pids
+	}
+	used := map[uint16]bool{}
+	for _, s := range program.Streams {
+		for _, first := range j.program.Streams {
+			if first.Type == s.Type && !used[first.PID] {
+				pids[s.PID], used[first.PID] = first.PID, true
+				break
+			}
+		}
+	}
+	if _, ok := pids[program.PCRPID]; !ok {
+		pids[program.PCRPID] = j.program.PCRPID
+	}
+	return pids
+}
+
+func sameProgram(a, b *ts.PMT) bool {
+	if a.PID != b.PID || a.PCRPID != b.PCRPID || len(a.Streams) != len(b.Streams) {
+		return false
+	}
+	for i := range a.Streams {
+		if a.Streams[i].PID != b.Streams[i].PID || a.Streams[i].Type != b.Streams[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// firstTimestamp is the earliest decoding time of the first PES packets of
+// the elementary streams of a segment
+func firstTimestamp(data []byte, program *ts.PMT) (int64, bool) {
+	var first int64
+	found := false
+	seen := map[uint16]bool{}
+	for i := 0; i+ts.PacketSize <= len(data) && len(seen) < len(program.Streams); i += ts.PacketSize {
+		p := ts.Packet(data[i : i+ts.PacketSize])
+		pid := p.PID()
+		if _, ok := program.Stream(pid); !ok || seen[pid] || !p.PayloadUnitStart() {
+			continue
+		}
+		seen[pid] = true
+		h, err := ts.ParsePESHeader(p.Payload())
+		if err != nil || !h.HasPTS {
+			continue
+		}
+		t := h.PTS
+		if h.HasDTS {
+			t = h.DTS
+		}
+		if !found || ts.TimestampDiff(t, first) < 0 {
+			first, found = t, true
+		}
+	}
+	return first, found
+}
+
+// shiftPCR adds a timestamp offset to a program clock reference
+func shiftPCR(pcr uint64, offset int64) uint64 {
+	wrap := int64(ts.TimestampWrap) * 300
+	shifted := (int64(pcr) + offset*300) % wrap
+	if shifted < 0 {
+		shifted += wrap
+	}
+	return uint64(shifted)
+}
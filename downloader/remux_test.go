@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/ts"
+)
+
+// remuxedTimeline checks the continuity counters of a joined stream and
+// lists the PIDs and the PTS of its PES packets
+func remuxedTimeline(t *testing.T, data []byte) (map[uint16]bool, []int64) {
+	pids := map[uint16]bool{}
+	counters := map[uint16]uint8{}
+	var pts []int64
+	for i := 0; i+ts.PacketSize <= len(data); i += ts.PacketSize {
+		p := ts.Packet(data[i : i+ts.PacketSize])
+		pids[p.PID()] = true
+		if last, ok := counters[p.PID()]; ok && p.ContinuityCounter() != (last+1)&0x0f {
+			t.Errorf("packet %v of PID %v continuity counter = %v, want %v", i/ts.PacketSize, p.PID(), p.ContinuityCounter(), (last+1)&0x0f)
+		}
+		counters[p.PID()] = p.ContinuityCounter()
+		if p.PID() == 0x100 && p.PayloadUnitStart() {
+			h, err := ts.ParsePESHeader(p.Payload())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pts = append(pts, h.PTS)
+		}
+	}
+	return pids, pts
+}
+
+func TestRemuxHLSPlaylist(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:0.1,\nsegment-1.ts\n#EXTINF:0.1,\nsegment-2.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:0.1,\nsegment-3.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/segment-1.ts": string(tsSegment(ts.TimestampWrap-4500, 3, 0x100, 0x101)),
+		"/hls/track_trd.mp4/segment-2.ts": string(tsSegment(4500, 3, 0x100, 0x101)),
+		"/hls/track_trd.mp4/segment-3.ts": string(tsSegment(900000, 3, 0x200, 0x201)),
+		"/hls/crypt_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/key1\"\n#EXTINF:0.1,\nsegment-1.ts\n#EXT-X-ENDLIST\n",
+		"/hls/crypt_trd.mp4/segment-1.ts": string(encryptSegment(tsSegment(0, 3, 0x100, 0x101), key, 0)),
+		"/hls/fmp4_trd.mp4/index.m3u8":    "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:0.1,\nsegment-1.m4s\n#EXTINF:0.1,\nsegment-2.m4s\n#EXT-X-ENDLIST\n",
+		"/hls/fmp4_trd.mp4/init.mp4":      "init",
+		"/hls/fmp4_trd.mp4/segment-1.m4s": "moof1",
+		"/hls/fmp4_trd.mp4/segment-2.m4s": "moof2",
+		"/keys/key1":                      string(key),
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	storage := NewMemoryStorage()
+	for _, track := range []string{"track", "crypt", "fmp4"} {
+		if err := DownloadHLSPlaylist(origin.URL+"/hls/"+track+"_trd.mp4/index.m3u8", storage, prefix, pubsub.New(1)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+	tests := []struct {
+		name    string
+		track   string
+		format  string
+		wantErr error
+		check   func(t *testing.T, data []byte)
+	}{
+		{"ts across discontinuity", "track", RemuxTS, nil, func(t *testing.T, data []byte) {
+			pids, pts := remuxedTimeline(t, data)
+			if pids[0x200] || pids[0x201] || len(pts) != 9 {
+				t.Fatalf("PIDs = %v, %v video PES packets, want the PIDs of the first segment and 9", pids, len(pts))
+			}
+			for i := 1; i < len(pts); i++ {
+				if d := ts.TimestampDiff(pts[i], pts[i-1]); d != 3000 {
+					t.Errorf("PTS %v follows %v by %v, want 3000", i, i-1, d)
+				}
+			}
+		}},
+		{"decrypted ts", "crypt", RemuxTS, nil, func(t *testing.T, data []byte) {
+			if !bytes.Equal(data, tsSegment(0, 3, 0x100, 0x101)) {
+				t.Errorf("remuxed encrypted segment differs from its plain text")
+			}
+		}},
+		{"mp4", "track", RemuxMP4, nil, func(t *testing.T, data []byte) {
+			if !bytes.Contains(data[:16], []byte("ftypisom")) || !bytes.Contains(data, []byte("avcC")) || !bytes.Contains(data, []byte("esds")) {
+				t.Errorf("remuxed mp4 lacks the ftyp, avcC or esds boxes")
+			}
+		}},
+		{"m4a", "track", RemuxM4A, nil, func(t *testing.T, data []byte) {
+			if !bytes.Contains(data[:16], []byte("ftypM4A ")) || bytes.Contains(data, []byte("avcC")) || !bytes.Contains(data, []byte("esds")) {
+				t.Errorf("remuxed m4a is not audio only")
+			}
+		}},
+		{"fragmented mp4", "fmp4", RemuxMP4, nil, func(t *testing.T, data []byte) {
+			if string(data) != "initmoof1moof2" {
+				t.Errorf("joined fragments = %q, want the init section and segments", data)
+			}
+		}},
+		{"fragmented ts", "fmp4", RemuxTS, ErrUnsupportedMedia, nil},
+		{"not cached", "other", RemuxTS, ErrNotCached, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(folder, strings.Replace(tt.name, " ", "-", -1)+"."+tt.format)
+			ps := pubsub.New(10)
+			ch := ps.Sub(DownloadStatusChannel)
+			err := RemuxHLSPlaylist(origin.URL+"/hls/"+tt.track+"_trd.mp4/index.m3u8", storage, prefix, dst, tt.format, ps)
+			if err != tt.wantErr {
+				t.Fatalf("RemuxHLSPlaylist() error = %v, want %v", err, tt.wantErr)
+			}
+			want := "remuxed"
+			if tt.wantErr != nil {
+				want = "failed remux"
+			}
+			for found := false; !found; {
+				select {
+				case msg := <-ch:
+					found = msg.(DownloadStatus).Status == want
+				case <-time.After(time.Second):
+					t.Fatalf("RemuxHLSPlaylist() published no %s status", want)
+				}
+			}
+			data, err := ioutil.ReadFile(dst)
+			if tt.check == nil {
+				if !os.IsNotExist(err) {
+					t.Errorf("failed remux left %s, error = %v", dst, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, data)
+		})
+	}
+}
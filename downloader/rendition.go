@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"strings"
+
+	"github.com/osiloke/streaming/hls"
+)
+
+// RenditionFilter selects the alternate renditions (EXT-X-MEDIA) of one type
+// which are downloaded along with the chosen variants
+type RenditionFilter struct {
+	// Type is one of hls.MediaTypeAudio, hls.MediaTypeVideo,
+	// hls.MediaTypeSubtitles or hls.MediaTypeClosedCaptions
+	Type string
+	// Languages matches renditions by language, "en" also matches "en-US".
+	// An empty list matches every language
+	Languages []string
+	// GroupIDs matches renditions by GROUP-ID, an empty list matches every
+	// group
+	GroupIDs []string
+}
+
+func (f RenditionFilter) matches(m *hls.Media) bool {
+	if f.Type != m.Type {
+		return false
+	}
+	if len(f.GroupIDs) > 0 && !containsString(f.GroupIDs, m.GroupID) {
+		return false
+	}
+	if len(f.Languages) == 0 {
+		return true
+	}
+	for _, lang := range f.Languages {
+		if languageMatches(lang, m.Language) {
+			return true
+		}
+	}
+	return false
+}
+
+func languageMatches(want, lang string) bool {
+	want, lang = strings.ToLower(want), strings.ToLower(lang)
+	return lang == want || strings.HasPrefix(lang, want+"-")
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// variantGroup returns the group a variant references for a media type
+func variantGroup(v *hls.Variant, mediaType string) string {
+	switch mediaType {
+	case hls.MediaTypeAudio:
+		return v.Audio
+	case hls.MediaTypeVideo:
+		return v.Video
+	case hls.MediaTypeSubtitles:
+		return v.Subtitles
+	case hls.MediaTypeClosedCaptions:
+		if v.ClosedCaptions != "NONE" {
+			return v.ClosedCaptions
+		}
+	}
+	return ""
+}
+
+// selectRenditions returns the renditions referenced by variants which pass
+// filters. Without filters every referenced rendition is selected, with
+// filters a rendition must match at least one of them
+func selectRenditions(media []*hls.Media, variants []*hls.Variant, filters []RenditionFilter) []*hls.Media {
+	selected := []*hls.Media{}
+	for _, m := range media {
+		referenced := false
+		for _, v := range variants {
+			if variantGroup(v, m.Type) == m.GroupID {
+				referenced = true
+				break
+			}
+		}
+		if !referenced {
+			continue
+		}
+		keep := len(filters) == 0
+		for _, f := range filters {
+			if f.matches(m) {
+				keep = true
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, m)
+		}
+	}
+	return selected
+}
+
+// pruneVariantGroups clears group references which no selected rendition
+// belongs to, so the stored master playlist stays valid
+func pruneVariantGroups(variants []*hls.Variant, media []*hls.Media) {
+	has := func(mediaType, group string) bool {
+		for _, m := range media {
+			if m.Type == mediaType && m.GroupID == group {
+				return true
+			}
+		}
+		return false
+	}
+	for _, v := range variants {
+		if v.Audio != "" && !has(hls.MediaTypeAudio, v.Audio) {
+			v.Audio = ""
+		}
+		if v.Video != "" && !has(hls.MediaTypeVideo, v.Video) {
+			v.Video = ""
+		}
+		if v.Subtitles != "" && !has(hls.MediaTypeSubtitles, v.Subtitles) {
+			v.Subtitles = ""
+		}
+		if v.ClosedCaptions != "" && v.ClosedCaptions != "NONE" && !has(hls.MediaTypeClosedCaptions, v.ClosedCaptions) {
+			v.ClosedCaptions = ""
+		}
+	}
+}
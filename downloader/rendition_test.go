@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+)
+
+func TestDownloadHLSPlaylistRenditions(t *testing.T) {
+	media := func(segment string) string {
+		return "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10,\n" + segment + "\n#EXT-X-ENDLIST\n"
+	}
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/master.m3u8": `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="fr-CA",NAME="Francais",URI="audio/fr.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="de",NAME="Deutsch",URI="audio/de.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="en",NAME="English",URI="subs/en.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="fr",NAME="Francais",URI="subs/fr.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800000,AUDIO="aac",SUBTITLES="subs"
+video/index.m3u8
+`,
+		"/hls/track_trd.mp4/video/index.m3u8": media("video.ts"),
+		"/hls/track_trd.mp4/video/video.ts":   "video",
+		"/hls/track_trd.mp4/audio/en.m3u8":    media("en.aac"),
+		"/hls/track_trd.mp4/audio/en.aac":     "en",
+		"/hls/track_trd.mp4/audio/fr.m3u8":    media("fr.aac"),
+		"/hls/track_trd.mp4/audio/fr.aac":     "fr",
+		"/hls/track_trd.mp4/subs/en.m3u8":     media("en.vtt"),
+		"/hls/track_trd.mp4/subs/en.vtt":      "WEBVTT",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/master.m3u8"
+
+	err = DownloadHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1), WithRenditions(
+		RenditionFilter{Type: hls.MediaTypeAudio, Languages: []string{"en", "fr"}},
+		RenditionFilter{Type: hls.MediaTypeSubtitles, Languages: []string{"en"}},
+	))
+	if err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	cached, err := ioutil.ReadFile(filepath.Join(folder, PrefixedHlsFilename(prefix, mustParseURL(url))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := hls.ParseMaster(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, m := range master.Media {
+		names = append(names, m.Name)
+		if !strings.HasPrefix(m.URI, prefix) {
+			t.Errorf("rendition uri is not proxied = %s", m.URI)
+		}
+	}
+	if want := []string{"English", "Francais", "English"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("cached renditions = %v, want %v", names, want)
+	}
+
+	files, err := GetHLSSegments(mustParseURL(url), NewFileStorage(folder), prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 9 {
+		t.Errorf("GetHLSSegments() returned %d files, want 9", len(files))
+	}
+	if err := RemoveHLSPlaylist(url, NewFileStorage(folder), prefix, pubsub.New(1)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	left, _ := ioutil.ReadDir(folder)
+	if len(left) != 0 {
+		t.Errorf("RemoveHLSPlaylist() left %d files", len(left))
+	}
+}
+
+func Test_selectRenditions(t *testing.T) {
+	media := []*hls.Media{
+		{Type: hls.MediaTypeAudio, GroupID: "aac", Language: "en", Name: "en"},
+		{Type: hls.MediaTypeAudio, GroupID: "ac3", Language: "en", Name: "en-ac3"},
+		{Type: hls.MediaTypeSubtitles, GroupID: "subs", Language: "fr", Name: "fr"},
+	}
+	variants := []*hls.Variant{{Audio: "aac", Subtitles: "subs"}}
+	tests := []struct {
+		name    string
+		filters []RenditionFilter
+		want    []*hls.Media
+	}{
+		{"referenced", nil, []*hls.Media{media[0], media[2]}},
+		{"audio only", []RenditionFilter{{Type: hls.MediaTypeAudio}}, []*hls.Media{media[0]}},
+		{"group", []RenditionFilter{{Type: hls.MediaTypeAudio, GroupIDs: []string{"ac3"}}}, []*hls.Media{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectRenditions(media, variants, tt.filters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectRenditions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
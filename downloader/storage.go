@@ -0,0 +1,278 @@
+package downloader
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage stores the cached playlists, segments and keys. Objects are named
+// by the KeyStrategy of the downloads, errors for missing objects satisfy
+// os.IsNotExist
+type Storage interface {
+	// Put stores everything read from r as name, replacing any existing object
+	Put(name string, r io.Reader) error
+	// Get opens an object for reading
+	Get(name string) (Object, error)
+	// Stat describes an object
+	Stat(name string) (ObjectInfo, error)
+	// Delete removes an object
+	Delete(name string) error
+	// List describes every object
+	List() ([]ObjectInfo, error)
+}
+
+// Object is a stored object opened for reading, it can be served with
+// http.ServeContent
+type Object interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// partialSuffix marks the files of a local folder still being written, they
+// are synced and renamed to their object name once complete
+const partialSuffix = ".partial"
+
+// stagingPrefix names the temporary folders downloads for storages which are
+// not local are staged in
+const stagingPrefix = "streaming-staging-"
+
+// stagingStorage is implemented by storages with a staging folder of their
+// own, downloads are staged there and Recover empties it
+type stagingStorage interface {
+	StagingDir() string
+}
+
+// referenceStorage is implemented by storages sharing the content of objects
+// between names, deleting a name only frees its content when References
+// counts no other name
+type referenceStorage interface {
+	References(name string) (int, error)
+}
+
+// localStorage is implemented by storages keeping each object as a file of a
+// local folder, downloads are written in place instead of being staged
+type localStorage interface {
+	Dir() string
+}
+
+// modeStorage is implemented by storages keeping objects as files, PutMode
+// stores an object like Put with the file permissions perm. Keys are stored
+// with it so they are only readable by their owner
+type modeStorage interface {
+	PutMode(name string, r io.Reader, perm os.FileMode) error
+}
+
+// putMode stores r as name with perm on storages which keep file permissions
+func putMode(storage Storage, name string, r io.Reader, perm os.FileMode) error {
+	if m, ok := storage.(modeStorage); ok {
+		return m.PutMode(name, r, perm)
+	}
+	return storage.Put(name, r)
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// writer streams what is written to it into Storage.Put
+type writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// NewWriter returns a writer storing everything written to it as name, the
+// object is complete once Close returns without error
+func NewWriter(s Storage, name string) io.WriteCloser {
+	pr, pw := io.Pipe()
+	w := &writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := s.Put(name, pr)
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *writer) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+// readObject reads a whole object
+func readObject(storage Storage, name string) ([]byte, error) {
+	obj, err := storage.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return ioutil.ReadAll(obj)
+}
+
+// objectPath is how status events name an object, the path of its file for
+// a local storage
+func objectPath(storage Storage, name string) string {
+	if l, ok := storage.(localStorage); ok {
+		return filepath.Join(l.Dir(), name)
+	}
+	return name
+}
+
+// stagingFolder is the folder files are downloaded into before they are
+// stored. It is the storage folder of a local storage, otherwise a temporary
+// folder removed by the returned cleanup, made in the staging folder of the
+// storage when it has one
+func stagingFolder(storage Storage) (string, func(), error) {
+	if l, ok := storage.(localStorage); ok {
+		return l.Dir(), func() {}, nil
+	}
+	dir := ""
+	if s, ok := storage.(stagingStorage); ok {
+		dir = s.StagingDir()
+	}
+	folder, err := ioutil.TempDir(dir, stagingPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	return folder, func() { os.RemoveAll(folder) }, nil
+}
+
+// stagedPath is the path object name is downloaded to in the staging folder
+func stagedPath(folder, name string) string {
+	return filepath.Join(folder, name+partialSuffix)
+}
+
+// commitStaged stores a file downloaded into the staging folder, a local
+// storage gets it synced and renamed into place
+func commitStaged(storage Storage, folder, name string) error {
+	path := stagedPath(folder, name)
+	if l, ok := storage.(localStorage); ok {
+		return syncRename(path, filepath.Join(l.Dir(), name))
+	}
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return storage.Put(name, f)
+}
+
+// syncRename flushes the file at path to disk before renaming it to newPath,
+// so newPath is never seen incomplete
+func syncRename(path, newPath string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, newPath)
+}
+
+// FileStorage stores objects as files of a local folder. Objects are written
+// to partial files which are renamed once complete, Recover discards the
+// partial files a crash leaves behind
+type FileStorage struct {
+	dir string
+}
+
+// NewFileStorage creates a FileStorage for dir
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{dir: dir}
+}
+
+// Dir is the folder objects are stored in
+func (s *FileStorage) Dir() string {
+	return s.dir
+}
+
+func (s *FileStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// Put stores r as the file name, replacing it only once r is written and
+// synced
+func (s *FileStorage) Put(name string, r io.Reader) error {
+	return s.PutMode(name, r, 0644)
+}
+
+// PutMode stores r as the file name with the permissions perm
+func (s *FileStorage) PutMode(name string, r io.Reader, perm os.FileMode) error {
+	f, err := ioutil.TempFile(s.dir, name+".*"+partialSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Get opens the file name
+func (s *FileStorage) Get(name string) (Object, error) {
+	return os.Open(s.path(name))
+}
+
+// Stat describes the file name
+func (s *FileStorage) Stat(name string) (ObjectInfo, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete removes the file name
+func (s *FileStorage) Delete(name string) error {
+	return os.Remove(s.path(name))
+}
+
+// List describes every file of the folder, partial files are left out
+func (s *FileStorage) List() ([]ObjectInfo, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	objects := []ObjectInfo{}
+	for _, info := range files {
+		if info.IsDir() || strings.HasSuffix(info.Name(), partialSuffix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objects, nil
+}
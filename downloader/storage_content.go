@@ -0,0 +1,296 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/minio/sha256-simd"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	namesBucket    = []byte("names")
+	contentsBucket = []byte("contents")
+)
+
+// contentName is what an index records for an object name
+type contentName struct {
+	Content string    `json:"content"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// ContentStorage stores objects by the sha256 of their content in a backing
+// Storage, so objects with the same content, such as a segment cached under
+// two prefixes, are stored once. An index in a bbolt database maps every
+// object name to its content and counts the names referencing each content,
+// the content is deleted with the last name referencing it. The backing
+// storage should not be shared with anything else
+type ContentStorage struct {
+	contents Storage
+	db       *bolt.DB
+	// staging is the folder contents are hashed in before they are stored
+	staging string
+
+	mu sync.Mutex
+	// uploading counts the Puts storing each content, it is not deleted while
+	// they are
+	uploading map[string]int
+}
+
+// OpenContentStorage opens or creates the index at path of the objects
+// stored in contents, objects are staged in the folder path.staging
+func OpenContentStorage(contents Storage, path string) (*ContentStorage, error) {
+	staging := path + ".staging"
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{namesBucket, contentsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &ContentStorage{contents: contents, db: db, staging: staging, uploading: map[string]int{}}, nil
+}
+
+// StagingDir is the folder objects are staged in, it is only shared with
+// other ContentStorages of the same index
+func (s *ContentStorage) StagingDir() string {
+	return s.staging
+}
+
+// Close closes the index
+func (s *ContentStorage) Close() error {
+	return s.db.Close()
+}
+
+func getContentName(tx *bolt.Tx, name string) (*contentName, error) {
+	v := tx.Bucket(namesBucket).Get([]byte(name))
+	if v == nil {
+		return nil, nil
+	}
+	cn := &contentName{}
+	return cn, json.Unmarshal(v, cn)
+}
+
+func contentRefs(tx *bolt.Tx, content string) (int, error) {
+	refs := 0
+	v := tx.Bucket(contentsBucket).Get([]byte(content))
+	if v == nil {
+		return 0, nil
+	}
+	err := json.Unmarshal(v, &refs)
+	return refs, err
+}
+
+// addRef changes the number of names referencing content by delta and
+// returns it
+func addRef(tx *bolt.Tx, content string, delta int) (int, error) {
+	refs, err := contentRefs(tx, content)
+	if err != nil {
+		return 0, err
+	}
+	refs += delta
+	if refs <= 0 {
+		return 0, tx.Bucket(contentsBucket).Delete([]byte(content))
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return 0, err
+	}
+	return refs, tx.Bucket(contentsBucket).Put([]byte(content), data)
+}
+
+// release deletes content once nothing references or uploads it, s.mu must
+// be held
+func (s *ContentStorage) release(content string, refs int) error {
+	if refs > 0 || s.uploading[content] > 0 {
+		return nil
+	}
+	if err := s.contents.Delete(content); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Put stores r as name, its content is only stored when no other name
+// references the same content
+func (s *ContentStorage) Put(name string, r io.Reader) error {
+	return s.PutMode(name, r, 0644)
+}
+
+// PutMode stores r as name like Put, the content is stored with the
+// permissions perm when the backing storage keeps them
+func (s *ContentStorage) PutMode(name string, r io.Reader, perm os.FileMode) error {
+	f, err := ioutil.TempFile(s.staging, stagingPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	content := hex.EncodeToString(h.Sum(nil))
+
+	s.mu.Lock()
+	s.uploading[content]++
+	var refs int
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		refs, err = contentRefs(tx, content)
+		return err
+	})
+	s.mu.Unlock()
+	if err == nil && refs == 0 {
+		err = putMode(s.contents, content, f, perm)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploading[content]--
+	if s.uploading[content] == 0 {
+		delete(s.uploading, content)
+	}
+	if err != nil {
+		return err
+	}
+	var old *contentName
+	var oldRefs int
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if old, err = getContentName(tx, name); err != nil {
+			return err
+		}
+		if old == nil || old.Content != content {
+			if old != nil {
+				if oldRefs, err = addRef(tx, old.Content, -1); err != nil {
+					return err
+				}
+			}
+			if _, err := addRef(tx, content, 1); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(contentName{Content: content, Size: size, ModTime: time.Now()})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(namesBucket).Put([]byte(name), data)
+	})
+	if err != nil || old == nil || old.Content == content {
+		return err
+	}
+	return s.release(old.Content, oldRefs)
+}
+
+// Get opens the content of name
+func (s *ContentStorage) Get(name string) (Object, error) {
+	info, err := s.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.contents.Get(info.Content)
+}
+
+// Stat describes the object name
+func (s *ContentStorage) Stat(name string) (ObjectInfo, error) {
+	info, err := s.lookup("stat", name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Name: name, Size: info.Size, ModTime: info.ModTime}, nil
+}
+
+func (s *ContentStorage) lookup(op, name string) (*contentName, error) {
+	var info *contentName
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		info, err = getContentName(tx, name)
+		return err
+	})
+	if err == nil && info == nil {
+		err = notExist(op, name)
+	}
+	return info, err
+}
+
+// Delete removes the object name, its content is deleted when no other name
+// references it
+func (s *ContentStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var info *contentName
+	var refs int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if info, err = getContentName(tx, name); err != nil || info == nil {
+			return err
+		}
+		if refs, err = addRef(tx, info.Content, -1); err != nil {
+			return err
+		}
+		return tx.Bucket(namesBucket).Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return notExist("remove", name)
+	}
+	return s.release(info.Content, refs)
+}
+
+// List describes every object sorted by name
+func (s *ContentStorage) List() ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(namesBucket).ForEach(func(k, v []byte) error {
+			info := contentName{}
+			if err := json.Unmarshal(v, &info); err != nil {
+				return err
+			}
+			objects = append(objects, ObjectInfo{Name: string(k), Size: info.Size, ModTime: info.ModTime})
+			return nil
+		})
+	})
+	return objects, err
+}
+
+// References counts the names sharing the content of name, name included
+func (s *ContentStorage) References(name string) (int, error) {
+	var refs int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		info, err := getContentName(tx, name)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			return notExist("stat", name)
+		}
+		refs, err = contentRefs(tx, info.Content)
+		return err
+	})
+	return refs, err
+}
@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestContentStorage(t *testing.T) {
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	contents := NewMemoryStorage()
+	s, err := OpenContentStorage(contents, filepath.Join(folder, "content.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	stored := func() int {
+		objects, _ := contents.List()
+		return len(objects)
+	}
+
+	s.Put("a", strings.NewReader("segment"))
+	s.Put("b", strings.NewReader("segment"))
+	if refs, err := s.References("a"); err != nil || refs != 2 || stored() != 1 {
+		t.Errorf("References() = %d, %v with %d contents, want 2 with 1", refs, err, stored())
+	}
+	s.Put("b", strings.NewReader("other"))
+	if refs, _ := s.References("a"); refs != 1 || stored() != 2 {
+		t.Errorf("References() after replace = %d with %d contents, want 1 with 2", refs, stored())
+	}
+	s.Delete("b")
+	if got, _ := readObject(s, "a"); string(got) != "segment" || stored() != 1 {
+		t.Errorf("Get() = %q with %d contents, want %q with 1", got, stored(), "segment")
+	}
+	s.Delete("a")
+	if stored() != 0 {
+		t.Errorf("%d contents left after deleting every name", stored())
+	}
+}
+
+func TestRemoveSharedSegments(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXT-X-ENDLIST\n"
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/index.m3u8":   playlist,
+		"/hls/a_trd.mp4/preview.m3u8": playlist,
+		"/hls/a_trd.mp4/segment-1.ts": "a1",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	contents := NewMemoryStorage()
+	storage, err := OpenContentStorage(contents, filepath.Join(folder, "content.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	prefixes := []string{"http://127.0.0.1:7071/cache?r=1&file=", "http://127.0.0.1:7072/cache?r=1&file="}
+	ps := pubsub.New(100)
+	urls := []string{origin.URL + "/hls/a_trd.mp4/index.m3u8", origin.URL + "/hls/a_trd.mp4/preview.m3u8"}
+	for _, prefix := range prefixes {
+		for _, url := range urls {
+			if err := DownloadHLSPlaylist(url, storage, prefix, ps, WithCatalog(catalog)); err != nil {
+				t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+			}
+		}
+	}
+	// every playlist of a prefix is the same, as is every segment
+	if objects, _ := contents.List(); len(objects) != 3 {
+		t.Errorf("%d contents stored, want 3", len(objects))
+	}
+
+	segment := PrefixedHlsFilename(prefixes[0], mustParseURL(origin.URL+"/hls/a_trd.mp4/segment-1.ts"))
+	if err := RemoveHLSPlaylist(urls[0], storage, prefixes[0], ps, WithCatalog(catalog)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if _, err := storage.Stat(segment); err != nil {
+		t.Errorf("segment shared with another playlist removed, error = %v", err)
+	}
+	playlistInfo, _ := storage.Stat(PrefixedHlsFilename(prefixes[0], mustParseURL(urls[1])))
+	removed := ps.Sub(RemoveStatusChannel)
+	if err := RemoveHLSPlaylist(urls[1], storage, prefixes[0], ps, WithCatalog(catalog)); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	ps.Unsub(removed, RemoveStatusChannel)
+	if _, err := storage.Stat(segment); !os.IsNotExist(err) {
+		t.Errorf("segment of the last playlist kept, error = %v", err)
+	}
+	for msg := range removed {
+		// the content of the segment stays for the other prefix
+		if rs := msg.(RemoveStatus); rs.Status == "removed" && rs.Freed != playlistInfo.Size {
+			t.Errorf("RemoveHLSPlaylist() freed %v, want %v", rs.Freed, playlistInfo.Size)
+		}
+	}
+	if got, _ := readObject(storage, PrefixedHlsFilename(prefixes[1], mustParseURL(origin.URL+"/hls/a_trd.mp4/segment-1.ts"))); string(got) != "a1" {
+		t.Errorf("segment cached under another prefix = %q, want %q", got, "a1")
+	}
+}
+
+func TestRemoveSharedSegmentsWithoutCatalog(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXT-X-ENDLIST\n"
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/index.m3u8":   playlist,
+		"/hls/a_trd.mp4/preview.m3u8": playlist,
+		"/hls/a_trd.mp4/segment-1.ts": "a1",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	storage, err := OpenContentStorage(NewMemoryStorage(), filepath.Join(folder, "content.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	urls := []string{origin.URL + "/hls/a_trd.mp4/index.m3u8", origin.URL + "/hls/a_trd.mp4/preview.m3u8"}
+	for _, url := range urls {
+		if err := DownloadHLSPlaylist(url, storage, prefix, ps); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+
+	segment := PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/hls/a_trd.mp4/segment-1.ts"))
+	if err := RemoveHLSPlaylist(urls[0], storage, prefix, ps); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if got, _ := readObject(storage, segment); string(got) != "a1" {
+		t.Errorf("segment shared with another playlist = %q, want %q", got, "a1")
+	}
+	if err := RemoveHLSPlaylist(urls[1], storage, prefix, ps); err != nil {
+		t.Fatalf("RemoveHLSPlaylist() error = %v", err)
+	}
+	if _, err := storage.Stat(segment); !os.IsNotExist(err) {
+		t.Errorf("segment of the last playlist kept, error = %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory, it is safe for concurrent use
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// memoryReader reads a stored object
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// NewMemoryStorage creates an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string]*memoryObject{}}
+}
+
+// Put stores everything read from r as name
+func (s *MemoryStorage) Put(name string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.objects[name] = &memoryObject{data: data, modTime: time.Now()}
+	s.mu.Unlock()
+	return nil
+}
+
+// Get opens the object name
+func (s *MemoryStorage) Get(name string) (Object, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return memoryReader{bytes.NewReader(obj.data)}, nil
+}
+
+// Stat describes the object name
+func (s *MemoryStorage) Stat(name string) (ObjectInfo, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[name]
+	s.mu.RUnlock()
+	if !ok {
+		return ObjectInfo{}, notExist("stat", name)
+	}
+	return ObjectInfo{Name: name, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+// Delete removes the object name
+func (s *MemoryStorage) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; !ok {
+		return notExist("remove", name)
+	}
+	delete(s.objects, name)
+	return nil
+}
+
+// List describes every object sorted by name
+func (s *MemoryStorage) List() ([]ObjectInfo, error) {
+	s.mu.RLock()
+	objects := make([]ObjectInfo, 0, len(s.objects))
+	for name, obj := range s.objects {
+		objects = append(objects, ObjectInfo{Name: name, Size: int64(len(obj.data)), ModTime: obj.modTime})
+	}
+	s.mu.RUnlock()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+	return objects, nil
+}
@@ -0,0 +1,383 @@
+package downloader
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the sha256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config configures an S3Storage
+type S3Config struct {
+	// Endpoint is the url of the server, e.g. http://127.0.0.1:9000 for a
+	// local MinIO or https://s3.amazonaws.com
+	Endpoint string
+	// Region defaults to us-east-1
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every object name to form its key
+	Prefix string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// S3Storage stores objects in a bucket of an S3-compatible object store.
+// Requests are path-style and signed with AWS Signature Version 4
+type S3Storage struct {
+	config S3Config
+}
+
+// S3Error is an error response of an S3-compatible server
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// NewS3Storage creates an S3Storage
+func NewS3Storage(config S3Config) *S3Storage {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &S3Storage{config: config}
+}
+
+// objectURL is the path-style url of the object with key, query is encoded
+// as canonical query strings are
+func (s *S3Storage) objectURL(key string, query url.Values) string {
+	u := s.config.Endpoint + "/" + uriEncode(s.config.Bucket, true)
+	if key != "" {
+		u += "/" + uriEncode(key, false)
+	}
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	return u
+}
+
+func (s *S3Storage) do(method, key string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		} else {
+			payloadHash = "UNSIGNED-PAYLOAD"
+		}
+	}
+	signV4(req, s.config.AccessKey, s.config.SecretKey, s.config.Region, payloadHash, time.Now())
+	return s.config.Client.Do(req)
+}
+
+// responseError turns an unsuccessful response into an error, missing
+// objects satisfy os.IsNotExist
+func responseError(resp *http.Response, op, name string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return notExist(op, name)
+	}
+	e := &S3Error{StatusCode: resp.StatusCode, Code: resp.Status}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	xml.Unmarshal(body, e)
+	return e
+}
+
+// Put uploads r as name. Readers which can not seek are spooled to a
+// temporary file first as the size of the object must be known
+func (s *S3Storage) Put(name string, r io.Reader) error {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		f, err := ioutil.TempFile("", "streaming")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := io.Copy(f, r); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		rs = f
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", s.config.Prefix+name, nil, ioutil.NopCloser(rs), end-start, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "put", name)
+	}
+	return nil
+}
+
+// Get opens name, the object is fetched as it is read and seeking starts a
+// new ranged request
+func (s *S3Storage) Get(name string) (Object, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s: s, name: name, size: info.Size}, nil
+}
+
+// Stat describes name
+func (s *S3Storage) Stat(name string) (ObjectInfo, error) {
+	resp, err := s.do("HEAD", s.config.Prefix+name, nil, nil, 0, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ObjectInfo{}, responseError(resp, "stat", name)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Delete removes name, S3 does not report whether it existed
+func (s *S3Storage) Delete(name string) error {
+	resp, err := s.do("DELETE", s.config.Prefix+name, nil, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError(resp, "remove", name)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List describes every object under the key prefix
+func (s *S3Storage) List() ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	query := url.Values{"list-type": {"2"}, "prefix": {s.config.Prefix}}
+	for {
+		resp, err := s.do("GET", "", query, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = responseError(resp, "list", s.config.Bucket)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, ObjectInfo{Name: strings.TrimPrefix(c.Key, s.config.Prefix), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// s3Object reads an object from offset onwards
+type s3Object struct {
+	s      *S3Storage
+	name   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", o.offset)}}
+		resp, err := o.s.do("GET", o.s.config.Prefix+o.name, nil, nil, 0, header)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.offset == 0) {
+			err = responseError(resp, "read", o.name)
+			resp.Body.Close()
+			return 0, err
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	if err == io.EOF && o.offset < o.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("s3: seek %s: negative position", o.name)
+	}
+	if offset != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// signV4 adds the AWS Signature Version 4 Authorization header to req, the
+// host, range and x-amz-* headers are signed
+func signV4(req *http.Request, accessKey, secretKey, region, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key as Signature Version 4 expects
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and '/'
+// unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+
+	"github.com/osiloke/streaming/log"
+)
+
+// Tracker follows the downloads in progress and the objects Proxies are
+// serving. Removals given the same Tracker with the WithTracker option wait
+// for, or with the WithCancelDownloads option cancel, the downloads of what
+// they remove, leave the objects other downloads store or reuse in place and
+// defer deleting objects until they are no longer served. A nil Tracker
+// tracks nothing
+type Tracker struct {
+	mu sync.Mutex
+	// downloads lists the downloads in progress by the name of the playlist
+	// or manifest they store
+	downloads map[string][]*trackedDownload
+	// readers counts the readers open on each object
+	readers map[string]int
+	// deferred holds the deletions waiting for the readers of an object
+	deferred map[string]deferredDelete
+}
+
+type trackedDownload struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// objects holds the objects the download stores or reuses
+	objects map[string]bool
+}
+
+type deferredDelete struct {
+	// entry is the playlist or manifest whose removal deferred the deletion
+	entry  string
+	delete func() error
+}
+
+// NewTracker creates an empty Tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		downloads: map[string][]*trackedDownload{},
+		readers:   map[string]int{},
+		deferred:  map[string]deferredDelete{},
+	}
+}
+
+// begin notes a download storing the playlist or manifest name, the returned
+// context is canceled when a removal cancels it and the returned function
+// must be called once the download is over. Deletions a removal of name
+// deferred are dropped so they do not delete what the download stores again
+func (t *Tracker) begin(parent context.Context, name string) (context.Context, *trackedDownload, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	if t == nil {
+		return ctx, nil, cancel
+	}
+	d := &trackedDownload{cancel: cancel, done: make(chan struct{}), objects: map[string]bool{}}
+	t.mu.Lock()
+	t.downloads[name] = append(t.downloads[name], d)
+	for object, dd := range t.deferred {
+		if dd.entry == name {
+			delete(t.deferred, object)
+		}
+	}
+	t.mu.Unlock()
+	return ctx, d, func() {
+		cancel()
+		t.mu.Lock()
+		downloads := t.downloads[name]
+		for i, other := range downloads {
+			if other == d {
+				downloads = append(downloads[:i], downloads[i+1:]...)
+				break
+			}
+		}
+		if len(downloads) == 0 {
+			delete(t.downloads, name)
+		} else {
+			t.downloads[name] = downloads
+		}
+		t.mu.Unlock()
+		close(d.done)
+	}
+}
+
+// await waits for the downloads storing name to finish, canceling them first
+// when cancel is set. It reports how many there were
+func (t *Tracker) await(name string, cancel bool) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	downloads := append([]*trackedDownload{}, t.downloads[name]...)
+	t.mu.Unlock()
+	for _, d := range downloads {
+		if cancel {
+			d.cancel()
+		}
+		<-d.done
+	}
+	return len(downloads)
+}
+
+// use notes objects download d stores or reuses, it is called before d checks
+// whether they are stored
+func (t *Tracker) use(d *trackedDownload, names ...string) {
+	if t == nil || d == nil {
+		return
+	}
+	t.mu.Lock()
+	for _, name := range names {
+		d.objects[name] = true
+	}
+	t.mu.Unlock()
+}
+
+// inUse reports whether a download in progress stores or reuses object name,
+// t.mu must be held
+func (t *Tracker) inUse(name string) bool {
+	for _, downloads := range t.downloads {
+		for _, d := range downloads {
+			if d.objects[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// using reports whether a download in progress stores or reuses object name
+func (t *Tracker) using(name string) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.inUse(name)
+}
+
+// deleteUnused runs remove unless a download in progress stores or reuses
+// object name, it reports whether the object was left in place
+func (t *Tracker) deleteUnused(name string, remove func() error) (bool, error) {
+	if t == nil {
+		return false, remove()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inUse(name) {
+		return true, nil
+	}
+	return false, remove()
+}
+
+// open notes a reader of object name, the returned function closes it and
+// runs a deletion deferred until the last reader closed
+func (t *Tracker) open(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	t.readers[name]++
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.readers[name]--
+		var dd deferredDelete
+		deferred := false
+		if t.readers[name] == 0 {
+			delete(t.readers, name)
+			dd, deferred = t.deferred[name]
+			delete(t.deferred, name)
+		}
+		t.mu.Unlock()
+		if deferred {
+			if err := dd.delete(); err != nil {
+				log.Debug.Printf("Deferred removal of %s %v", name, err)
+			}
+		}
+	}
+}
+
+// deferDelete defers deleting object name, removed with the playlist or
+// manifest entry, while readers are open on it. It reports whether the
+// deletion was deferred, the caller deletes the object otherwise
+func (t *Tracker) deferDelete(name, entry string, remove func() error) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.readers[name] == 0 {
+		return false
+	}
+	t.deferred[name] = deferredDelete{entry: entry, delete: remove}
+	return true
+}
@@ -0,0 +1,185 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/hls"
+	"github.com/osiloke/streaming/ts"
+)
+
+// ValidationMode controls how findings of hls.Validate are handled when
+// downloading
+type ValidationMode int
+
+const (
+	// SkipValidation downloads playlists without validating them
+	SkipValidation ValidationMode = iota
+	// WarnInvalid publishes an "invalid hls" DownloadStatus for a playlist
+	// with findings and downloads it anyway
+	WarnInvalid
+	// RejectInvalid fails the download when a playlist has errors, warnings
+	// are published as with WarnInvalid
+	RejectInvalid
+)
+
+// ValidationError is returned when RejectInvalid refuses a playlist
+type ValidationError struct {
+	URL         string
+	Diagnostics []hls.Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid playlist %s: %s", e.URL, formatDiagnostics(e.Diagnostics))
+}
+
+func formatDiagnostics(diagnostics []hls.Diagnostic) string {
+	findings := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		findings[i] = d.String()
+	}
+	return strings.Join(findings, "; ")
+}
+
+// validateHLS validates a playlist as served by the origin
+func validateHLS(url *url.URL, raw []byte, segmentURLPrefix string, ps *pubsub.PubSub, o *options) error {
+	mode := o.validation
+	if mode == SkipValidation {
+		return nil
+	}
+	diagnostics := hls.Validate(raw)
+	if len(diagnostics) == 0 {
+		return nil
+	}
+	if mode == RejectInvalid && hls.HasErrors(diagnostics) {
+		return &ValidationError{URL: url.String(), Diagnostics: diagnostics}
+	}
+	idf := idAndFile(url)
+	ds := DownloadStatus{URL: url.String(), ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: o.key(segmentURLPrefix, url), Progress: "0", Status: "invalid hls", Error: formatDiagnostics(diagnostics)}
+	ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
+
+// SegmentError is returned when RejectInvalid refuses a downloaded segment
+type SegmentError struct {
+	URL string
+	Err error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("invalid segment %s: %v", e.URL, e.Err)
+}
+
+// Unwrap returns what is wrong with the segment
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// errBadCipherText is returned for encrypted segments which are not padded
+// AES blocks
+var errBadCipherText = errors.New("segment is not AES-128 encrypted")
+
+// segmentKey picks the key segments are decrypted with from the EXT-X-KEY
+// tags preceding them
+func segmentKey(keys []*hls.Key) *hls.Key {
+	for _, key := range keys {
+		if key.Method == hls.KeyMethodNone || key.KeyFormat == "" || key.KeyFormat == "identity" {
+			return key
+		}
+	}
+	return keys[0]
+}
+
+// encryptedSegments lists the segments of a media playlist which are
+// encrypted, only their size can be checked before decryption
+func encryptedSegments(hlsRaw []byte, segmentURLPrefix string) map[string]bool {
+	encrypted := map[string]bool{}
+	playlist, ok := parsePlaylist(hlsRaw).(*hls.MediaPlaylist)
+	if !ok {
+		return encrypted
+	}
+	for _, s := range playlist.Segments {
+		if len(s.Keys) == 0 || segmentKey(s.Keys).Method == hls.KeyMethodNone {
+			continue
+		}
+		encrypted[strings.TrimPrefix(s.URI, segmentURLPrefix)] = true
+		for _, part := range s.Parts {
+			encrypted[strings.TrimPrefix(part.URI, segmentURLPrefix)] = true
+		}
+		if s.Map != nil {
+			encrypted[strings.TrimPrefix(s.Map.URI, segmentURLPrefix)] = true
+		}
+	}
+	return encrypted
+}
+
+// checkSegment finds what is wrong with the segment downloaded from url to
+// staged: error pages served in place of the segment, transport streams which
+// are truncated or broken and encrypted segments which are not whole cipher
+// blocks
+func checkSegment(url, staged string, encrypted bool) error {
+	f, err := os.Open(staged)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	head = head[:n]
+	if n == 0 {
+		return ErrEmptySegment
+	}
+	if strings.HasPrefix(http.DetectContentType(head), "text/html") {
+		return ErrHTMLSegment
+	}
+	if encrypted {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size()%aes.BlockSize != 0 {
+			return errBadCipherText
+		}
+		return nil
+	}
+	if head[0] != ts.SyncByte && !strings.EqualFold(path.Ext(mustParseURL(url).Path), ".ts") {
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return ts.Validate(bufio.NewReader(f))
+}
+
+// validateSegment checks a downloaded segment with the WithSegmentValidation
+// option
+func validateSegment(url, staged string, encrypted bool, segmentURLPrefix string, ps *pubsub.PubSub, o *options) error {
+	mode := o.segmentValidation
+	if mode == SkipValidation {
+		return nil
+	}
+	err := checkSegment(url, staged, encrypted)
+	if err == nil {
+		return nil
+	}
+	if mode == RejectInvalid {
+		return &SegmentError{URL: url, Err: err}
+	}
+	sourceURL := mustParseURL(url)
+	idf := idAndFile(sourceURL)
+	ds := DownloadStatus{URL: url, ID: idf[0], Segment: idf[1], Prefix: segmentURLPrefix, TempFilename: o.key(segmentURLPrefix, sourceURL), Progress: "1", Status: "invalid segment", Error: err.Error()}
+	ps.Pub(ds, DownloadStatusChannel)
+	return nil
+}
@@ -0,0 +1,229 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/ts"
+)
+
+// testSPS is a baseline profile sequence parameter set of 320x240 pictures
+var testSPS = []byte{0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+
+// testPackets splits payload into packets of pid, the first one starting the
+// payload unit and carrying a PCR when withPCR is set
+func testPackets(pid uint16, cc *uint8, payload []byte, pcr int64, withPCR bool) []byte {
+	out := []byte{}
+	for first := true; first || len(payload) > 0; first = false {
+		p := make([]byte, ts.PacketSize)
+		p[0], p[1], p[2], p[3] = ts.SyncByte, byte(pid>>8), byte(pid), 0x10|*cc&0x0f
+		*cc++
+		if first {
+			p[1] |= 0x40
+		}
+		var adaptation []byte
+		if first && withPCR {
+			adaptation = []byte{0x10, 0, 0, 0, 0, 0, 0}
+		}
+		room := ts.PacketSize - 4
+		if adaptation != nil {
+			room -= 1 + len(adaptation)
+		}
+		if stuffing := room - len(payload); stuffing > 0 {
+			switch {
+			case adaptation != nil:
+			case stuffing == 1:
+				adaptation, stuffing = []byte{}, 0
+			default:
+				adaptation, stuffing = []byte{0}, stuffing-2
+			}
+			adaptation = append(adaptation, bytes.Repeat([]byte{0xff}, stuffing)...)
+		}
+		offset := 4
+		if adaptation != nil {
+			p[3] |= 0x20
+			p[4] = byte(len(adaptation))
+			copy(p[5:], adaptation)
+			offset = 5 + len(adaptation)
+		}
+		payload = payload[copy(p[offset:], payload):]
+		if first && withPCR {
+			ts.Packet(p).SetPCR(uint64(pcr) * 300)
+		}
+		out = append(out, p...)
+	}
+	return out
+}
+
+func testPES(streamID byte, pts int64, data []byte) []byte {
+	h := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5, 0x21, 0, 0x01, 0, 0x01}
+	ts.ShiftPESTimestamps(h, pts)
+	if streamID != 0xe0 {
+		n := len(h) - 6 + len(data)
+		h[4], h[5] = byte(n>>8), byte(n)
+	}
+	return append(h, data...)
+}
+
+// tsSegment muxes frames H.264 access units at 30 fps and AAC frames of a
+// 48kHz stereo stream starting at pts, the PMT is on PID 0x1000 and the
+// video and audio streams on videoPID and audioPID
+func tsSegment(pts int64, frames int, videoPID, audioPID uint16) []byte {
+	var patCC, pmtCC, videoCC, audioCC uint8
+	section := func(table byte, data []byte) []byte {
+		length := 5 + len(data) + 4
+		s := []byte{0, table, 0xb0 | byte(length>>8), byte(length), 0, 1, 0xc1, 0, 0}
+		return append(append(s, data...), 0, 0, 0, 0)
+	}
+	segment := testPackets(ts.PIDPAT, &patCC, section(0x00, []byte{0, 1, 0xf0, 0x00}), 0, false)
+	pmt := []byte{0xe0 | byte(videoPID>>8), byte(videoPID), 0xf0, 0x00,
+		ts.StreamTypeH264, 0xe0 | byte(videoPID>>8), byte(videoPID), 0xf0, 0x00,
+		ts.StreamTypeAAC, 0xe0 | byte(audioPID>>8), byte(audioPID), 0xf0, 0x00}
+	segment = append(segment, testPackets(0x1000, &pmtCC, section(0x02, pmt), 0, false)...)
+	adts := []byte{0xff, 0xf1, 0x4c, 0x80, 0x02, 0x1f, 0xfc, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x11, 0x22, 0x33}
+	for i := 0; i < frames; i++ {
+		au := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1, 0x41, 0x9a, byte(i)}
+		if i == 0 {
+			au = append([]byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1}, testSPS...)
+			au = append(au, 0, 0, 1, 0x68, 0xce, 0x38, 0x80, 0, 0, 1, 0x65, 0x88, 0x84)
+		}
+		videoPTS := pts + int64(i)*3000
+		segment = append(segment, testPackets(videoPID, &videoCC, testPES(0xe0, videoPTS, au), videoPTS, true)...)
+		segment = append(segment, testPackets(audioPID, &audioCC, testPES(0xc0, pts+int64(i)*1920, adts), 0, false)...)
+	}
+	return segment
+}
+
+// encryptSegment encrypts a segment with AES-128 the way HLS does
+func encryptSegment(data, key []byte, sequence uint64) []byte {
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], sequence)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func TestDownloadHLSPlaylistValidation(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/track_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:9,\nsegment-1.ts\n#EXT-X-ENDLIST\n",
+		"/hls/track_trd.mp4/segment-1.ts": "segment",
+	})
+	defer origin.Close()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	url := origin.URL + "/hls/track_trd.mp4/index.m3u8"
+	tests := []struct {
+		name      string
+		mode      ValidationMode
+		wantErr   bool
+		wantFiles int
+		wantEvent bool
+	}{
+		{"skip", SkipValidation, false, 2, false},
+		{"warn", WarnInvalid, false, 2, true},
+		{"reject", RejectInvalid, true, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder, err := ioutil.TempDir("", "streaming")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(folder)
+			ps := pubsub.New(10)
+			ch := ps.Sub(DownloadStatusChannel)
+			err = DownloadHLSPlaylist(url, NewFileStorage(folder), prefix, ps, WithValidation(tt.mode))
+			if _, ok := err.(*ValidationError); ok != tt.wantErr {
+				t.Errorf("DownloadHLSPlaylist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			ps.Unsub(ch, DownloadStatusChannel)
+			warned := false
+			for c := range ch {
+				if status := c.(DownloadStatus); status.Status == "invalid hls" {
+					warned = status.Error != ""
+				}
+			}
+			if warned != tt.wantEvent {
+				t.Errorf("published invalid hls = %v, want %v", warned, tt.wantEvent)
+			}
+			if files, _ := ioutil.ReadDir(folder); len(files) != tt.wantFiles {
+				t.Errorf("cached %d files, want %d", len(files), tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestDownloadHLSPlaylistSegmentValidation(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	segment := string(tsSegment(90000, 3, 0x100, 0x101))
+	html := "<!DOCTYPE html><html><body><h1>502 Bad Gateway</h1></body></html>"
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	tests := []struct {
+		name      string
+		segment   string
+		mode      ValidationMode
+		wantErr   error
+		wantFiles int
+		wantEvent bool
+	}{
+		{"valid", segment, RejectInvalid, nil, 4, false},
+		{"skip", html, SkipValidation, nil, 4, false},
+		{"warn", html, WarnInvalid, nil, 4, true},
+		{"reject html", html, RejectInvalid, ErrHTMLSegment, 0, false},
+		{"reject truncated", segment[:len(segment)-100], RejectInvalid, ts.ErrTruncated, 0, false},
+		{"reject empty", "", RejectInvalid, ErrEmptySegment, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := testOrigin(map[string]string{
+				"/hls/track_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:0.1,\nsegment-1.ts\n#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/key1\"\n#EXTINF:0.1,\nsegment-2.ts\n#EXT-X-ENDLIST\n",
+				"/hls/track_trd.mp4/segment-1.ts": tt.segment,
+				"/hls/track_trd.mp4/segment-2.ts": string(encryptSegment([]byte(segment), key, 1)),
+				"/keys/key1":                      string(key),
+			})
+			defer origin.Close()
+			folder, err := ioutil.TempDir("", "streaming")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(folder)
+			ps := pubsub.New(10)
+			ch := ps.Sub(DownloadStatusChannel)
+			err = DownloadHLSPlaylist(origin.URL+"/hls/track_trd.mp4/index.m3u8", NewFileStorage(folder), prefix, ps, WithSegmentValidation(tt.mode))
+			var got error
+			if err != nil {
+				e, ok := err.(*SegmentError)
+				if !ok {
+					t.Fatalf("DownloadHLSPlaylist() error = %v, want a SegmentError", err)
+				}
+				got = e.Err
+				if pe, ok := got.(*ts.PacketError); ok {
+					got = pe.Err
+				}
+			}
+			if got != tt.wantErr {
+				t.Errorf("DownloadHLSPlaylist() error = %v, want %v", err, tt.wantErr)
+			}
+			ps.Unsub(ch, DownloadStatusChannel)
+			warned := false
+			for c := range ch {
+				if status := c.(DownloadStatus); status.Status == "invalid segment" {
+					warned = status.Error != ""
+				}
+			}
+			if warned != tt.wantEvent {
+				t.Errorf("published invalid segment = %v, want %v", warned, tt.wantEvent)
+			}
+			if files, _ := ioutil.ReadDir(folder); len(files) != tt.wantFiles {
+				t.Errorf("cached %d files, want %d", len(files), tt.wantFiles)
+			}
+		})
+	}
+}
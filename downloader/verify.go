@@ -0,0 +1,255 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/osiloke/streaming/log"
+)
+
+// Problem kinds found by Verify and VerifyCache
+const (
+	// MissingObject is an object of a playlist or manifest which is not stored
+	MissingObject = "missing"
+	// SizeMismatch is an object whose size differs from the size recorded
+	// when it was downloaded
+	SizeMismatch = "size mismatch"
+	// ChecksumMismatch is an object whose content differs from the content
+	// downloaded
+	ChecksumMismatch = "checksum mismatch"
+	// OrphanObject is a stored object no playlist, manifest or catalog entry
+	// references
+	OrphanObject = "orphan"
+)
+
+// Problem is an object of the cache which is missing, corrupted or orphaned
+type Problem struct {
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	// URL is the url the object was downloaded from, when it is cataloged
+	URL string `json:"url,omitempty"`
+	// Entry is the name of the playlist or manifest referencing the object,
+	// empty for orphans
+	Entry  string `json:"entry,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Verify checks the objects of the catalog entry of a source url against the
+// sizes and checksums recorded when they were downloaded
+func Verify(catalog *Catalog, storage Storage, url, segmentURLPrefix string) ([]Problem, error) {
+	entry, err := catalog.Entry(url, segmentURLPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return verifyEntry(storage, entry)
+}
+
+func verifyEntry(storage Storage, entry *CatalogEntry) ([]Problem, error) {
+	problems := []Problem{}
+	for _, o := range entry.Objects {
+		p := Problem{Object: o.Name, URL: o.URL, Entry: entry.Name}
+		size, checksum, err := checksumObject(storage, o.Name)
+		switch {
+		case os.IsNotExist(err):
+			p.Kind = MissingObject
+		case err != nil:
+			return nil, err
+		case size != o.Size:
+			p.Kind = SizeMismatch
+			p.Detail = fmt.Sprintf("%d bytes, %d downloaded", size, o.Size)
+		case o.Checksum != "" && checksum != o.Checksum:
+			p.Kind = ChecksumMismatch
+			p.Detail = fmt.Sprintf("sha256 %s, %s downloaded", checksum, o.Checksum)
+		default:
+			continue
+		}
+		problems = append(problems, p)
+	}
+	return problems, nil
+}
+
+// VerifyCache checks the whole cache. Every catalog entry is verified as
+// Verify does, every stored playlist and manifest is checked for objects it
+// references which are not stored, and stored objects nothing references are
+// reported as orphans. The catalog may be nil, stored playlists which are not
+// cataloged are read with segmentURLPrefix and the WithKeyStrategy option
+func VerifyCache(catalog *Catalog, storage Storage, segmentURLPrefix string, opts ...Option) ([]Problem, error) {
+	keys := newOptions(append([]Option{WithCatalog(catalog)}, opts...)).keys
+	objects, err := storage.List()
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, info := range objects {
+		stored[info.Name] = true
+	}
+	problems := []Problem{}
+	referenced := map[string]bool{}
+	reported := map[string]bool{}
+	prefixes := map[string]string{}
+	if catalog != nil {
+		entries, err := catalog.Entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			found, err := verifyEntry(storage, e)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, found...)
+			for _, p := range found {
+				reported[p.Entry+"/"+p.Object] = true
+			}
+			for _, o := range e.Objects {
+				referenced[o.Name] = true
+			}
+			prefixes[e.Name] = e.Prefix
+		}
+	}
+	for _, info := range objects {
+		prefix, ok := prefixes[info.Name]
+		if !ok {
+			prefix = segmentURLPrefix
+		}
+		isPlaylist, names, err := playlistReferences(storage, info.Name, prefix, keys)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isPlaylist {
+			continue
+		}
+		referenced[info.Name] = true
+		for _, name := range names {
+			referenced[name] = true
+			if !stored[name] && !reported[info.Name+"/"+name] {
+				reported[info.Name+"/"+name] = true
+				problems = append(problems, Problem{Kind: MissingObject, Object: name, Entry: info.Name})
+			}
+		}
+	}
+	for _, info := range objects {
+		if !referenced[info.Name] {
+			problems = append(problems, Problem{Kind: OrphanObject, Object: info.Name, Detail: fmt.Sprintf("%d bytes", info.Size)})
+		}
+	}
+	return problems, nil
+}
+
+// Repair fixes the problems Verify or VerifyCache found. Orphans are deleted
+// and corrupted objects are deleted before their entries are downloaded
+// again, objects already stored are skipped then. Entries which expired or
+// fail to download again are removed with their objects, as are playlists
+// which are neither cataloged nor listed by an entry since their source url
+// is unknown. Deleted objects are reported with a RemoveStatus, every entry
+// with a "repaired" or "failed repair" DownloadStatus. Entries are downloaded
+// again with opts, which should be the options they were first downloaded
+// with such as the variant selector, renditions and key strategy
+func Repair(catalog *Catalog, storage Storage, problems []Problem, ps *pubsub.PubSub, opts ...Option) error {
+	entries := []string{}
+	seen := map[string]bool{}
+	for _, p := range problems {
+		if p.Kind != MissingObject {
+			status := "remove corrupt"
+			if p.Kind == OrphanObject {
+				status = "remove orphan"
+			}
+			if err := storage.Delete(p.Object); err != nil && !os.IsNotExist(err) {
+				ds := RemoveStatus{URL: p.URL, TempFilename: objectPath(storage, p.Object), Progress: "0", Status: "failed segment", Error: err.Error()}
+				ps.Pub(ds, RemoveStatusChannel)
+				return err
+			}
+			ds := RemoveStatus{URL: p.URL, TempFilename: objectPath(storage, p.Object), Progress: "1", Status: status, Error: ""}
+			ps.Pub(ds, RemoveStatusChannel)
+		}
+		if p.Entry != "" && !seen[p.Entry] {
+			seen[p.Entry] = true
+			entries = append(entries, p.Entry)
+		}
+	}
+	for i := 0; i < len(entries); i++ {
+		name := entries[i]
+		var entry *CatalogEntry
+		if catalog != nil {
+			var err error
+			if entry, err = catalog.entryNamed(name); err != nil && err != ErrNotCataloged {
+				return err
+			}
+		}
+		if entry == nil && catalog != nil {
+			// a variant playlist is repaired with the entries listing it
+			_, owners, err := catalog.Object(name)
+			if err != nil && err != ErrNotCataloged {
+				return err
+			}
+			for _, e := range owners {
+				if !seen[e.Name] {
+					seen[e.Name] = true
+					entries = append(entries, e.Name)
+				}
+			}
+			if len(owners) > 0 {
+				continue
+			}
+		}
+		if entry == nil {
+			if err := storage.Delete(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			ds := RemoveStatus{TempFilename: objectPath(storage, name), Progress: "1", Status: "discarded playlist", Error: ""}
+			ps.Pub(ds, RemoveStatusChannel)
+			continue
+		}
+		if err := repairEntry(catalog, storage, entry, ps, opts); err != nil {
+			return err
+		}
+	}
+	log.Debug.Printf("Repaired %v problems of %v entries\n", len(problems), len(entries))
+	return nil
+}
+
+// repairEntry downloads an entry again with opts, removing it when that is
+// not possible
+func repairEntry(catalog *Catalog, storage Storage, entry *CatalogEntry, ps *pubsub.PubSub, opts []Option) error {
+	idf := idAndFile(mustParseURL(entry.URL))
+	err := ErrExpired
+	if !entry.Expired(time.Now()) {
+		download := DownloadHLSPlaylist
+		if entry.Kind == EntryDASH {
+			download = DownloadDASHManifest
+		}
+		err = download(entry.URL, storage, entry.Prefix, ps, append(append([]Option{}, opts...), WithCatalog(catalog))...)
+	}
+	if err == nil {
+		ds := DownloadStatus{URL: entry.URL, ID: idf[0], Segment: idf[1], Prefix: entry.Prefix, TempFilename: entry.Name, Progress: "1", Status: "repaired", Error: ""}
+		ps.Pub(ds, DownloadStatusChannel)
+		return nil
+	}
+	ds := DownloadStatus{URL: entry.URL, ID: idf[0], Segment: idf[1], Prefix: entry.Prefix, TempFilename: entry.Name, Progress: "0", Status: "failed repair", Error: err.Error()}
+	ps.Pub(ds, DownloadStatusChannel)
+	return discardEntry(catalog, storage, entry, ps)
+}
+
+// discardEntry removes an entry and those of its objects no other entry
+// references, the stored playlist is not needed to find them
+func discardEntry(catalog *Catalog, storage Storage, entry *CatalogEntry, ps *pubsub.PubSub) error {
+	if err := catalog.remove(entry.Name); err != nil {
+		return err
+	}
+	for _, o := range entry.Objects {
+		if _, _, err := catalog.Object(o.Name); err != ErrNotCataloged {
+			continue
+		}
+		if err := storage.Delete(o.Name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		ds := RemoveStatus{URL: o.URL, Prefix: entry.Prefix, TempFilename: objectPath(storage, o.Name), Progress: "1", Status: "remove segment", Error: ""}
+		ps.Pub(ds, RemoveStatusChannel)
+	}
+	return nil
+}
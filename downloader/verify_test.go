@@ -0,0 +1,127 @@
+package downloader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cskr/pubsub"
+)
+
+func TestVerifyAndRepair(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXTINF:9,\nsegment-2.ts\n#EXT-X-ENDLIST\n",
+		"/hls/a_trd.mp4/segment-1.ts": "a1",
+		"/hls/a_trd.mp4/segment-2.ts": "a2",
+		"/hls/b_trd.mp4/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXT-X-ENDLIST\n",
+		"/hls/b_trd.mp4/segment-1.ts": "b1",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	name := func(path string) string {
+		return PrefixedHlsFilename(prefix, mustParseURL(origin.URL+path))
+	}
+	kinds := func(problems []Problem) []string {
+		found := []string{}
+		for _, p := range problems {
+			found = append(found, p.Kind)
+		}
+		sort.Strings(found)
+		return found
+	}
+	aURL := origin.URL + "/hls/a_trd.mp4/index.m3u8"
+	for _, url := range []string{aURL, origin.URL + "/hls/b_trd.mp4/index.m3u8"} {
+		if err := DownloadHLSPlaylist(url, storage, prefix, ps, WithCatalog(catalog)); err != nil {
+			t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+		}
+	}
+	if problems, err := VerifyCache(catalog, storage, prefix); err != nil || len(problems) != 0 {
+		t.Fatalf("VerifyCache() = %v, %v, want no problems", problems, err)
+	}
+
+	storage.Put(name("/hls/a_trd.mp4/segment-1.ts"), strings.NewReader("a9"))
+	storage.Delete(name("/hls/a_trd.mp4/segment-2.ts"))
+	storage.Put(name("/hls/b_trd.mp4/segment-1.ts"), strings.NewReader("b"))
+	storage.Put("orphan", strings.NewReader("orphan"))
+
+	problems, err := Verify(catalog, storage, aURL, prefix)
+	if want := []string{ChecksumMismatch, MissingObject}; err != nil || !reflect.DeepEqual(kinds(problems), want) {
+		t.Errorf("Verify() = %v, %v, want %v", problems, err, want)
+	}
+	problems, err = VerifyCache(catalog, storage, prefix)
+	if want := []string{ChecksumMismatch, MissingObject, OrphanObject, SizeMismatch}; err != nil || !reflect.DeepEqual(kinds(problems), want) {
+		t.Errorf("VerifyCache() = %v, %v, want %v", problems, err, want)
+	}
+
+	if err := Repair(catalog, storage, problems, ps); err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+	if problems, err := VerifyCache(catalog, storage, prefix); err != nil || len(problems) != 0 {
+		t.Errorf("VerifyCache() after Repair() = %v, %v, want no problems", problems, err)
+	}
+	if data, _ := readObject(storage, name("/hls/a_trd.mp4/segment-1.ts")); string(data) != "a1" {
+		t.Errorf("repaired segment = %q, want %q", data, "a1")
+	}
+	if _, err := storage.Stat("orphan"); !os.IsNotExist(err) {
+		t.Errorf("orphan not deleted, error = %v", err)
+	}
+}
+
+func TestRepairOptions(t *testing.T) {
+	origin := testOrigin(map[string]string{
+		"/hls/a_trd.mp4/master.m3u8":      "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=64000\nlow/index.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=256000\nhigh/index.m3u8\n",
+		"/hls/a_trd.mp4/low/index.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:9\n#EXTINF:9,\nsegment-1.ts\n#EXT-X-ENDLIST\n",
+		"/hls/a_trd.mp4/low/segment-1.ts": "a1",
+	})
+	defer origin.Close()
+	folder, err := ioutil.TempDir("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	catalog, err := OpenCatalog(filepath.Join(folder, "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer catalog.Close()
+	storage := NewMemoryStorage()
+	prefix := "http://127.0.0.1:7071/cache?r=1&file="
+	ps := pubsub.New(100)
+	lowest := WithVariantSelector(LowestBandwidth())
+	if err := DownloadHLSPlaylist(origin.URL+"/hls/a_trd.mp4/master.m3u8", storage, prefix, ps, WithCatalog(catalog), lowest); err != nil {
+		t.Fatalf("DownloadHLSPlaylist() error = %v", err)
+	}
+	segment := PrefixedHlsFilename(prefix, mustParseURL(origin.URL+"/hls/a_trd.mp4/low/segment-1.ts"))
+	storage.Delete(segment)
+
+	problems, err := VerifyCache(catalog, storage, prefix)
+	if err != nil || len(problems) == 0 {
+		t.Fatalf("VerifyCache() = %v, %v, want the missing segment", problems, err)
+	}
+	// the origin only serves the variant the entry was downloaded with
+	if err := Repair(catalog, storage, problems, ps, lowest); err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+	if data, _ := readObject(storage, segment); string(data) != "a1" {
+		t.Errorf("repaired segment = %q, want %q", data, "a1")
+	}
+	if problems, err := VerifyCache(catalog, storage, prefix); err != nil || len(problems) != 0 {
+		t.Errorf("VerifyCache() after Repair() = %v, %v, want no problems", problems, err)
+	}
+}
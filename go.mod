@@ -7,4 +7,6 @@ require (
 	github.com/cskr/pubsub v1.0.2
 	github.com/minio/sha256-simd v0.1.0
 	github.com/worldiety/goup v0.0.13 // indirect
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/sys v0.10.0 // indirect
 )
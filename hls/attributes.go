@@ -0,0 +1,226 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errBadAttributes = errors.New("malformed attribute list")
+
+type attribute struct {
+	Name  string
+	Value string
+}
+
+// attributeList is an ordered EXT-X attribute list, values are kept raw
+type attributeList []attribute
+
+func parseAttributes(s string) (attributeList, error) {
+	attrs := attributeList{}
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, errBadAttributes
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, errBadAttributes
+			}
+			value = s[:end+2]
+			s = s[end+2:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		attrs = append(attrs, attribute{name, value})
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if len(s) > 0 {
+			return nil, errBadAttributes
+		}
+	}
+	return attrs, nil
+}
+
+func (a attributeList) raw(name string) (string, bool) {
+	for _, attr := range a {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+func (a attributeList) string(name string) string {
+	v, _ := a.raw(name)
+	return strings.Trim(v, `"`)
+}
+
+func (a attributeList) bool(name string) bool {
+	return a.string(name) == "YES"
+}
+
+func (a attributeList) int(name string) (int64, error) {
+	v, ok := a.raw(name)
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return n, nil
+}
+
+func (a attributeList) float(name string) (*float64, error) {
+	v, ok := a.raw(name)
+	if !ok {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return &f, nil
+}
+
+// attributeWriter builds an attribute list, zero values are skipped. The
+// list is merged with source, the attrs a tag type keeps from parsing, so
+// attributes keep their order and those the writer does not know about are
+// written back unchanged
+type attributeWriter struct {
+	source attributeList
+	attrs  attributeList
+	// known holds every attribute name the writer handles, including those
+	// skipped for having a zero value
+	known map[string]bool
+}
+
+func (w *attributeWriter) add(name, value string) {
+	w.know(name)
+	w.attrs = append(w.attrs, attribute{name, value})
+}
+
+func (w *attributeWriter) know(name string) {
+	if w.known == nil {
+		w.known = map[string]bool{}
+	}
+	w.known[name] = true
+}
+
+func (w *attributeWriter) quoted(name, value string) {
+	w.know(name)
+	if value != "" {
+		w.add(name, `"`+value+`"`)
+	}
+}
+
+func (w *attributeWriter) enum(name, value string) {
+	w.know(name)
+	if value != "" {
+		w.add(name, value)
+	}
+}
+
+func (w *attributeWriter) int(name string, value int64) {
+	w.know(name)
+	if value != 0 {
+		w.add(name, strconv.FormatInt(value, 10))
+	}
+}
+
+func (w *attributeWriter) float(name string, value *float64) {
+	w.know(name)
+	if value != nil {
+		w.add(name, formatFloat(*value))
+	}
+}
+
+func (w *attributeWriter) bool(name string, value bool) {
+	w.know(name)
+	if value {
+		w.add(name, "YES")
+	}
+}
+
+func (w *attributeWriter) raw(attrs map[string]string) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.add(name, attrs[name])
+	}
+}
+
+// String merges the written attributes into the source list. Attributes are
+// written where the source had them, with their source value when it is
+// equal to the written one, known attributes which were not written are left
+// out and new ones follow the source attributes
+func (w *attributeWriter) String() string {
+	values := map[string]string{}
+	for _, attr := range w.attrs {
+		values[attr.Name] = attr.Value
+	}
+	merged := attributeList{}
+	done := map[string]bool{}
+	for _, attr := range w.source {
+		value, ok := values[attr.Name]
+		switch {
+		case ok && !done[attr.Name]:
+			if sameValue(attr.Value, value) {
+				value = attr.Value
+			}
+			merged = append(merged, attribute{attr.Name, value})
+			done[attr.Name] = true
+		case !w.known[attr.Name]:
+			merged = append(merged, attr)
+		}
+	}
+	for _, attr := range w.attrs {
+		if !done[attr.Name] {
+			merged = append(merged, attr)
+		}
+	}
+	b := strings.Builder{}
+	for i, attr := range merged {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(attr.Name)
+		b.WriteByte('=')
+		b.WriteString(attr.Value)
+	}
+	return b.String()
+}
+
+// sameValue reports whether two raw attribute values are equal, numbers are
+// compared by value so 30.000 equals 30
+func sameValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	return err == nil && x == y
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,29 @@
+package hls
+
+// ResolveByteRanges fills in every omitted EXT-X-BYTERANGE, EXT-X-MAP and
+// EXT-X-PART offset so each range can be used on its own
+func (p *MediaPlaylist) ResolveByteRanges() {
+	next := map[string]int64{}
+	resolve := func(uri string, br *ByteRange) {
+		if br == nil {
+			return
+		}
+		if !br.HasOffset {
+			br.Offset = next[uri]
+			br.HasOffset = true
+		}
+		next[uri] = br.Offset + br.Length
+	}
+	for _, s := range p.Segments {
+		if s.Map != nil {
+			resolve(s.Map.URI, s.Map.ByteRange)
+		}
+		for _, part := range s.Parts {
+			resolve(part.URI, part.ByteRange)
+		}
+		resolve(s.URI, s.ByteRange)
+	}
+	for _, part := range p.Parts {
+		resolve(part.URI, part.ByteRange)
+	}
+}
@@ -0,0 +1,285 @@
+// Package hls models HLS playlists as described in RFC 8216 and provides a
+// parser and a writer for them.
+package hls
+
+import (
+	"time"
+)
+
+// Playlist is implemented by MasterPlaylist and MediaPlaylist
+type Playlist interface {
+	// Encode returns the playlist in its textual M3U8 form
+	Encode() []byte
+	// Rewrite replaces every URI referenced by the playlist with fn(uri)
+	Rewrite(fn func(uri string) string)
+}
+
+// Media types used by EXT-X-MEDIA
+const (
+	MediaTypeAudio          = "AUDIO"
+	MediaTypeVideo          = "VIDEO"
+	MediaTypeSubtitles      = "SUBTITLES"
+	MediaTypeClosedCaptions = "CLOSED-CAPTIONS"
+)
+
+// Encryption methods used by EXT-X-KEY
+const (
+	KeyMethodNone      = "NONE"
+	KeyMethodAES128    = "AES-128"
+	KeyMethodSampleAES = "SAMPLE-AES"
+)
+
+// Playlist types used by EXT-X-PLAYLIST-TYPE
+const (
+	PlaylistTypeEvent = "EVENT"
+	PlaylistTypeVOD   = "VOD"
+)
+
+// MediaPlaylist is a playlist made up of media segments
+type MediaPlaylist struct {
+	Version               int
+	TargetDuration        int
+	MediaSequence         uint64
+	DiscontinuitySequence uint64
+	PlaylistType          string
+	AllowCache            *bool
+	IFramesOnly           bool
+	IndependentSegments   bool
+	EndList               bool
+	Start                 *Start
+	ServerControl         *ServerControl
+	PartInf               *PartInf
+	Skip                  *Skip
+	Segments              []*Segment
+	// Parts are the partial segments of the segment which is still being
+	// published, they follow the last complete segment
+	Parts            []*Part
+	PreloadHints     []*PreloadHint
+	RenditionReports []*RenditionReport
+	// Unknown holds unrecognised tags and comments found before the first
+	// segment, they are written back unchanged
+	Unknown []string
+	// Trailing holds unrecognised tags and comments found after the last
+	// segment
+	Trailing []string
+	// order lists the tags of the parsed playlist outside of its segments,
+	// they are written back in that order
+	order tagOrder
+	// targetDuration is the parsed EXT-X-TARGETDURATION value
+	targetDuration string
+}
+
+// Segment is a single media segment of a media playlist
+type Segment struct {
+	URI           string
+	Duration      float64
+	Title         string
+	ByteRange     *ByteRange
+	Discontinuity bool
+	// Keys are the EXT-X-KEY tags which appear before this segment, they
+	// apply to this segment and every following one until replaced
+	Keys []*Key
+	// Map is the EXT-X-MAP tag which appears before this segment, it applies
+	// to this segment and every following one until replaced
+	Map             *Map
+	ProgramDateTime time.Time
+	DateRanges      []*DateRange
+	Gap             bool
+	Bitrate         int64
+	// Parts are the partial segments (EXT-X-PART) which make up the segment
+	Parts []*Part
+	// Unknown holds unrecognised tags and comments which precede the segment
+	Unknown []string
+	// order lists the tags of the parsed segment
+	order tagOrder
+	// duration and programDateTime are the parsed EXTINF duration and
+	// EXT-X-PROGRAM-DATE-TIME value
+	duration        string
+	programDateTime string
+}
+
+// ByteRange is a sub-range of a resource as used by EXT-X-BYTERANGE and
+// EXT-X-MAP
+type ByteRange struct {
+	Length int64
+	Offset int64
+	// HasOffset is false when the offset was omitted and the range starts
+	// right after the previous one
+	HasOffset bool
+}
+
+// Key describes how media segments are encrypted (EXT-X-KEY and
+// EXT-X-SESSION-KEY)
+type Key struct {
+	Method            string
+	URI               string
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+	attrs             attributeList
+}
+
+// Map specifies the media initialization section (EXT-X-MAP)
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+	attrs     attributeList
+}
+
+// DateRange associates a range of time with a set of attributes
+// (EXT-X-DATERANGE)
+type DateRange struct {
+	ID              string
+	Class           string
+	StartDate       time.Time
+	EndDate         time.Time
+	Duration        *float64
+	PlannedDuration *float64
+	SCTE35Cmd       string
+	SCTE35Out       string
+	SCTE35In        string
+	EndOnNext       bool
+	// ClientAttributes holds X- prefixed attributes with their values as they
+	// appear in the playlist, including any quotes
+	ClientAttributes map[string]string
+	attrs            attributeList
+}
+
+// Preload hint types used by EXT-X-PRELOAD-HINT
+const (
+	PreloadHintPart = "PART"
+	PreloadHintMap  = "MAP"
+)
+
+// ServerControl lists the Low-Latency HLS delivery directives a server
+// supports (EXT-X-SERVER-CONTROL)
+type ServerControl struct {
+	CanSkipUntil      float64
+	CanSkipDateRanges bool
+	HoldBack          float64
+	PartHoldBack      float64
+	CanBlockReload    bool
+	attrs             attributeList
+}
+
+// PartInf carries the part target duration of a Low-Latency playlist
+// (EXT-X-PART-INF)
+type PartInf struct {
+	PartTarget float64
+	attrs      attributeList
+}
+
+// Skip reports how many segments were left out of a playlist delta update
+// (EXT-X-SKIP)
+type Skip struct {
+	SkippedSegments           uint64
+	RecentlyRemovedDateRanges string
+	attrs                     attributeList
+}
+
+// Part is a partial segment (EXT-X-PART)
+type Part struct {
+	URI         string
+	Duration    float64
+	Independent bool
+	ByteRange   *ByteRange
+	Gap         bool
+	attrs       attributeList
+}
+
+// PreloadHint announces a resource which will be published next
+// (EXT-X-PRELOAD-HINT)
+type PreloadHint struct {
+	Type            string
+	URI             string
+	ByteRangeStart  int64
+	ByteRangeLength *int64
+	attrs           attributeList
+}
+
+// RenditionReport reports the live edge of another rendition
+// (EXT-X-RENDITION-REPORT)
+type RenditionReport struct {
+	URI      string
+	LastMSN  *uint64
+	LastPart *uint64
+	attrs    attributeList
+}
+
+// Start indicates a preferred point at which to start playing (EXT-X-START)
+type Start struct {
+	TimeOffset float64
+	Precise    bool
+	attrs      attributeList
+}
+
+// MasterPlaylist is a playlist which lists the variant streams of a
+// presentation
+type MasterPlaylist struct {
+	Version             int
+	IndependentSegments bool
+	Start               *Start
+	Media               []*Media
+	Variants            []*Variant
+	IFrameVariants      []*Variant
+	SessionData         []*SessionData
+	SessionKeys         []*Key
+	// Unknown holds unrecognised tags and comments, they are written back
+	// where they were found
+	Unknown []string
+	// order lists the tags of the parsed playlist
+	order tagOrder
+}
+
+// Media is an alternative rendition (EXT-X-MEDIA)
+type Media struct {
+	Type            string
+	URI             string
+	GroupID         string
+	Language        string
+	AssocLanguage   string
+	Name            string
+	Default         bool
+	Autoselect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics string
+	Channels        string
+	attrs           attributeList
+}
+
+// Variant is a variant stream (EXT-X-STREAM-INF) or an I-frame variant
+// stream (EXT-X-I-FRAME-STREAM-INF)
+type Variant struct {
+	URI              string
+	Bandwidth        int64
+	AverageBandwidth int64
+	ProgramID        int
+	Codecs           string
+	Resolution       *Resolution
+	FrameRate        float64
+	HDCPLevel        string
+	Audio            string
+	Video            string
+	Subtitles        string
+	// ClosedCaptions is either a GROUP-ID or NONE
+	ClosedCaptions string
+	// Unknown holds unrecognised tags and comments which precede the variant
+	Unknown []string
+	attrs   attributeList
+}
+
+// Resolution is the pixel resolution of a variant
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+// SessionData carries arbitrary session data (EXT-X-SESSION-DATA)
+type SessionData struct {
+	DataID   string
+	Value    string
+	URI      string
+	Language string
+	attrs    attributeList
+}
@@ -0,0 +1,711 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMissingHeader is returned when a playlist does not start with #EXTM3U
+var ErrMissingHeader = errors.New("hls: missing #EXTM3U header")
+
+// ParseError reports a malformed line in a playlist
+type ParseError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("hls: line %d: %v: %q", e.Line, e.Err, e.Text)
+}
+
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+}
+
+type line struct {
+	num  int
+	text string
+}
+
+// tag splits a tag line into its name and value
+func (l line) tag() (string, string) {
+	i := strings.IndexByte(l.text, ':')
+	if i < 0 {
+		return l.text, ""
+	}
+	return l.text[:i], l.text[i+1:]
+}
+
+func (l line) isTag() bool {
+	return strings.HasPrefix(l.text, "#EXT")
+}
+
+func (l line) isComment() bool {
+	return strings.HasPrefix(l.text, "#") && !l.isTag()
+}
+
+func (l line) fail(err error) error {
+	return &ParseError{Line: l.num, Text: l.text, Err: err}
+}
+
+var masterTags = map[string]bool{
+	"#EXT-X-STREAM-INF":         true,
+	"#EXT-X-I-FRAME-STREAM-INF": true,
+	"#EXT-X-MEDIA":              true,
+	"#EXT-X-SESSION-DATA":       true,
+	"#EXT-X-SESSION-KEY":        true,
+}
+
+// Parse parses a master or media playlist
+func Parse(data []byte) (Playlist, error) {
+	return Decode(bytes.NewReader(data))
+}
+
+// Decode reads a master or media playlist from r
+func Decode(r io.Reader) (Playlist, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lines {
+		if name, _ := l.tag(); l.isTag() && masterTags[name] {
+			return decodeMaster(lines)
+		}
+	}
+	return decodeMedia(lines)
+}
+
+// ParseMedia parses data which must be a media playlist
+func ParseMedia(data []byte) (*MediaPlaylist, error) {
+	p, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	media, ok := p.(*MediaPlaylist)
+	if !ok {
+		return nil, errors.New("hls: not a media playlist")
+	}
+	return media, nil
+}
+
+// ParseMaster parses data which must be a master playlist
+func ParseMaster(data []byte) (*MasterPlaylist, error) {
+	p, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	master, ok := p.(*MasterPlaylist)
+	if !ok {
+		return nil, errors.New("hls: not a master playlist")
+	}
+	return master, nil
+}
+
+func readLines(r io.Reader) ([]line, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lines := []line{}
+	num := 0
+	for scanner.Scan() {
+		num++
+		text := strings.TrimSpace(scanner.Text())
+		if num == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+		lines = append(lines, line{num, text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[0].text != "#EXTM3U" {
+		return nil, ErrMissingHeader
+	}
+	return lines[1:], nil
+}
+
+// segmentTags are the media playlist tags which apply to the next segment
+var segmentTags = map[string]bool{
+	"#EXTINF":                  true,
+	"#EXT-X-BYTERANGE":         true,
+	"#EXT-X-DISCONTINUITY":     true,
+	"#EXT-X-KEY":               true,
+	"#EXT-X-MAP":               true,
+	"#EXT-X-PROGRAM-DATE-TIME": true,
+	"#EXT-X-DATERANGE":         true,
+	"#EXT-X-GAP":               true,
+	"#EXT-X-BITRATE":           true,
+	"#EXT-X-PART":              true,
+}
+
+func decodeMedia(lines []line) (*MediaPlaylist, error) {
+	p := &MediaPlaylist{}
+	var seg *Segment
+	// start is where the pending segment is in the order of p
+	start := 0
+	pending := func() *Segment {
+		if seg == nil {
+			seg = &Segment{}
+			start = len(p.order)
+			p.order = append(p.order, segmentTag)
+		}
+		return seg
+	}
+	unknown := func(text string) {
+		if seg == nil && len(p.Segments) == 0 {
+			p.Unknown = append(p.Unknown, text)
+			p.order = append(p.order, unknownTag)
+		} else {
+			pending().Unknown = append(pending().Unknown, text)
+			seg.order = append(seg.order, unknownTag)
+		}
+	}
+	for _, l := range lines {
+		if !l.isTag() {
+			if l.isComment() {
+				unknown(l.text)
+				continue
+			}
+			s := pending()
+			s.URI = l.text
+			p.Segments = append(p.Segments, s)
+			seg = nil
+			continue
+		}
+		name, value := l.tag()
+		var err error
+		switch name {
+		case "#EXT-X-VERSION":
+			p.Version, err = strconv.Atoi(value)
+		case "#EXT-X-TARGETDURATION":
+			var d float64
+			d, err = strconv.ParseFloat(value, 64)
+			p.TargetDuration = int(math.Ceil(d))
+			p.targetDuration = value
+		case "#EXT-X-MEDIA-SEQUENCE":
+			p.MediaSequence, err = strconv.ParseUint(value, 10, 64)
+		case "#EXT-X-DISCONTINUITY-SEQUENCE":
+			p.DiscontinuitySequence, err = strconv.ParseUint(value, 10, 64)
+		case "#EXT-X-PLAYLIST-TYPE":
+			p.PlaylistType = value
+		case "#EXT-X-ALLOW-CACHE":
+			allow := value == "YES"
+			p.AllowCache = &allow
+		case "#EXT-X-I-FRAMES-ONLY":
+			p.IFramesOnly = true
+		case "#EXT-X-INDEPENDENT-SEGMENTS":
+			p.IndependentSegments = true
+		case "#EXT-X-ENDLIST":
+			p.EndList = true
+		case "#EXT-X-START":
+			p.Start, err = parseStart(value)
+		case "#EXT-X-SERVER-CONTROL":
+			p.ServerControl, err = parseServerControl(value)
+		case "#EXT-X-PART-INF":
+			p.PartInf, err = parsePartInf(value)
+		case "#EXT-X-SKIP":
+			p.Skip, err = parseSkip(value)
+		case "#EXT-X-PART":
+			var part *Part
+			part, err = parsePart(value)
+			pending().Parts = append(pending().Parts, part)
+		case "#EXT-X-PRELOAD-HINT":
+			var hint *PreloadHint
+			hint, err = parsePreloadHint(value)
+			p.PreloadHints = append(p.PreloadHints, hint)
+		case "#EXT-X-RENDITION-REPORT":
+			var report *RenditionReport
+			report, err = parseRenditionReport(value)
+			p.RenditionReports = append(p.RenditionReports, report)
+		case "#EXTINF":
+			err = parseExtInf(pending(), value)
+		case "#EXT-X-BYTERANGE":
+			pending().ByteRange, err = parseByteRange(value)
+		case "#EXT-X-DISCONTINUITY":
+			pending().Discontinuity = true
+		case "#EXT-X-KEY":
+			var key *Key
+			key, err = parseKey(value)
+			pending().Keys = append(pending().Keys, key)
+		case "#EXT-X-MAP":
+			pending().Map, err = parseMap(value)
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			pending().ProgramDateTime, err = parseDate(value)
+			seg.programDateTime = value
+		case "#EXT-X-DATERANGE":
+			var dr *DateRange
+			dr, err = parseDateRange(value)
+			pending().DateRanges = append(pending().DateRanges, dr)
+		case "#EXT-X-GAP":
+			pending().Gap = true
+		case "#EXT-X-BITRATE":
+			pending().Bitrate, err = strconv.ParseInt(value, 10, 64)
+		default:
+			unknown(l.text)
+			continue
+		}
+		if err != nil {
+			return nil, l.fail(err)
+		}
+		if segmentTags[name] {
+			seg.order = append(seg.order, name)
+		} else {
+			p.order = append(p.order, name)
+		}
+	}
+	if seg != nil {
+		// the parts and lines after the last segment belong to the playlist
+		p.Parts = seg.Parts
+		p.Trailing = append(p.Trailing, seg.Unknown...)
+		trailing := tagOrder{}
+		for _, name := range seg.order {
+			switch name {
+			case unknownTag:
+				trailing = append(trailing, trailingTag)
+			case "#EXT-X-PART":
+				trailing = append(trailing, name)
+			}
+		}
+		p.order = append(p.order[:start], append(trailing, p.order[start+1:]...)...)
+	}
+	return p, nil
+}
+
+func decodeMaster(lines []line) (*MasterPlaylist, error) {
+	p := &MasterPlaylist{}
+	var variant *Variant
+	// unknown holds the unrecognised lines since the last tag, they belong to
+	// the variant they precede
+	var unknown []string
+	flush := func() {
+		for range unknown {
+			p.order = append(p.order, unknownTag)
+		}
+		p.Unknown = append(p.Unknown, unknown...)
+		unknown = nil
+	}
+	for _, l := range lines {
+		if !l.isTag() {
+			if l.isComment() {
+				unknown = append(unknown, l.text)
+				continue
+			}
+			if variant == nil {
+				return nil, l.fail(errors.New("URI without EXT-X-STREAM-INF"))
+			}
+			variant.URI = l.text
+			variant.Unknown = append(variant.Unknown, unknown...)
+			unknown = nil
+			p.Variants = append(p.Variants, variant)
+			variant = nil
+			continue
+		}
+		name, value := l.tag()
+		var err error
+		switch name {
+		case "#EXT-X-VERSION":
+			p.Version, err = strconv.Atoi(value)
+		case "#EXT-X-INDEPENDENT-SEGMENTS":
+			p.IndependentSegments = true
+		case "#EXT-X-START":
+			p.Start, err = parseStart(value)
+		case "#EXT-X-MEDIA":
+			var media *Media
+			media, err = parseMedia(value)
+			p.Media = append(p.Media, media)
+		case "#EXT-X-STREAM-INF":
+			variant, err = parseVariant(value)
+			if err == nil {
+				variant.Unknown, unknown = unknown, nil
+			}
+		case "#EXT-X-I-FRAME-STREAM-INF":
+			var v *Variant
+			v, err = parseVariant(value)
+			if err == nil {
+				v.URI = v.attrs.string("URI")
+				p.IFrameVariants = append(p.IFrameVariants, v)
+			}
+		case "#EXT-X-SESSION-DATA":
+			var data *SessionData
+			data, err = parseSessionData(value)
+			p.SessionData = append(p.SessionData, data)
+		case "#EXT-X-SESSION-KEY":
+			var key *Key
+			key, err = parseKey(value)
+			p.SessionKeys = append(p.SessionKeys, key)
+		default:
+			unknown = append(unknown, l.text)
+			continue
+		}
+		if err != nil {
+			return nil, l.fail(err)
+		}
+		if name != "#EXT-X-STREAM-INF" {
+			flush()
+		}
+		p.order = append(p.order, name)
+	}
+	flush()
+	return p, nil
+}
+
+func parseExtInf(s *Segment, value string) error {
+	d := value
+	if i := strings.IndexByte(value, ','); i >= 0 {
+		d = value[:i]
+		s.Title = value[i+1:]
+	}
+	s.duration = strings.TrimSpace(d)
+	var err error
+	s.Duration, err = strconv.ParseFloat(s.duration, 64)
+	return err
+}
+
+func parseByteRange(value string) (*ByteRange, error) {
+	br := &ByteRange{}
+	parts := strings.SplitN(value, "@", 2)
+	var err error
+	br.Length, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 2 {
+		br.HasOffset = true
+		br.Offset, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return br, nil
+}
+
+func parseDate(value string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var t time.Time
+		t, err = time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func parseStart(value string) (*Start, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := attrs.float("TIME-OFFSET")
+	if err != nil {
+		return nil, err
+	}
+	if offset == nil {
+		return nil, errors.New("missing TIME-OFFSET")
+	}
+	return &Start{TimeOffset: *offset, Precise: attrs.bool("PRECISE"), attrs: attrs}, nil
+}
+
+func parseKey(value string) (*Key, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{
+		Method:            attrs.string("METHOD"),
+		URI:               attrs.string("URI"),
+		IV:                attrs.string("IV"),
+		KeyFormat:         attrs.string("KEYFORMAT"),
+		KeyFormatVersions: attrs.string("KEYFORMATVERSIONS"),
+		attrs:             attrs,
+	}
+	if key.Method == "" {
+		return nil, errors.New("missing METHOD")
+	}
+	return key, nil
+}
+
+func parseMap(value string) (*Map, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	m := &Map{URI: attrs.string("URI"), attrs: attrs}
+	if m.URI == "" {
+		return nil, errors.New("missing URI")
+	}
+	if br, ok := attrs.raw("BYTERANGE"); ok {
+		m.ByteRange, err = parseByteRange(strings.Trim(br, `"`))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func parseDateRange(value string) (*DateRange, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	dr := &DateRange{
+		ID:        attrs.string("ID"),
+		Class:     attrs.string("CLASS"),
+		SCTE35Cmd: attrs.string("SCTE35-CMD"),
+		SCTE35Out: attrs.string("SCTE35-OUT"),
+		SCTE35In:  attrs.string("SCTE35-IN"),
+		EndOnNext: attrs.bool("END-ON-NEXT"),
+		attrs:     attrs,
+	}
+	if dr.StartDate, err = parseDate(attrs.string("START-DATE")); err != nil {
+		return nil, err
+	}
+	if end := attrs.string("END-DATE"); end != "" {
+		if dr.EndDate, err = parseDate(end); err != nil {
+			return nil, err
+		}
+	}
+	if dr.Duration, err = attrs.float("DURATION"); err != nil {
+		return nil, err
+	}
+	if dr.PlannedDuration, err = attrs.float("PLANNED-DURATION"); err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		if strings.HasPrefix(attr.Name, "X-") {
+			if dr.ClientAttributes == nil {
+				dr.ClientAttributes = map[string]string{}
+			}
+			dr.ClientAttributes[attr.Name] = attr.Value
+		}
+	}
+	return dr, nil
+}
+
+func parseMedia(value string) (*Media, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	media := &Media{
+		Type:            attrs.string("TYPE"),
+		URI:             attrs.string("URI"),
+		GroupID:         attrs.string("GROUP-ID"),
+		Language:        attrs.string("LANGUAGE"),
+		AssocLanguage:   attrs.string("ASSOC-LANGUAGE"),
+		Name:            attrs.string("NAME"),
+		Default:         attrs.bool("DEFAULT"),
+		Autoselect:      attrs.bool("AUTOSELECT"),
+		Forced:          attrs.bool("FORCED"),
+		InstreamID:      attrs.string("INSTREAM-ID"),
+		Characteristics: attrs.string("CHARACTERISTICS"),
+		Channels:        attrs.string("CHANNELS"),
+		attrs:           attrs,
+	}
+	if media.Type == "" || media.GroupID == "" || media.Name == "" {
+		return nil, errors.New("EXT-X-MEDIA requires TYPE, GROUP-ID and NAME")
+	}
+	return media, nil
+}
+
+func parseVariant(value string) (*Variant, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	v := &Variant{
+		Codecs:         attrs.string("CODECS"),
+		HDCPLevel:      attrs.string("HDCP-LEVEL"),
+		Audio:          attrs.string("AUDIO"),
+		Video:          attrs.string("VIDEO"),
+		Subtitles:      attrs.string("SUBTITLES"),
+		ClosedCaptions: attrs.string("CLOSED-CAPTIONS"),
+		attrs:          attrs,
+	}
+	if v.Bandwidth, err = attrs.int("BANDWIDTH"); err != nil {
+		return nil, err
+	}
+	if v.AverageBandwidth, err = attrs.int("AVERAGE-BANDWIDTH"); err != nil {
+		return nil, err
+	}
+	programID, err := attrs.int("PROGRAM-ID")
+	if err != nil {
+		return nil, err
+	}
+	v.ProgramID = int(programID)
+	frameRate, err := attrs.float("FRAME-RATE")
+	if err != nil {
+		return nil, err
+	}
+	if frameRate != nil {
+		v.FrameRate = *frameRate
+	}
+	if res := attrs.string("RESOLUTION"); res != "" {
+		v.Resolution = &Resolution{}
+		if _, err := fmt.Sscanf(res, "%dx%d", &v.Resolution.Width, &v.Resolution.Height); err != nil {
+			return nil, fmt.Errorf("invalid RESOLUTION: %v", err)
+		}
+	}
+	return v, nil
+}
+
+func parseSessionData(value string) (*SessionData, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	data := &SessionData{
+		DataID:   attrs.string("DATA-ID"),
+		Value:    attrs.string("VALUE"),
+		URI:      attrs.string("URI"),
+		Language: attrs.string("LANGUAGE"),
+		attrs:    attrs,
+	}
+	if data.DataID == "" {
+		return nil, errors.New("missing DATA-ID")
+	}
+	return data, nil
+}
+
+func parseServerControl(value string) (*ServerControl, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	sc := &ServerControl{
+		CanSkipDateRanges: attrs.bool("CAN-SKIP-DATERANGES"),
+		CanBlockReload:    attrs.bool("CAN-BLOCK-RELOAD"),
+		attrs:             attrs,
+	}
+	for name, dst := range map[string]*float64{
+		"CAN-SKIP-UNTIL": &sc.CanSkipUntil,
+		"HOLD-BACK":      &sc.HoldBack,
+		"PART-HOLD-BACK": &sc.PartHoldBack,
+	} {
+		f, err := attrs.float(name)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			*dst = *f
+		}
+	}
+	return sc, nil
+}
+
+func parsePartInf(value string) (*PartInf, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	target, err := attrs.float("PART-TARGET")
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, errors.New("missing PART-TARGET")
+	}
+	return &PartInf{PartTarget: *target, attrs: attrs}, nil
+}
+
+func parseSkip(value string) (*Skip, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	skipped, err := attrs.int("SKIPPED-SEGMENTS")
+	if err != nil {
+		return nil, err
+	}
+	return &Skip{
+		SkippedSegments:           uint64(skipped),
+		RecentlyRemovedDateRanges: attrs.string("RECENTLY-REMOVED-DATERANGES"),
+		attrs:                     attrs,
+	}, nil
+}
+
+func parsePart(value string) (*Part, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	part := &Part{
+		URI:         attrs.string("URI"),
+		Independent: attrs.bool("INDEPENDENT"),
+		Gap:         attrs.bool("GAP"),
+		attrs:       attrs,
+	}
+	if part.URI == "" {
+		return nil, errors.New("missing URI")
+	}
+	duration, err := attrs.float("DURATION")
+	if err != nil {
+		return nil, err
+	}
+	if duration == nil {
+		return nil, errors.New("missing DURATION")
+	}
+	part.Duration = *duration
+	if br, ok := attrs.raw("BYTERANGE"); ok {
+		if part.ByteRange, err = parseByteRange(strings.Trim(br, `"`)); err != nil {
+			return nil, err
+		}
+	}
+	return part, nil
+}
+
+func parsePreloadHint(value string) (*PreloadHint, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	hint := &PreloadHint{Type: attrs.string("TYPE"), URI: attrs.string("URI"), attrs: attrs}
+	if hint.Type == "" || hint.URI == "" {
+		return nil, errors.New("EXT-X-PRELOAD-HINT requires TYPE and URI")
+	}
+	if hint.ByteRangeStart, err = attrs.int("BYTERANGE-START"); err != nil {
+		return nil, err
+	}
+	if _, ok := attrs.raw("BYTERANGE-LENGTH"); ok {
+		length, err := attrs.int("BYTERANGE-LENGTH")
+		if err != nil {
+			return nil, err
+		}
+		hint.ByteRangeLength = &length
+	}
+	return hint, nil
+}
+
+func parseRenditionReport(value string) (*RenditionReport, error) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return nil, err
+	}
+	report := &RenditionReport{URI: attrs.string("URI"), attrs: attrs}
+	for name, dst := range map[string]**uint64{
+		"LAST-MSN":  &report.LastMSN,
+		"LAST-PART": &report.LastPart,
+	} {
+		if _, ok := attrs.raw(name); !ok {
+			continue
+		}
+		n, err := attrs.int(name)
+		if err != nil {
+			return nil, err
+		}
+		v := uint64(n)
+		*dst = &v
+	}
+	return report, nil
+}
@@ -0,0 +1,275 @@
+package hls
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// exportedEqual is reflect.DeepEqual leaving out unexported fields, which
+// hold what a parsed playlist remembers of its source
+func exportedEqual(x, y interface{}) bool {
+	return equalValues(reflect.ValueOf(x), reflect.ValueOf(y))
+}
+
+func equalValues(x, y reflect.Value) bool {
+	if x.IsValid() != y.IsValid() || x.Type() != y.Type() {
+		return false
+	}
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			return x.IsNil() == y.IsNil()
+		}
+		return equalValues(x.Elem(), y.Elem())
+	case reflect.Struct:
+		if x.Type().PkgPath() != reflect.TypeOf(Segment{}).PkgPath() {
+			break
+		}
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath == "" && !equalValues(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if x.IsNil() != y.IsNil() || x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !equalValues(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(x.Interface(), y.Interface())
+}
+
+func mediaPlaylist() []byte {
+	return []byte(`#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-TARGETDURATION:9
+#EXT-X-ALLOW-CACHE:YES
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MEDIA-SEQUENCE:1
+#EXT-X-X-VENDOR:1
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/1",IV=0x00000000000000000000000000000001
+#EXT-X-PROGRAM-DATE-TIME:2019-06-25T10:00:00Z
+#EXTINF:9.009,first
+segment-1-a1.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-BYTERANGE:1000@200
+#EXTINF:1.15,
+https://cdn.example.com/segment-2-a1.ts
+#EXT-X-ENDLIST
+`)
+}
+
+func masterPlaylist() []byte {
+	return []byte(`#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="English",DEFAULT=YES,AUTOSELECT=YES,URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AVERAGE-BANDWIDTH=1000000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=640x360,FRAME-RATE=29.97,AUDIO="aac",CLOSED-CAPTIONS=NONE
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2560000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720,AUDIO="aac"
+https://cdn.example.com/high/index.m3u8
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,CODECS="avc1.4d401f",URI="low/iframe.m3u8"
+`)
+}
+
+func TestParseMedia(t *testing.T) {
+	allow := true
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *MediaPlaylist
+		wantErr bool
+	}{
+		{
+			"media",
+			mediaPlaylist(),
+			&MediaPlaylist{
+				Version:        4,
+				TargetDuration: 9,
+				AllowCache:     &allow,
+				PlaylistType:   PlaylistTypeVOD,
+				MediaSequence:  1,
+				EndList:        true,
+				Unknown:        []string{"#EXT-X-X-VENDOR:1"},
+				Segments: []*Segment{
+					{
+						URI:             "segment-1-a1.ts",
+						Duration:        9.009,
+						Title:           "first",
+						Keys:            []*Key{{Method: KeyMethodAES128, URI: "https://keys.example.com/1", IV: "0x00000000000000000000000000000001"}},
+						ProgramDateTime: time.Date(2019, 6, 25, 10, 0, 0, 0, time.UTC),
+					},
+					{
+						URI:           "https://cdn.example.com/segment-2-a1.ts",
+						Duration:      1.15,
+						Discontinuity: true,
+						ByteRange:     &ByteRange{Length: 1000, Offset: 200, HasOffset: true},
+					},
+				},
+			},
+			false,
+		},
+		{
+			"missing header",
+			[]byte("#EXTINF:10,\nsegment.ts\n"),
+			nil,
+			true,
+		},
+		{
+			"bad duration",
+			[]byte("#EXTM3U\n#EXTINF:ten,\nsegment.ts\n"),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMedia(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMedia() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !exportedEqual(got, tt.want) {
+				t.Errorf("ParseMedia() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMaster(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *MasterPlaylist
+		wantErr bool
+	}{
+		{
+			"master",
+			masterPlaylist(),
+			&MasterPlaylist{
+				Version:             4,
+				IndependentSegments: true,
+				Media: []*Media{
+					{Type: MediaTypeAudio, GroupID: "aac", Language: "en", Name: "English", Default: true, Autoselect: true, URI: "audio/en.m3u8"},
+				},
+				Variants: []*Variant{
+					{URI: "low/index.m3u8", Bandwidth: 1280000, AverageBandwidth: 1000000, Codecs: "avc1.4d401f,mp4a.40.2", Resolution: &Resolution{640, 360}, FrameRate: 29.97, Audio: "aac", ClosedCaptions: "NONE"},
+					{URI: "https://cdn.example.com/high/index.m3u8", Bandwidth: 2560000, Codecs: "avc1.4d401f,mp4a.40.2", Resolution: &Resolution{1280, 720}, Audio: "aac"},
+				},
+				IFrameVariants: []*Variant{
+					{URI: "low/iframe.m3u8", Bandwidth: 86000, Codecs: "avc1.4d401f"},
+				},
+			},
+			false,
+		},
+		{
+			"uri without stream inf",
+			[]byte("#EXTM3U\n#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"a\",NAME=\"a\"\nlow.m3u8\n"),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMaster(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMaster() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !exportedEqual(got, tt.want) {
+				t.Errorf("ParseMaster() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    attributeList
+		wantErr bool
+	}{
+		{
+			"quoted comma",
+			`BANDWIDTH=1,CODECS="avc1,mp4a",RESOLUTION=1x1`,
+			attributeList{{"BANDWIDTH", "1"}, {"CODECS", `"avc1,mp4a"`}, {"RESOLUTION", "1x1"}},
+			false,
+		},
+		{
+			"unterminated quote",
+			`URI="abc`,
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAttributes(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseAttributes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !exportedEqual(got, tt.want) {
+				t.Errorf("parseAttributes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func lowLatencyPlaylist() []byte {
+	return []byte(`#EXTM3U
+#EXT-X-TARGETDURATION:4
+#EXT-X-VERSION:6
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=1.0,CAN-SKIP-UNTIL=24.0
+#EXT-X-PART-INF:PART-TARGET=0.33334
+#EXT-X-MEDIA-SEQUENCE:266
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.00008,
+fileSequence266.mp4
+#EXT-X-PART:DURATION=0.33334,URI="filePart267.0.mp4",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.33334,URI="filePart267.1.mp4"
+#EXTINF:0.66668,
+fileSequence267.mp4
+#EXT-X-PART:DURATION=0.33334,URI="filePart268.0.mp4",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.33334,URI="fileSequence268.mp4",BYTERANGE="20000@0"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="filePart268.2.mp4"
+#EXT-X-RENDITION-REPORT:URI="../1M/waitForMSN.php",LAST-MSN=268,LAST-PART=1
+`)
+}
+
+func TestParseLowLatency(t *testing.T) {
+	p, err := ParseMedia(lowLatencyPlaylist())
+	if err != nil {
+		t.Fatalf("ParseMedia() error = %v", err)
+	}
+	if want := (&ServerControl{CanBlockReload: true, PartHoldBack: 1, CanSkipUntil: 24}); !exportedEqual(p.ServerControl, want) {
+		t.Errorf("ServerControl = %+v, want %+v", p.ServerControl, want)
+	}
+	if p.PartInf == nil || p.PartInf.PartTarget != 0.33334 {
+		t.Errorf("PartInf = %+v", p.PartInf)
+	}
+	if len(p.Segments) != 2 || len(p.Segments[1].Parts) != 2 || !p.Segments[1].Parts[0].Independent {
+		t.Errorf("Segments = %+v", p.Segments)
+	}
+	wantParts := []*Part{
+		{URI: "filePart268.0.mp4", Duration: 0.33334, Independent: true},
+		{URI: "fileSequence268.mp4", Duration: 0.33334, ByteRange: &ByteRange{Length: 20000, HasOffset: true}},
+	}
+	if !exportedEqual(p.Parts, wantParts) {
+		t.Errorf("Parts = %+v, want %+v", p.Parts, wantParts)
+	}
+	if len(p.PreloadHints) != 1 || p.PreloadHints[0].Type != PreloadHintPart {
+		t.Errorf("PreloadHints = %+v", p.PreloadHints)
+	}
+	if len(p.RenditionReports) != 1 || *p.RenditionReports[0].LastMSN != 268 || *p.RenditionReports[0].LastPart != 1 {
+		t.Errorf("RenditionReports = %+v", p.RenditionReports)
+	}
+}
@@ -0,0 +1,71 @@
+package hls
+
+import "net/url"
+
+// Rewrite replaces every segment, part, key, map, preload hint and rendition
+// report URI with fn(uri)
+func (p *MediaPlaylist) Rewrite(fn func(uri string) string) {
+	for _, s := range p.Segments {
+		s.URI = fn(s.URI)
+		for _, key := range s.Keys {
+			key.rewrite(fn)
+		}
+		if s.Map != nil {
+			s.Map.URI = fn(s.Map.URI)
+		}
+		for _, part := range s.Parts {
+			part.URI = fn(part.URI)
+		}
+	}
+	for _, part := range p.Parts {
+		part.URI = fn(part.URI)
+	}
+	for _, hint := range p.PreloadHints {
+		hint.URI = fn(hint.URI)
+	}
+	for _, report := range p.RenditionReports {
+		report.URI = fn(report.URI)
+	}
+}
+
+// Rewrite replaces every variant, rendition, session data and session key
+// URI with fn(uri)
+func (p *MasterPlaylist) Rewrite(fn func(uri string) string) {
+	for _, v := range p.Variants {
+		v.URI = fn(v.URI)
+	}
+	for _, v := range p.IFrameVariants {
+		v.URI = fn(v.URI)
+	}
+	for _, m := range p.Media {
+		if m.URI != "" {
+			m.URI = fn(m.URI)
+		}
+	}
+	for _, d := range p.SessionData {
+		if d.URI != "" {
+			d.URI = fn(d.URI)
+		}
+	}
+	for _, key := range p.SessionKeys {
+		key.rewrite(fn)
+	}
+}
+
+func (k *Key) rewrite(fn func(uri string) string) {
+	if k.URI != "" {
+		k.URI = fn(k.URI)
+	}
+}
+
+// ResolveURIs resolves every relative URI in p against base, which should be
+// the URL the playlist was finally retrieved from
+func ResolveURIs(p Playlist, base *url.URL) {
+	p.Rewrite(func(uri string) string {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return uri
+		}
+		return base.ResolveReference(ref).String()
+	})
+}
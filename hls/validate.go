@@ -0,0 +1,397 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity grades a Diagnostic
+type Severity int
+
+const (
+	// Warning marks deprecated or discouraged usage players usually accept
+	Warning Severity = iota
+	// Error marks a violation of a MUST of RFC 8216
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// MarshalText writes the severity by name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic is a finding of Validate
+type Diagnostic struct {
+	// Line is the 1-based line number of the finding
+	Line     int      `json:"line"`
+	Severity Severity `json:"severity"`
+	// Rule names the check which failed, such as "missing-extinf"
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %s: %s [%s]", d.Line, d.Severity, d.Message, d.Rule)
+}
+
+// HasErrors reports whether one of diagnostics is an Error
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaOnlyTags may not appear in a master playlist
+var mediaOnlyTags = map[string]bool{
+	"#EXTINF":                       true,
+	"#EXT-X-TARGETDURATION":         true,
+	"#EXT-X-MEDIA-SEQUENCE":         true,
+	"#EXT-X-DISCONTINUITY-SEQUENCE": true,
+	"#EXT-X-PLAYLIST-TYPE":          true,
+	"#EXT-X-ENDLIST":                true,
+	"#EXT-X-I-FRAMES-ONLY":          true,
+	"#EXT-X-BYTERANGE":              true,
+	"#EXT-X-DISCONTINUITY":          true,
+	"#EXT-X-KEY":                    true,
+	"#EXT-X-MAP":                    true,
+	"#EXT-X-PROGRAM-DATE-TIME":      true,
+	"#EXT-X-PART":                   true,
+	"#EXT-X-PART-INF":               true,
+}
+
+// onceTags may appear at most once in a playlist
+var onceTags = map[string]bool{
+	"#EXT-X-VERSION":                true,
+	"#EXT-X-TARGETDURATION":         true,
+	"#EXT-X-MEDIA-SEQUENCE":         true,
+	"#EXT-X-DISCONTINUITY-SEQUENCE": true,
+	"#EXT-X-PLAYLIST-TYPE":          true,
+	"#EXT-X-I-FRAMES-ONLY":          true,
+	"#EXT-X-ENDLIST":                true,
+	"#EXT-X-INDEPENDENT-SEGMENTS":   true,
+	"#EXT-X-START":                  true,
+	"#EXT-X-SERVER-CONTROL":         true,
+	"#EXT-X-PART-INF":               true,
+}
+
+type validator struct {
+	diagnostics []Diagnostic
+	version     int
+	// requirements holds the first use of each feature needing a version
+	requirements []requirement
+	seen         map[string]bool
+}
+
+type requirement struct {
+	line    line
+	version int
+	feature string
+}
+
+func (v *validator) report(l line, severity Severity, rule, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{Line: l.num, Severity: severity, Rule: rule, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) require(l line, version int, feature string) {
+	for _, r := range v.requirements {
+		if r.feature == feature {
+			return
+		}
+	}
+	v.requirements = append(v.requirements, requirement{l, version, feature})
+}
+
+// Validate checks a master or media playlist against the rules of RFC 8216
+// and returns its findings in line order. A playlist without findings is
+// valid
+func Validate(data []byte) []Diagnostic {
+	v := &validator{version: 1, seen: map[string]bool{}}
+	lines, err := readLines(bytes.NewReader(data))
+	if err != nil {
+		v.report(line{num: 1}, Error, "missing-header", "%v", strings.TrimPrefix(err.Error(), "hls: "))
+		return v.diagnostics
+	}
+	if _, err := Parse(data); err != nil {
+		if pe, ok := err.(*ParseError); ok {
+			v.report(line{num: pe.Line}, Error, "syntax", "%v", pe.Err)
+		}
+	}
+	master := false
+	for _, l := range lines {
+		if name, _ := l.tag(); l.isTag() && masterTags[name] {
+			master = true
+		}
+	}
+	for _, l := range lines {
+		name, value := l.tag()
+		if !l.isTag() {
+			continue
+		}
+		if onceTags[name] {
+			if v.seen[name] {
+				v.report(l, Error, "duplicate-tag", "%s must appear at most once", name)
+			}
+			v.seen[name] = true
+		}
+		if name == "#EXT-X-VERSION" {
+			if n, err := strconv.Atoi(value); err == nil {
+				v.version = n
+			}
+		}
+	}
+	if master {
+		v.validateMaster(lines)
+	} else {
+		v.validateMedia(lines)
+	}
+	for _, r := range v.requirements {
+		if r.version > v.version {
+			v.report(r.line, Error, "version", "%s requires EXT-X-VERSION %d or later, the playlist declares %d", r.feature, r.version, v.version)
+		}
+	}
+	sortDiagnostics(v.diagnostics)
+	return v.diagnostics
+}
+
+func (v *validator) validateMedia(lines []line) {
+	var target *line
+	targetDuration := 0
+	iFramesOnly := v.seen["#EXT-X-I-FRAMES-ONLY"]
+	partInf := v.seen["#EXT-X-PART-INF"]
+	type extinf struct {
+		line     line
+		duration float64
+	}
+	durations := []extinf{}
+	var pending *line
+	var pendingRange *line
+	pendingRangeOffset := false
+	segments := 0
+	previousURI := ""
+	previousRanged := false
+	for _, l := range lines {
+		if !l.isTag() {
+			if l.isComment() {
+				continue
+			}
+			if pending == nil {
+				v.report(l, Error, "missing-extinf", "segment %s is not preceded by EXTINF", l.text)
+			}
+			if pendingRange != nil && !pendingRangeOffset && (!previousRanged || previousURI != l.text) {
+				v.report(*pendingRange, Error, "byterange-offset", "EXT-X-BYTERANGE without an offset must follow a sub-range of the same resource")
+			}
+			previousURI, previousRanged = l.text, pendingRange != nil
+			pending, pendingRange, pendingRangeOffset = nil, nil, false
+			segments++
+			continue
+		}
+		name, value := l.tag()
+		switch name {
+		case "#EXT-X-TARGETDURATION":
+			l := l
+			target = &l
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				v.report(l, Error, "target-duration", "EXT-X-TARGETDURATION must be a decimal integer")
+			}
+			targetDuration = n
+		case "#EXT-X-MEDIA-SEQUENCE", "#EXT-X-DISCONTINUITY-SEQUENCE":
+			if segments > 0 || pending != nil {
+				v.report(l, Error, "tag-position", "%s must appear before the first media segment", name)
+			}
+		case "#EXT-X-PLAYLIST-TYPE":
+			if value != PlaylistTypeEvent && value != PlaylistTypeVOD {
+				v.report(l, Error, "playlist-type", "EXT-X-PLAYLIST-TYPE must be EVENT or VOD")
+			}
+		case "#EXT-X-ALLOW-CACHE":
+			if v.version >= 7 {
+				v.report(l, Warning, "deprecated", "EXT-X-ALLOW-CACHE was removed in protocol version 7")
+			}
+		case "#EXT-X-I-FRAMES-ONLY":
+			v.require(l, 4, "EXT-X-I-FRAMES-ONLY")
+		case "#EXTINF":
+			if pending != nil {
+				v.report(l, Error, "duplicate-tag", "EXTINF must be followed by a segment uri")
+			}
+			l := l
+			pending = &l
+			d := value
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				d = value[:i]
+			}
+			duration, err := strconv.ParseFloat(strings.TrimSpace(d), 64)
+			if err != nil {
+				continue
+			}
+			if strings.Contains(d, ".") {
+				v.require(l, 3, "a floating-point EXTINF duration")
+			}
+			durations = append(durations, extinf{l, duration})
+		case "#EXT-X-BYTERANGE":
+			v.require(l, 4, "EXT-X-BYTERANGE")
+			l := l
+			pendingRange = &l
+			pendingRangeOffset = strings.Contains(value, "@")
+		case "#EXT-X-KEY":
+			v.validateKey(l, value)
+		case "#EXT-X-MAP":
+			if iFramesOnly {
+				v.require(l, 5, "EXT-X-MAP")
+			} else {
+				v.require(l, 6, "EXT-X-MAP without EXT-X-I-FRAMES-ONLY")
+			}
+		case "#EXT-X-PART":
+			if !partInf {
+				v.report(l, Error, "missing-part-inf", "EXT-X-PART requires EXT-X-PART-INF")
+			}
+		default:
+			if masterTags[name] {
+				v.report(l, Error, "mixed-tags", "%s may only appear in a master playlist", name)
+			}
+		}
+	}
+	if pending != nil {
+		v.report(*pending, Error, "missing-uri", "EXTINF is not followed by a segment uri")
+	}
+	if target == nil {
+		v.report(line{num: 1}, Error, "missing-target-duration", "media playlists must contain EXT-X-TARGETDURATION")
+		return
+	}
+	for _, d := range durations {
+		if int(math.Floor(d.duration+0.5)) > targetDuration {
+			v.report(d.line, Error, "target-duration", "segment duration %s exceeds EXT-X-TARGETDURATION %d", strconv.FormatFloat(d.duration, 'f', -1, 64), targetDuration)
+		}
+	}
+}
+
+func (v *validator) validateKey(l line, value string) {
+	attrs, err := parseAttributes(value)
+	if err != nil {
+		return
+	}
+	method := attrs.string("METHOD")
+	_, hasURI := attrs.raw("URI")
+	switch {
+	case method == KeyMethodNone && (hasURI || attrs.string("IV") != ""):
+		v.report(l, Error, "key-attributes", "EXT-X-KEY with METHOD=NONE must not have other attributes")
+	case method != KeyMethodNone && !hasURI:
+		v.report(l, Error, "key-attributes", "EXT-X-KEY with METHOD=%s requires URI", method)
+	}
+	if _, ok := attrs.raw("IV"); ok {
+		v.require(l, 2, "the IV attribute of EXT-X-KEY")
+	}
+	_, keyFormat := attrs.raw("KEYFORMAT")
+	_, keyFormatVersions := attrs.raw("KEYFORMATVERSIONS")
+	if keyFormat || keyFormatVersions {
+		v.require(l, 5, "the KEYFORMAT and KEYFORMATVERSIONS attributes of EXT-X-KEY")
+	}
+}
+
+func (v *validator) validateMaster(lines []line) {
+	groups := map[string]map[string]bool{}
+	names := map[string]bool{}
+	type reference struct {
+		line      line
+		mediaType string
+		group     string
+	}
+	references := []reference{}
+	var streamInf *line
+	for _, l := range lines {
+		if !l.isTag() {
+			if !l.isComment() {
+				streamInf = nil
+			}
+			continue
+		}
+		if streamInf != nil {
+			v.report(*streamInf, Error, "missing-uri", "EXT-X-STREAM-INF must be followed by a uri")
+			streamInf = nil
+		}
+		name, value := l.tag()
+		if mediaOnlyTags[name] {
+			v.report(l, Error, "mixed-tags", "%s may only appear in a media playlist", name)
+			continue
+		}
+		switch name {
+		case "#EXT-X-MEDIA":
+			attrs, err := parseAttributes(value)
+			if err != nil {
+				continue
+			}
+			mediaType, group := attrs.string("TYPE"), attrs.string("GROUP-ID")
+			if groups[mediaType] == nil {
+				groups[mediaType] = map[string]bool{}
+			}
+			groups[mediaType][group] = true
+			if key := mediaType + "\n" + group + "\n" + attrs.string("NAME"); names[key] {
+				v.report(l, Error, "duplicate-name", "EXT-X-MEDIA NAME %q is used twice in group %q", attrs.string("NAME"), group)
+			} else {
+				names[key] = true
+			}
+			if mediaType == MediaTypeClosedCaptions {
+				if _, ok := attrs.raw("URI"); ok {
+					v.report(l, Error, "closed-captions", "EXT-X-MEDIA with TYPE=CLOSED-CAPTIONS must not have a URI")
+				}
+				instreamID := attrs.string("INSTREAM-ID")
+				if instreamID == "" {
+					v.report(l, Error, "closed-captions", "EXT-X-MEDIA with TYPE=CLOSED-CAPTIONS requires INSTREAM-ID")
+				} else if strings.HasPrefix(instreamID, "SERVICE") {
+					v.require(l, 7, "a SERVICE value of INSTREAM-ID")
+				}
+			}
+		case "#EXT-X-STREAM-INF", "#EXT-X-I-FRAME-STREAM-INF":
+			attrs, err := parseAttributes(value)
+			if err != nil {
+				continue
+			}
+			if _, ok := attrs.raw("BANDWIDTH"); !ok {
+				v.report(l, Error, "missing-bandwidth", "%s requires BANDWIDTH", name)
+			}
+			if _, ok := attrs.raw("PROGRAM-ID"); ok && v.version >= 6 {
+				v.report(l, Warning, "deprecated", "PROGRAM-ID was removed in protocol version 6")
+			}
+			if name == "#EXT-X-I-FRAME-STREAM-INF" {
+				if attrs.string("URI") == "" {
+					v.report(l, Error, "missing-uri", "EXT-X-I-FRAME-STREAM-INF requires URI")
+				}
+			} else {
+				l := l
+				streamInf = &l
+			}
+			for attr, mediaType := range map[string]string{"AUDIO": MediaTypeAudio, "VIDEO": MediaTypeVideo, "SUBTITLES": MediaTypeSubtitles, "CLOSED-CAPTIONS": MediaTypeClosedCaptions} {
+				if raw, ok := attrs.raw(attr); ok && raw != "NONE" {
+					references = append(references, reference{l, mediaType, attrs.string(attr)})
+				}
+			}
+		}
+	}
+	if streamInf != nil {
+		v.report(*streamInf, Error, "missing-uri", "EXT-X-STREAM-INF must be followed by a uri")
+	}
+	for _, r := range references {
+		if !groups[r.mediaType][r.group] {
+			v.report(r.line, Error, "missing-group", "no EXT-X-MEDIA with TYPE=%s and GROUP-ID %q", r.mediaType, r.group)
+		}
+	}
+}
+
+func sortDiagnostics(diagnostics []Diagnostic) {
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+}
@@ -0,0 +1,65 @@
+package hls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type finding struct {
+		Line int
+		Rule string
+	}
+	tests := []struct {
+		name string
+		data string
+		want []finding
+	}{
+		{"valid media", string(mediaPlaylist()), []finding{}},
+		{"valid master", string(masterPlaylist()), []finding{}},
+		{"valid low latency", string(lowLatencyPlaylist()), []finding{}},
+		{"missing header", "#EXTINF:1,\na.ts\n", []finding{{1, "missing-header"}}},
+		{
+			"segment problems",
+			"#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\na.ts\nb.ts\n#EXTINF:5,\nc.ts\n#EXT-X-MEDIA-SEQUENCE:3\n#EXTINF:4,\n",
+			[]finding{{5, "missing-extinf"}, {6, "target-duration"}, {8, "tag-position"}, {9, "missing-uri"}},
+		},
+		{
+			"version",
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.0,\n#EXT-X-BYTERANGE:100\na.mp4\n",
+			[]finding{{4, "version"}, {6, "byterange-offset"}, {6, "version"}},
+		},
+		{
+			"missing target duration",
+			"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-VERSION:3\n#EXTINF:4,\na.ts\n",
+			[]finding{{1, "missing-target-duration"}, {3, "duplicate-tag"}},
+		},
+		{
+			"key",
+			"#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-KEY:METHOD=AES-128\n#EXTINF:4,\na.ts\n",
+			[]finding{{3, "key-attributes"}},
+		},
+		{
+			"master problems",
+			"#EXTM3U\n#EXT-X-VERSION:6\n#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID=\"cc\",NAME=\"en\",INSTREAM-ID=\"SERVICE1\"\n#EXT-X-STREAM-INF:BANDWIDTH=1,PROGRAM-ID=1,AUDIO=\"aac\",CLOSED-CAPTIONS=\"cc\"\n#EXT-X-STREAM-INF:BANDWIDTH=2\nhigh.m3u8\n#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=1\nlow.m3u8\n#EXTINF:4,\n",
+			[]finding{{3, "version"}, {4, "deprecated"}, {4, "missing-uri"}, {4, "missing-group"}, {7, "missing-bandwidth"}, {9, "mixed-tags"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []finding{}
+			for _, d := range Validate([]byte(tt.data)) {
+				got = append(got, finding{d.Line, d.Rule})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	if HasErrors([]Diagnostic{{Severity: Warning}}) || !HasErrors([]Diagnostic{{Severity: Warning}, {Severity: Error}}) {
+		t.Errorf("HasErrors() does not only report errors")
+	}
+}
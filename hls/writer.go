@@ -0,0 +1,413 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Markers used in a tagOrder besides tag names
+const (
+	// unknownTag stands for the next unrecognised tag or comment
+	unknownTag = ""
+	// trailingTag stands for the next line of MediaPlaylist.Trailing
+	trailingTag = "trailing"
+	// segmentTag stands for the next segment of a media playlist
+	segmentTag = "segment"
+)
+
+// tagOrder lists the tags of a parsed playlist or segment as they appeared
+type tagOrder []string
+
+func (o tagOrder) has(name string) bool {
+	for _, n := range o {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tagWriter collects the lines of a playlist or a segment by tag, they are
+// written in the order the tags were parsed in. Tags the parsed playlist did
+// not have follow in the order they were collected
+type tagWriter struct {
+	names []string
+	lines map[string][]string
+}
+
+func (w *tagWriter) add(name, text string) {
+	if w.lines == nil {
+		w.lines = map[string][]string{}
+	}
+	if _, ok := w.lines[name]; !ok {
+		w.names = append(w.names, name)
+	}
+	w.lines[name] = append(w.lines[name], text)
+}
+
+func (w *tagWriter) tag(name string, value string) {
+	if value != "" {
+		w.add(name, name+":"+value)
+	} else {
+		w.add(name, name)
+	}
+}
+
+func (w *tagWriter) unknown(name string, lines []string) {
+	for _, l := range lines {
+		w.add(name, l)
+	}
+}
+
+func (w *tagWriter) writeTo(b *bytes.Buffer, order tagOrder) {
+	write := func(name string, n int) {
+		lines := w.lines[name]
+		if n > len(lines) {
+			n = len(lines)
+		}
+		for _, l := range lines[:n] {
+			b.WriteString(l)
+			b.WriteByte('\n')
+		}
+		w.lines[name] = lines[n:]
+	}
+	for _, name := range order {
+		write(name, 1)
+	}
+	for _, name := range w.names {
+		write(name, len(w.lines[name]))
+	}
+}
+
+func formatByteRange(br *ByteRange) string {
+	if br.HasOffset {
+		return fmt.Sprintf("%d@%d", br.Length, br.Offset)
+	}
+	return strconv.FormatInt(br.Length, 10)
+}
+
+func formatDate(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatTargetDuration is the EXT-X-TARGETDURATION value of p, the parsed
+// value when it was not changed
+func (p *MediaPlaylist) formatTargetDuration() string {
+	if d, err := strconv.ParseFloat(p.targetDuration, 64); err == nil && int(math.Ceil(d)) == p.TargetDuration {
+		return p.targetDuration
+	}
+	return strconv.Itoa(p.TargetDuration)
+}
+
+func (s *Start) attributes() string {
+	w := &attributeWriter{source: s.attrs}
+	w.add("TIME-OFFSET", formatFloat(s.TimeOffset))
+	w.bool("PRECISE", s.Precise)
+	return w.String()
+}
+
+func (k *Key) attributes() string {
+	w := &attributeWriter{source: k.attrs}
+	w.enum("METHOD", k.Method)
+	w.quoted("URI", k.URI)
+	w.enum("IV", k.IV)
+	w.quoted("KEYFORMAT", k.KeyFormat)
+	w.quoted("KEYFORMATVERSIONS", k.KeyFormatVersions)
+	return w.String()
+}
+
+func (m *Map) attributes() string {
+	w := &attributeWriter{source: m.attrs}
+	w.quoted("URI", m.URI)
+	if m.ByteRange != nil {
+		w.quoted("BYTERANGE", formatByteRange(m.ByteRange))
+	}
+	return w.String()
+}
+
+func (d *DateRange) attributes() string {
+	w := &attributeWriter{source: d.attrs}
+	w.quoted("ID", d.ID)
+	w.quoted("CLASS", d.Class)
+	w.quoted("START-DATE", formatDate(d.StartDate))
+	if !d.EndDate.IsZero() {
+		w.quoted("END-DATE", formatDate(d.EndDate))
+	}
+	w.float("DURATION", d.Duration)
+	w.float("PLANNED-DURATION", d.PlannedDuration)
+	w.raw(d.ClientAttributes)
+	w.enum("SCTE35-CMD", d.SCTE35Cmd)
+	w.enum("SCTE35-OUT", d.SCTE35Out)
+	w.enum("SCTE35-IN", d.SCTE35In)
+	w.bool("END-ON-NEXT", d.EndOnNext)
+	return w.String()
+}
+
+func (sc *ServerControl) attributes() string {
+	w := &attributeWriter{source: sc.attrs}
+	if sc.CanSkipUntil > 0 {
+		w.float("CAN-SKIP-UNTIL", &sc.CanSkipUntil)
+	}
+	w.bool("CAN-SKIP-DATERANGES", sc.CanSkipDateRanges)
+	if sc.HoldBack > 0 {
+		w.float("HOLD-BACK", &sc.HoldBack)
+	}
+	if sc.PartHoldBack > 0 {
+		w.float("PART-HOLD-BACK", &sc.PartHoldBack)
+	}
+	w.bool("CAN-BLOCK-RELOAD", sc.CanBlockReload)
+	return w.String()
+}
+
+func (p *PartInf) attributes() string {
+	w := &attributeWriter{source: p.attrs}
+	w.add("PART-TARGET", formatFloat(p.PartTarget))
+	return w.String()
+}
+
+func (s *Skip) attributes() string {
+	w := &attributeWriter{source: s.attrs}
+	w.add("SKIPPED-SEGMENTS", strconv.FormatUint(s.SkippedSegments, 10))
+	w.quoted("RECENTLY-REMOVED-DATERANGES", s.RecentlyRemovedDateRanges)
+	return w.String()
+}
+
+func (p *Part) attributes() string {
+	w := &attributeWriter{source: p.attrs}
+	w.add("DURATION", formatFloat(p.Duration))
+	w.quoted("URI", p.URI)
+	w.bool("INDEPENDENT", p.Independent)
+	if p.ByteRange != nil {
+		w.quoted("BYTERANGE", formatByteRange(p.ByteRange))
+	}
+	w.bool("GAP", p.Gap)
+	return w.String()
+}
+
+func (h *PreloadHint) attributes() string {
+	w := &attributeWriter{source: h.attrs}
+	w.enum("TYPE", h.Type)
+	w.quoted("URI", h.URI)
+	w.int("BYTERANGE-START", h.ByteRangeStart)
+	if h.ByteRangeLength != nil {
+		w.add("BYTERANGE-LENGTH", strconv.FormatInt(*h.ByteRangeLength, 10))
+	}
+	return w.String()
+}
+
+func (r *RenditionReport) attributes() string {
+	w := &attributeWriter{source: r.attrs}
+	w.quoted("URI", r.URI)
+	if r.LastMSN != nil {
+		w.add("LAST-MSN", strconv.FormatUint(*r.LastMSN, 10))
+	}
+	if r.LastPart != nil {
+		w.add("LAST-PART", strconv.FormatUint(*r.LastPart, 10))
+	}
+	return w.String()
+}
+
+func (m *Media) attributes() string {
+	w := &attributeWriter{source: m.attrs}
+	w.enum("TYPE", m.Type)
+	w.quoted("URI", m.URI)
+	w.quoted("GROUP-ID", m.GroupID)
+	w.quoted("LANGUAGE", m.Language)
+	w.quoted("ASSOC-LANGUAGE", m.AssocLanguage)
+	w.quoted("NAME", m.Name)
+	w.bool("DEFAULT", m.Default)
+	w.bool("AUTOSELECT", m.Autoselect)
+	w.bool("FORCED", m.Forced)
+	w.quoted("INSTREAM-ID", m.InstreamID)
+	w.quoted("CHARACTERISTICS", m.Characteristics)
+	w.quoted("CHANNELS", m.Channels)
+	return w.String()
+}
+
+func (v *Variant) attributes(iframe bool) string {
+	w := &attributeWriter{source: v.attrs}
+	w.int("PROGRAM-ID", int64(v.ProgramID))
+	w.int("BANDWIDTH", v.Bandwidth)
+	w.int("AVERAGE-BANDWIDTH", v.AverageBandwidth)
+	w.quoted("CODECS", v.Codecs)
+	if v.Resolution != nil {
+		w.add("RESOLUTION", fmt.Sprintf("%dx%d", v.Resolution.Width, v.Resolution.Height))
+	}
+	if v.FrameRate != 0 {
+		w.add("FRAME-RATE", formatFloat(v.FrameRate))
+	}
+	w.enum("HDCP-LEVEL", v.HDCPLevel)
+	w.quoted("VIDEO", v.Video)
+	if iframe {
+		w.quoted("URI", v.URI)
+		return w.String()
+	}
+	w.quoted("AUDIO", v.Audio)
+	w.quoted("SUBTITLES", v.Subtitles)
+	if v.ClosedCaptions == "NONE" {
+		w.enum("CLOSED-CAPTIONS", v.ClosedCaptions)
+	} else {
+		w.quoted("CLOSED-CAPTIONS", v.ClosedCaptions)
+	}
+	return w.String()
+}
+
+func (d *SessionData) attributes() string {
+	w := &attributeWriter{source: d.attrs}
+	w.quoted("DATA-ID", d.DataID)
+	w.quoted("VALUE", d.Value)
+	w.quoted("URI", d.URI)
+	w.quoted("LANGUAGE", d.Language)
+	return w.String()
+}
+
+// Encode returns the media playlist in M3U8 form. A parsed playlist is
+// written back with its tags, comments and attributes in their order
+func (p *MediaPlaylist) Encode() []byte {
+	w := &tagWriter{}
+	if p.Version > 0 {
+		w.tag("#EXT-X-VERSION", strconv.Itoa(p.Version))
+	}
+	w.tag("#EXT-X-TARGETDURATION", p.formatTargetDuration())
+	if p.ServerControl != nil {
+		w.tag("#EXT-X-SERVER-CONTROL", p.ServerControl.attributes())
+	}
+	if p.PartInf != nil {
+		w.tag("#EXT-X-PART-INF", p.PartInf.attributes())
+	}
+	if p.AllowCache != nil {
+		allow := "NO"
+		if *p.AllowCache {
+			allow = "YES"
+		}
+		w.tag("#EXT-X-ALLOW-CACHE", allow)
+	}
+	if p.PlaylistType != "" {
+		w.tag("#EXT-X-PLAYLIST-TYPE", p.PlaylistType)
+	}
+	if p.MediaSequence > 0 || p.order.has("#EXT-X-MEDIA-SEQUENCE") {
+		w.tag("#EXT-X-MEDIA-SEQUENCE", strconv.FormatUint(p.MediaSequence, 10))
+	}
+	if p.DiscontinuitySequence > 0 || p.order.has("#EXT-X-DISCONTINUITY-SEQUENCE") {
+		w.tag("#EXT-X-DISCONTINUITY-SEQUENCE", strconv.FormatUint(p.DiscontinuitySequence, 10))
+	}
+	if p.IFramesOnly {
+		w.tag("#EXT-X-I-FRAMES-ONLY", "")
+	}
+	if p.IndependentSegments {
+		w.tag("#EXT-X-INDEPENDENT-SEGMENTS", "")
+	}
+	if p.Start != nil {
+		w.tag("#EXT-X-START", p.Start.attributes())
+	}
+	w.unknown(unknownTag, p.Unknown)
+	if p.Skip != nil {
+		w.tag("#EXT-X-SKIP", p.Skip.attributes())
+	}
+	for _, s := range p.Segments {
+		w.add(segmentTag, s.encode())
+	}
+	for _, part := range p.Parts {
+		w.tag("#EXT-X-PART", part.attributes())
+	}
+	w.unknown(trailingTag, p.Trailing)
+	for _, hint := range p.PreloadHints {
+		w.tag("#EXT-X-PRELOAD-HINT", hint.attributes())
+	}
+	for _, report := range p.RenditionReports {
+		w.tag("#EXT-X-RENDITION-REPORT", report.attributes())
+	}
+	if p.EndList {
+		w.tag("#EXT-X-ENDLIST", "")
+	}
+	b := &bytes.Buffer{}
+	b.WriteString("#EXTM3U\n")
+	w.writeTo(b, p.order)
+	return b.Bytes()
+}
+
+// encode returns the lines of the segment
+func (s *Segment) encode() string {
+	w := &tagWriter{}
+	w.unknown(unknownTag, s.Unknown)
+	if s.Discontinuity {
+		w.tag("#EXT-X-DISCONTINUITY", "")
+	}
+	for _, key := range s.Keys {
+		w.tag("#EXT-X-KEY", key.attributes())
+	}
+	if s.Map != nil {
+		w.tag("#EXT-X-MAP", s.Map.attributes())
+	}
+	if !s.ProgramDateTime.IsZero() {
+		date := formatDate(s.ProgramDateTime)
+		if t, err := parseDate(s.programDateTime); err == nil && t.Equal(s.ProgramDateTime) {
+			date = s.programDateTime
+		}
+		w.tag("#EXT-X-PROGRAM-DATE-TIME", date)
+	}
+	for _, dr := range s.DateRanges {
+		w.tag("#EXT-X-DATERANGE", dr.attributes())
+	}
+	if s.Gap {
+		w.tag("#EXT-X-GAP", "")
+	}
+	if s.Bitrate > 0 {
+		w.tag("#EXT-X-BITRATE", strconv.FormatInt(s.Bitrate, 10))
+	}
+	for _, part := range s.Parts {
+		w.tag("#EXT-X-PART", part.attributes())
+	}
+	duration := formatFloat(s.Duration)
+	if d, err := strconv.ParseFloat(s.duration, 64); err == nil && d == s.Duration {
+		duration = s.duration
+	}
+	w.tag("#EXTINF", duration+","+s.Title)
+	if s.ByteRange != nil {
+		w.tag("#EXT-X-BYTERANGE", formatByteRange(s.ByteRange))
+	}
+	b := &bytes.Buffer{}
+	w.writeTo(b, s.order)
+	b.WriteString(s.URI)
+	return b.String()
+}
+
+// Encode returns the master playlist in M3U8 form. A parsed playlist is
+// written back with its tags, comments and attributes in their order
+func (p *MasterPlaylist) Encode() []byte {
+	w := &tagWriter{}
+	if p.Version > 0 {
+		w.tag("#EXT-X-VERSION", strconv.Itoa(p.Version))
+	}
+	if p.IndependentSegments {
+		w.tag("#EXT-X-INDEPENDENT-SEGMENTS", "")
+	}
+	if p.Start != nil {
+		w.tag("#EXT-X-START", p.Start.attributes())
+	}
+	for _, d := range p.SessionData {
+		w.tag("#EXT-X-SESSION-DATA", d.attributes())
+	}
+	for _, k := range p.SessionKeys {
+		w.tag("#EXT-X-SESSION-KEY", k.attributes())
+	}
+	for _, m := range p.Media {
+		w.tag("#EXT-X-MEDIA", m.attributes())
+	}
+	for _, v := range p.Variants {
+		lines := append(append([]string{}, v.Unknown...), "#EXT-X-STREAM-INF:"+v.attributes(false), v.URI)
+		w.add("#EXT-X-STREAM-INF", strings.Join(lines, "\n"))
+	}
+	for _, v := range p.IFrameVariants {
+		w.tag("#EXT-X-I-FRAME-STREAM-INF", v.attributes(true))
+	}
+	w.unknown(unknownTag, p.Unknown)
+	b := &bytes.Buffer{}
+	b.WriteString("#EXTM3U\n")
+	w.writeTo(b, p.order)
+	return b.Bytes()
+}
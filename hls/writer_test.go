@@ -0,0 +1,128 @@
+package hls
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"media", mediaPlaylist()},
+		{"master", masterPlaylist()},
+		{"low latency", lowLatencyPlaylist()},
+		{"daterange", []byte(`#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-DATERANGE:ID="ad",START-DATE="2019-06-25T10:00:00.5Z",DURATION=30,X-AD-ID="1234",SCTE35-OUT=0xFC01
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+#EXT-X-GAP
+#EXTINF:6,
+gap.m4s
+`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := Parse(tt.data)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			encoded := want.Encode()
+			got, err := Parse(encoded)
+			if err != nil {
+				t.Fatalf("Parse(Encode()) error = %v\n%s", err, encoded)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Parse(Encode()) = %+v, want %+v", got, want)
+			}
+			if again := got.Encode(); !reflect.DeepEqual(again, encoded) {
+				t.Errorf("Encode() = %s, want %s", again, encoded)
+			}
+		})
+	}
+}
+
+func TestEncodeLossless(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"media", string(mediaPlaylist())},
+		{"master", string(masterPlaylist())},
+		{"low latency", string(lowLatencyPlaylist())},
+		{"no media sequence", "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.000,\na.ts\n#EXT-X-ENDLIST\n"},
+		{"decimal target duration", "#EXTM3U\n#EXT-X-TARGETDURATION:9.5\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:9.500,\na.ts\n"},
+		{"tag order", "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:3\n# generated\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:4\n#EXTINF:4,\n#EXT-X-PROGRAM-DATE-TIME:2019-06-25T10:00:00.000+00:00\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\",X-VENDOR=\"v\",IV=0x01\na.ts\n#EXT-X-ENDLIST\n"},
+		{"unknown attributes", `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,VIDEO-RANGE=PQ,CODECS="hvc1.2.4.L93.90",SCORE=2.0,SUPPLEMENTAL-CODECS="dvh1.08.01/db4h",FRAME-RATE=30.000
+hdr/index.m3u8
+`},
+		{"master order", `#EXTM3U
+# variants
+#EXT-X-X-VENDOR:1
+#EXT-X-STREAM-INF:BANDWIDTH=1280000
+low/index.m3u8
+#EXT-X-CONTENT-STEERING:SERVER-URI="/steering"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="audio/en.m3u8"
+#EXT-X-VERSION:4
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := string(p.Encode()); got != tt.data {
+				t.Errorf("Encode() = %s, want %s", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestEncodeRewritten(t *testing.T) {
+	p, _ := ParseMaster([]byte(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,VIDEO-RANGE=PQ,CODECS="hvc1.2.4.L93.90"
+hdr/index.m3u8
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,X-VENDOR="v",URI="skd://key"
+`))
+	p.Rewrite(func(uri string) string { return "/cache?file=" + uri })
+	p.Variants[0].Bandwidth = 2560000
+	p.Variants[0].Resolution = &Resolution{Width: 1920, Height: 1080}
+	want := `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=2560000,VIDEO-RANGE=PQ,CODECS="hvc1.2.4.L93.90",RESOLUTION=1920x1080
+/cache?file=hdr/index.m3u8
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,X-VENDOR="v",URI="/cache?file=skd://key"
+`
+	if got := string(p.Encode()); got != want {
+		t.Errorf("Encode() = %s, want %s", got, want)
+	}
+}
+
+func TestMediaPlaylist_Encode(t *testing.T) {
+	p := &MediaPlaylist{
+		Version:        3,
+		TargetDuration: 10,
+		Segments: []*Segment{
+			{URI: "a.ts", Duration: 9.5, Keys: []*Key{{Method: KeyMethodAES128, URI: "k"}}},
+			{URI: "b.ts", Duration: 10, Discontinuity: true, ByteRange: &ByteRange{Length: 1000, Offset: 200, HasOffset: true}},
+		},
+		EndList: true,
+	}
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-KEY:METHOD=AES-128,URI="k"
+#EXTINF:9.5,
+a.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:10,
+#EXT-X-BYTERANGE:1000@200
+b.ts
+#EXT-X-ENDLIST
+`
+	if got := string(p.Encode()); got != want {
+		t.Errorf("Encode() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package mp4
+
+import "errors"
+
+// ErrBadADTS is returned for AAC streams without valid ADTS headers
+var ErrBadADTS = errors.New("mp4: malformed ADTS frame")
+
+// AACFrameSamples is the number of PCM samples an AAC frame decodes to
+const AACFrameSamples = 1024
+
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ADTSFrame is an AAC frame of an ADTS stream
+type ADTSFrame struct {
+	// Config is the AudioSpecificConfig of the frame
+	Config     []byte
+	SampleRate int
+	Channels   int
+	// Data is the raw AAC frame without its ADTS header
+	Data []byte
+}
+
+// SplitADTS splits an ADTS stream into its AAC frames
+func SplitADTS(b []byte) ([]ADTSFrame, error) {
+	frames := []ADTSFrame{}
+	for len(b) > 0 {
+		if len(b) < 7 || b[0] != 0xff || b[1]&0xf0 != 0xf0 {
+			return frames, ErrBadADTS
+		}
+		headerLength := 7
+		if b[1]&0x01 == 0 {
+			// the header carries a CRC
+			headerLength = 9
+		}
+		object := b[2]>>6 + 1
+		rate := b[2] >> 2 & 0x0f
+		channels := b[2]&0x01<<2 | b[3]>>6
+		length := int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5
+		if int(rate) >= len(sampleRates) || length < headerLength || length > len(b) {
+			return frames, ErrBadADTS
+		}
+		frames = append(frames, ADTSFrame{
+			Config:     []byte{object<<3 | rate>>1, rate<<7 | channels<<3},
+			SampleRate: sampleRates[rate],
+			Channels:   int(channels),
+			Data:       b[headerLength:length],
+		})
+		b = b[length:]
+	}
+	return frames, nil
+}
+
+// AACSampleEntry is the mp4a sample entry of an AAC track with its
+// AudioSpecificConfig
+func AACSampleEntry(config []byte, channels, sampleRate int) []byte {
+	entry := make([]byte, 28)
+	entry[7] = 1 // data reference index
+	copy(entry[16:], be16(channels))
+	copy(entry[18:], be16(16))
+	copy(entry[24:], be16(sampleRate))
+	decoderConfig := append([]byte{0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, descriptor(0x05, config)...)
+	es := append([]byte{0, 0, 0}, descriptor(0x04, decoderConfig)...)
+	es = append(es, descriptor(0x06, []byte{0x02})...)
+	return box("mp4a", entry, fullBox("esds", 0, 0, descriptor(0x03, es)))
+}
+
+// descriptor is an MPEG-4 descriptor with a single byte length
+func descriptor(tag byte, payload []byte) []byte {
+	return append([]byte{tag, byte(len(payload))}, payload...)
+}
@@ -0,0 +1,252 @@
+package mp4
+
+import "errors"
+
+// ErrBadSPS is returned for H.264 sequence parameter sets which can not be
+// parsed
+var ErrBadSPS = errors.New("mp4: malformed H.264 sequence parameter set")
+
+// H.264 NAL unit types
+const (
+	NALUnitIDR = 5
+	NALUnitSPS = 7
+	NALUnitPPS = 8
+	NALUnitAUD = 9
+)
+
+// SplitAnnexB splits an H.264 Annex B byte stream into its NAL units
+func SplitAnnexB(b []byte) [][]byte {
+	units := [][]byte{}
+	start := -1
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			units = appendNALUnit(units, b[start:i])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 {
+		units = appendNALUnit(units, b[start:])
+	}
+	return units
+}
+
+// appendNALUnit appends a NAL unit without the zero bytes which precede the
+// next start code
+func appendNALUnit(units [][]byte, u []byte) [][]byte {
+	for len(u) > 0 && u[len(u)-1] == 0 {
+		u = u[:len(u)-1]
+	}
+	if len(u) == 0 {
+		return units
+	}
+	return append(units, u)
+}
+
+// AVCSample is an H.264 access unit converted to an MP4 sample
+type AVCSample struct {
+	// Data holds the NAL units of the access unit prefixed by their 4 byte
+	// length, parameter sets and access unit delimiters are left out
+	Data []byte
+	// SPS and PPS are the parameter sets the access unit carries
+	SPS, PPS []byte
+	// Sync is true for access units with an IDR picture
+	Sync bool
+}
+
+// ParseAVCAccessUnit converts an H.264 access unit in Annex B format to an MP4
+// sample
+func ParseAVCAccessUnit(b []byte) *AVCSample {
+	s := &AVCSample{}
+	for _, u := range SplitAnnexB(b) {
+		switch u[0] & 0x1f {
+		case NALUnitSPS:
+			s.SPS = u
+			continue
+		case NALUnitPPS:
+			s.PPS = u
+			continue
+		case NALUnitAUD:
+			continue
+		case NALUnitIDR:
+			s.Sync = true
+		}
+		n := len(u)
+		s.Data = append(s.Data, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		s.Data = append(s.Data, u...)
+	}
+	return s
+}
+
+// bitReader reads the exp-Golomb coded fields of a parameter set
+type bitReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) bit() uint {
+	if r.pos >= len(r.b)*8 {
+		r.err = ErrBadSPS
+		return 0
+	}
+	v := uint(r.b[r.pos/8]>>(7-uint(r.pos%8))) & 1
+	r.pos++
+	return v
+}
+
+func (r *bitReader) bits(n int) uint {
+	v := uint(0)
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bit() == 0 && r.err == nil {
+		zeros++
+		if zeros > 31 {
+			r.err = ErrBadSPS
+			return 0
+		}
+	}
+	return 1<<uint(zeros) - 1 + r.bits(zeros)
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+// rbsp removes the emulation prevention bytes of a NAL unit
+func rbsp(u []byte) []byte {
+	out := make([]byte, 0, len(u))
+	for i := 0; i < len(u); i++ {
+		if i >= 2 && u[i] == 3 && u[i-1] == 0 && u[i-2] == 0 {
+			continue
+		}
+		out = append(out, u[i])
+	}
+	return out
+}
+
+// SPSDimensions reads the width and height of the pictures of an H.264
+// sequence parameter set
+func SPSDimensions(sps []byte) (width, height int, err error) {
+	if len(sps) < 4 {
+		return 0, 0, ErrBadSPS
+	}
+	r := &bitReader{b: rbsp(sps[1:])}
+	profile := r.bits(8)
+	r.bits(16)
+	r.ue()
+	chroma := uint(1)
+	separatePlanes := uint(0)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chroma = r.ue()
+		if chroma == 3 {
+			separatePlanes = r.bit()
+		}
+		r.ue()
+		r.ue()
+		r.bit()
+		if r.bit() == 1 {
+			lists := 8
+			if chroma == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bit() == 1 {
+					skipScalingList(r, i)
+				}
+			}
+		}
+	}
+	r.ue()
+	switch r.ue() {
+	case 0:
+		r.ue()
+	case 1:
+		r.bit()
+		r.se()
+		r.se()
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()
+	r.bit()
+	widthMBs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMBsOnly := r.bit()
+	if frameMBsOnly == 0 {
+		r.bit()
+	}
+	r.bit()
+	var left, right, top, bottom uint
+	if r.bit() == 1 {
+		left, right, top, bottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	cropX, cropY := uint(1), 2-frameMBsOnly
+	if chroma != 0 && separatePlanes == 0 {
+		if chroma == 1 || chroma == 2 {
+			cropX = 2
+		}
+		if chroma == 1 {
+			cropY *= 2
+		}
+	}
+	width = int(widthMBs*16 - (left+right)*cropX)
+	height = int((2-frameMBsOnly)*heightMapUnits*16 - (top+bottom)*cropY)
+	if width <= 0 || height <= 0 {
+		return 0, 0, ErrBadSPS
+	}
+	return width, height, nil
+}
+
+func skipScalingList(r *bitReader, i int) {
+	size := 16
+	if i >= 6 {
+		size = 64
+	}
+	last, next := 8, 8
+	for j := 0; j < size && r.err == nil; j++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// AVCSampleEntry is the avc1 sample entry of an H.264 track with its
+// decoder configuration
+func AVCSampleEntry(sps, pps []byte, width, height int) []byte {
+	config := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1}
+	config = append(config, be16(len(sps))...)
+	config = append(config, sps...)
+	config = append(config, 1)
+	config = append(config, be16(len(pps))...)
+	config = append(config, pps...)
+	entry := make([]byte, 78)
+	entry[7] = 1 // data reference index
+	copy(entry[24:], be16(width))
+	copy(entry[26:], be16(height))
+	copy(entry[28:], []byte{0, 0x48, 0, 0, 0, 0x48, 0, 0})
+	entry[41] = 1 // frame count
+	copy(entry[74:], []byte{0, 0x18, 0xff, 0xff})
+	return box("avc1", entry, box("avcC", config))
+}
@@ -0,0 +1,326 @@
+// Package mp4 writes progressive ISO base media files (ISO/IEC 14496-12) from
+// H.264 and AAC elementary streams.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrNoSampleEntry is returned when a track with samples has no sample entry
+var ErrNoSampleEntry = errors.New("mp4: track without sample entry")
+
+// Track handlers
+const (
+	HandlerVideo = "vide"
+	HandlerAudio = "soun"
+)
+
+// movieTimescale is the timescale of the movie header and edit lists
+const movieTimescale = 1000
+
+// Track is a track of a Writer
+type Track struct {
+	ID        uint32
+	Handler   string
+	Timescale uint32
+	// Width and Height are the dimensions of video tracks
+	Width, Height int
+	// SampleEntry is the sample description of the track, see
+	// AVCSampleEntry and AACSampleEntry
+	SampleEntry []byte
+	// DefaultDuration is the duration of the last sample, the duration of the
+	// one before it is used when it is 0
+	DefaultDuration uint32
+	samples         []sample
+}
+
+type sample struct {
+	offset uint64
+	size   uint32
+	dts    int64
+	cto    int32
+	sync   bool
+}
+
+// Writer writes a progressive MP4 file, samples are written as they come and
+// the movie box describing them follows when the Writer is closed
+type Writer struct {
+	w          io.WriteSeeker
+	mdatOffset int64
+	offset     uint64
+	tracks     []*Track
+}
+
+// NewWriter starts an MP4 file of brand on w
+func NewWriter(w io.WriteSeeker, brand string, compatible ...string) (*Writer, error) {
+	ftyp := []byte(brand)
+	ftyp = append(ftyp, be32(512)...)
+	for _, c := range compatible {
+		ftyp = append(ftyp, c...)
+	}
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	header := box("ftyp", ftyp)
+	// mdat uses a 64 bit size which is filled in on Close
+	header = append(header, 0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 0)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	mdatOffset := start + int64(len(header)) - 16
+	return &Writer{w: w, mdatOffset: mdatOffset, offset: uint64(start) + uint64(len(header))}, nil
+}
+
+// AddTrack adds a track with handler and timescale
+func (w *Writer) AddTrack(handler string, timescale uint32) *Track {
+	t := &Track{ID: uint32(len(w.tracks) + 1), Handler: handler, Timescale: timescale}
+	w.tracks = append(w.tracks, t)
+	return t
+}
+
+// WriteSample writes a sample of t decoded at dts and presented cto later,
+// in the timescale of the track
+func (w *Writer) WriteSample(t *Track, data []byte, dts int64, cto int32, sync bool) error {
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	t.samples = append(t.samples, sample{offset: w.offset, size: uint32(len(data)), dts: dts, cto: cto, sync: sync})
+	w.offset += uint64(len(data))
+	return nil
+}
+
+// Close completes the mdat box and writes the movie box, it does not close
+// the underlying writer
+func (w *Writer) Close() error {
+	size := w.offset - uint64(w.mdatOffset)
+	if _, err := w.w.Seek(w.mdatOffset+8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(be64(size)); err != nil {
+		return err
+	}
+	if _, err := w.w.Seek(int64(w.offset), io.SeekStart); err != nil {
+		return err
+	}
+	moov, err := w.moov()
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(moov)
+	return err
+}
+
+func (w *Writer) moov() ([]byte, error) {
+	tracks := []*Track{}
+	start := 0.0
+	for _, t := range w.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+		if t.SampleEntry == nil {
+			return nil, ErrNoSampleEntry
+		}
+		if s := t.start(); len(tracks) == 0 || s < start {
+			start = s
+		}
+		tracks = append(tracks, t)
+	}
+	var duration uint64
+	traks := [][]byte{}
+	for _, t := range tracks {
+		delay := uint64((t.start() - start) * movieTimescale)
+		if d := delay + t.duration()*movieTimescale/uint64(t.Timescale); d > duration {
+			duration = d
+		}
+		traks = append(traks, t.trak(delay))
+	}
+	mvhd := make([]byte, 96)
+	copy(mvhd[8:], be32(movieTimescale))
+	copy(mvhd[12:], be32(uint32(duration)))
+	copy(mvhd[16:], be32(0x00010000))
+	copy(mvhd[20:], be16(0x0100))
+	copy(mvhd[32:], matrix())
+	copy(mvhd[92:], be32(uint32(len(w.tracks)+1)))
+	return box("moov", append([][]byte{fullBox("mvhd", 0, 0, mvhd)}, traks...)...), nil
+}
+
+// start is the presentation time of the first sample in seconds
+func (t *Track) start() float64 {
+	s := t.samples[0]
+	return float64(s.dts+int64(s.cto)) / float64(t.Timescale)
+}
+
+// durations are the durations of the samples from their decoding times
+func (t *Track) durations() []uint32 {
+	durations := make([]uint32, len(t.samples))
+	for i := range t.samples {
+		if i+1 < len(t.samples) {
+			if d := t.samples[i+1].dts - t.samples[i].dts; d > 0 {
+				durations[i] = uint32(d)
+			}
+			continue
+		}
+		durations[i] = t.DefaultDuration
+		if durations[i] == 0 && i > 0 {
+			durations[i] = durations[i-1]
+		}
+	}
+	return durations
+}
+
+func (t *Track) duration() uint64 {
+	var d uint64
+	for _, n := range t.durations() {
+		d += uint64(n)
+	}
+	return d
+}
+
+func (t *Track) trak(delay uint64) []byte {
+	duration := t.duration()
+	tkhd := make([]byte, 80)
+	copy(tkhd[8:], be32(t.ID))
+	copy(tkhd[16:], be32(uint32(duration*movieTimescale/uint64(t.Timescale))))
+	if t.Handler == HandlerAudio {
+		copy(tkhd[32:], be16(0x0100))
+	}
+	copy(tkhd[36:], matrix())
+	copy(tkhd[72:], be32(uint32(t.Width)<<16))
+	copy(tkhd[76:], be32(uint32(t.Height)<<16))
+	children := [][]byte{fullBox("tkhd", 0, 3, tkhd)}
+	if first := t.samples[0].cto; delay > 0 || first > 0 {
+		children = append(children, box("edts", t.elst(delay, duration, first)))
+	}
+	mdhd := make([]byte, 20)
+	copy(mdhd[8:], be32(t.Timescale))
+	copy(mdhd[12:], be32(uint32(duration)))
+	copy(mdhd[16:], be16(0x55c4)) // und
+	name := "SoundHandler"
+	header := fullBox("smhd", 0, 0, make([]byte, 4))
+	if t.Handler == HandlerVideo {
+		name, header = "VideoHandler", fullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+	hdlr := append(append(make([]byte, 4), t.Handler...), make([]byte, 12)...)
+	hdlr = append(append(hdlr, name...), 0)
+	dinf := box("dinf", fullBox("dref", 0, 0, be32(1), fullBox("url ", 0, 1)))
+	minf := box("minf", header, dinf, t.stbl())
+	mdia := box("mdia", fullBox("mdhd", 0, 0, mdhd), fullBox("hdlr", 0, 0, hdlr), minf)
+	return box("trak", append(children, mdia)...)
+}
+
+// elst delays a track starting after the movie with an empty edit and skips
+// the composition offset of its first sample
+func (t *Track) elst(delay, duration uint64, first int32) []byte {
+	entries := [][]byte{}
+	if delay > 0 {
+		entries = append(entries, be32(uint32(delay)), be32(0xffffffff), be32(0x00010000))
+	}
+	entries = append(entries, be32(uint32(duration*movieTimescale/uint64(t.Timescale))), be32(uint32(first)), be32(0x00010000))
+	return fullBox("elst", 0, 0, append([][]byte{be32(uint32(len(entries) / 3))}, entries...)...)
+}
+
+func (t *Track) stbl() []byte {
+	stts := [][]byte{}
+	var runs uint32
+	durations := t.durations()
+	for i := 0; i < len(durations); {
+		j := i
+		for j < len(durations) && durations[j] == durations[i] {
+			j++
+		}
+		stts = append(stts, be32(uint32(j-i)), be32(durations[i]))
+		runs++
+		i = j
+	}
+	sizes := [][]byte{be32(0), be32(uint32(len(t.samples)))}
+	offsets := [][]byte{be32(uint32(len(t.samples)))}
+	ctts := [][]byte{}
+	var cttsRuns uint32
+	syncs := [][]byte{}
+	allSync := true
+	large := t.samples[len(t.samples)-1].offset > 0xffffffff
+	for i, s := range t.samples {
+		sizes = append(sizes, be32(s.size))
+		if large {
+			offsets = append(offsets, be64(s.offset))
+		} else {
+			offsets = append(offsets, be32(uint32(s.offset)))
+		}
+		if s.sync {
+			syncs = append(syncs, be32(uint32(i+1)))
+		} else {
+			allSync = false
+		}
+		if n := len(ctts); n > 0 && int32(binary.BigEndian.Uint32(ctts[n-1])) == s.cto {
+			ctts[n-2] = be32(binary.BigEndian.Uint32(ctts[n-2]) + 1)
+			continue
+		}
+		ctts = append(ctts, be32(1), be32(uint32(s.cto)))
+		cttsRuns++
+	}
+	children := [][]byte{
+		fullBox("stsd", 0, 0, be32(1), t.SampleEntry),
+		fullBox("stts", 0, 0, append([][]byte{be32(runs)}, stts...)...),
+	}
+	if cttsRuns > 1 || t.samples[0].cto != 0 {
+		children = append(children, fullBox("ctts", 0, 0, append([][]byte{be32(cttsRuns)}, ctts...)...))
+	}
+	if !allSync {
+		children = append(children, fullBox("stss", 0, 0, append([][]byte{be32(uint32(len(syncs)))}, syncs...)...))
+	}
+	// every sample is a chunk of its own
+	children = append(children, fullBox("stsc", 0, 0, be32(1), be32(1), be32(1), be32(1)), fullBox("stsz", 0, 0, sizes...))
+	if large {
+		children = append(children, fullBox("co64", 0, 0, offsets...))
+	} else {
+		children = append(children, fullBox("stco", 0, 0, offsets...))
+	}
+	return box("stbl", children...)
+}
+
+func box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = append(b, be32(uint32(size))...)
+	b = append(b, typ...)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func fullBox(typ string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return box(typ, append([][]byte{header}, payloads...)...)
+}
+
+// matrix is the identity transformation matrix of movie and track headers
+func matrix() []byte {
+	m := make([]byte, 36)
+	copy(m[0:], be32(0x00010000))
+	copy(m[16:], be32(0x00010000))
+	copy(m[32:], be32(0x40000000))
+	return m
+}
+
+func be16(v int) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,122 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testSPS is a baseline profile sequence parameter set of 320x240 pictures
+var testSPS = []byte{0x67, 0x42, 0xc0, 0x1e, 0xda, 0x05, 0x07, 0xe4}
+
+// boxes lists the types of the boxes in b with their payloads
+func boxes(b []byte) map[string][]byte {
+	found := map[string][]byte{}
+	for len(b) >= 8 {
+		size, header := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		if size == 1 {
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			break
+		}
+		found[string(b[4:8])] = b[header:size]
+		b = b[size:]
+	}
+	return found
+}
+
+func TestSPSDimensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		sps        []byte
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{"baseline", testSPS, 320, 240, false},
+		{"truncated", testSPS[:5], 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, err := SPSDimensions(tt.sps)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SPSDimensions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("SPSDimensions() = %vx%v, want %vx%v", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestParseAVCAccessUnit(t *testing.T) {
+	au := []byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 0, 1}
+	au = append(au, testSPS...)
+	au = append(au, 0, 0, 1, 0x68, 0xce, 0x38, 0x80, 0, 0, 1, 0x65, 0x88, 0x84)
+	got := ParseAVCAccessUnit(au)
+	want := &AVCSample{Data: []byte{0, 0, 0, 3, 0x65, 0x88, 0x84}, SPS: testSPS, PPS: []byte{0x68, 0xce, 0x38, 0x80}, Sync: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAVCAccessUnit() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSplitADTS(t *testing.T) {
+	frame := []byte{0xff, 0xf1, 0x4c, 0x80, 0x01, 0x3f, 0xfc, 0xaa, 0xbb}
+	frames, err := SplitADTS(append(append([]byte{}, frame...), frame...))
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("SplitADTS() = %v frames, %v, want 2", len(frames), err)
+	}
+	want := ADTSFrame{Config: []byte{0x11, 0x90}, SampleRate: 48000, Channels: 2, Data: []byte{0xaa, 0xbb}}
+	if !reflect.DeepEqual(frames[1], want) {
+		t.Errorf("SplitADTS() = %+v, want %+v", frames[1], want)
+	}
+	if _, err := SplitADTS([]byte("<html>")); err != ErrBadADTS {
+		t.Errorf("SplitADTS() of html error = %v, want ErrBadADTS", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	f, err := ioutil.TempFile("", "streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w, err := NewWriter(f, "isom", "isom", "avc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := w.AddTrack(HandlerVideo, 90000)
+	video.Width, video.Height = 320, 240
+	video.SampleEntry = AVCSampleEntry(testSPS, []byte{0x68, 0xce, 0x38, 0x80}, 320, 240)
+	audio := w.AddTrack(HandlerAudio, 48000)
+	audio.SampleEntry = AACSampleEntry([]byte{0x11, 0x90}, 2, 48000)
+	audio.DefaultDuration = AACFrameSamples
+	for i := int64(0); i < 3; i++ {
+		w.WriteSample(video, []byte{0, 0, 0, 1, 0x65}, 90000+i*3000, 0, i == 0)
+		w.WriteSample(audio, []byte{0xaa}, 48000+i*AACFrameSamples, 0, true)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := boxes(data)
+	if !bytes.HasPrefix(top["ftyp"], []byte("isom")) || len(top["mdat"]) != 18 || top["moov"] == nil {
+		t.Fatalf("boxes = %v, want ftyp, mdat and moov", top)
+	}
+	if n := bytes.Count(top["moov"], []byte("trak")); n != 2 {
+		t.Errorf("moov has %v tracks, want 2", n)
+	}
+	for _, typ := range []string{"avcC", "esds", "stss", "stco"} {
+		if !bytes.Contains(top["moov"], []byte(typ)) {
+			t.Errorf("moov has no %s box", typ)
+		}
+	}
+}
@@ -8,6 +8,13 @@ import (
 	"github.com/osiloke/streaming/log"
 )
 
+// tracker coordinates the downloads and removals of the tools so removing a
+// playlist being downloaded cancels the download
+var tracker = downloader.NewTracker()
+
+// diskReserve is the free space downloads leave on the device
+const diskReserve = 64 << 20
+
 // EventBus event sender
 type EventBus interface {
 	SendMessageEvent(channel, message string)
@@ -17,7 +24,7 @@ type EventBus interface {
 func GetHLS(url, storage, segmentURLPrefix string, dispatcher EventBus) string {
 	ps := pubsub.New(1)
 	ch := ps.Sub(downloader.DownloadStatusChannel)
-	go downloader.DownloadHLSPlaylist(url, storage, segmentURLPrefix, ps)
+	go downloader.DownloadHLSPlaylist(url, downloader.NewFileStorage(storage), segmentURLPrefix, ps, downloader.WithTracker(tracker), downloader.WithDiskPreflight(diskReserve))
 	// go func() {
 	for c := range ch {
 		status := c.(downloader.DownloadStatus)
@@ -42,7 +49,7 @@ func GetMultipleHLS(urls []string, storage, segmentURLPrefix string, dispatcher
 		}
 	}()
 	for _, url := range urls {
-		downloader.DownloadHLSPlaylist(url, storage, segmentURLPrefix, ps)
+		downloader.DownloadHLSPlaylist(url, downloader.NewFileStorage(storage), segmentURLPrefix, ps, downloader.WithTracker(tracker), downloader.WithDiskPreflight(diskReserve))
 		log.Debug.Printf("Finished storing - %s", url)
 	}
 	ps.Unsub(ch, downloader.DownloadStatusChannel)
@@ -53,7 +60,7 @@ func GetMultipleHLS(urls []string, storage, segmentURLPrefix string, dispatcher
 func RemoveHLS(url, storage, segmentURLPrefix string, dispatcher EventBus) string {
 	ps := pubsub.New(1)
 	ch := ps.Sub(downloader.RemoveStatusChannel)
-	go downloader.RemoveHLSPlaylist(url, storage, segmentURLPrefix, ps)
+	go downloader.RemoveHLSPlaylist(url, downloader.NewFileStorage(storage), segmentURLPrefix, ps, downloader.WithTracker(tracker), downloader.WithCancelDownloads())
 	// go func() {
 	for c := range ch {
 		status := c.(downloader.RemoveStatus)
@@ -79,7 +86,7 @@ func RemoveMultipleHLS(key string, urls []string, storage, segmentURLPrefix stri
 		}
 	}()
 	for _, url := range urls {
-		err := downloader.RemoveHLSPlaylist(url, storage, segmentURLPrefix, ps)
+		err := downloader.RemoveHLSPlaylist(url, downloader.NewFileStorage(storage), segmentURLPrefix, ps, downloader.WithTracker(tracker), downloader.WithCancelDownloads())
 		if err != nil {
 			log.Debug.Printf("Failed removing - %s - %s", url, err.Error())
 			// dispatcher.SendMessageEvent("DOWNLOADER_REMOVE_HLS_FAILED", url)
@@ -92,3 +99,23 @@ func RemoveMultipleHLS(key string, urls []string, storage, segmentURLPrefix stri
 	ps.Unsub(ch, downloader.RemoveStatusChannel)
 	// close(ch)
 }
+
+// SaveHLS remux a cached hls url into a single ts, mp4 or m4a file at dst
+func SaveHLS(url, storage, segmentURLPrefix, dst, format string, dispatcher EventBus) string {
+	ps := pubsub.New(1)
+	ch := ps.Sub(downloader.DownloadStatusChannel)
+	go func() {
+		for c := range ch {
+			status := c.(downloader.DownloadStatus)
+			v, _ := json.Marshal(status)
+			dispatcher.SendMessageEvent("REMUX_STATUS", string(v))
+		}
+	}()
+	defer ps.Unsub(ch, downloader.DownloadStatusChannel)
+	if err := downloader.RemuxHLSPlaylist(url, downloader.NewFileStorage(storage), segmentURLPrefix, dst, format, ps); err != nil {
+		log.Debug.Printf("Failed saving - %s - %s", url, err.Error())
+		return err.Error()
+	}
+	log.Debug.Printf("Finished saving - %s", url)
+	return "done"
+}
@@ -0,0 +1,176 @@
+package ts
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNoProgram is returned when a stream ends before the PMT of its first
+// program
+var ErrNoProgram = errors.New("ts: no program map table")
+
+// PES is a PES packet of an elementary stream
+type PES struct {
+	PID        uint16
+	StreamType uint8
+	Header     *PESHeader
+	// Data is the elementary stream data the packet carries
+	Data []byte
+}
+
+// Demuxer reads the PES packets of the elementary streams of the first
+// program of a transport stream
+type Demuxer struct {
+	r   *Reader
+	pmt *PMT
+	// pmtPID is the PID of the PMT of the first program, -1 until the PAT
+	// was read
+	pmtPID  int
+	psi     map[uint16][]byte
+	pending map[uint16][]byte
+	// order lists the PIDs of pending PES packets by when they started
+	order []uint16
+	ready []*PES
+	err   error
+	// onPacket sees every packet read, an error it returns stops the
+	// demuxer
+	onPacket func(p Packet) error
+}
+
+// NewDemuxer reads the packets of a transport stream from r
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{r: NewReader(r), pmtPID: -1, psi: map[uint16][]byte{}, pending: map[uint16][]byte{}}
+}
+
+// Program reads the stream until the PMT of its first program is known
+func (d *Demuxer) Program() (*PMT, error) {
+	for d.pmt == nil {
+		if err := d.readPacket(); err == io.EOF {
+			return nil, ErrNoProgram
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return d.pmt, nil
+}
+
+// Next returns the next complete PES packet, io.EOF is returned after the
+// last one
+func (d *Demuxer) Next() (*PES, error) {
+	for len(d.ready) == 0 {
+		if d.err != nil {
+			return nil, d.err
+		}
+		if err := d.readPacket(); err == io.EOF {
+			for _, pid := range d.order {
+				d.flush(pid)
+			}
+			d.order, d.err = nil, io.EOF
+		} else if err != nil {
+			d.err = err
+		}
+	}
+	pes := d.ready[0]
+	d.ready = d.ready[1:]
+	return pes, nil
+}
+
+func (d *Demuxer) readPacket() error {
+	p, err := d.r.ReadPacket()
+	if err != nil {
+		return err
+	}
+	if d.onPacket != nil {
+		if err := d.onPacket(p); err != nil {
+			return err
+		}
+	}
+	pid := p.PID()
+	payload := p.Payload()
+	if payload == nil {
+		return nil
+	}
+	switch {
+	case pid == PIDPAT || int(pid) == d.pmtPID:
+		return d.readSection(pid, p.PayloadUnitStart(), payload)
+	case d.pmt == nil:
+		return nil
+	}
+	if _, ok := d.pmt.Stream(pid); !ok {
+		return nil
+	}
+	if p.PayloadUnitStart() {
+		d.flush(pid)
+		d.pending[pid] = append([]byte{}, payload...)
+		d.order = append(d.order, pid)
+	} else if data, ok := d.pending[pid]; ok {
+		d.pending[pid] = append(data, payload...)
+	}
+	if h, err := ParsePESHeader(d.pending[pid]); err == nil && h.PacketLength > 0 && len(d.pending[pid]) >= 6+h.PacketLength {
+		d.flush(pid)
+	}
+	return nil
+}
+
+// readSection assembles the PAT and PMT sections
+func (d *Demuxer) readSection(pid uint16, start bool, payload []byte) error {
+	if start {
+		pointer := int(payload[0])
+		if 1+pointer > len(payload) {
+			return ErrBadSection
+		}
+		d.psi[pid] = append([]byte{}, payload[1+pointer:]...)
+	} else if b, ok := d.psi[pid]; ok {
+		d.psi[pid] = append(b, payload...)
+	}
+	b := d.psi[pid]
+	n := sectionLength(b)
+	if n == 0 || len(b) < n {
+		return nil
+	}
+	delete(d.psi, pid)
+	if pid == PIDPAT {
+		programs, err := ParsePAT(b[:n])
+		if err != nil {
+			return err
+		}
+		for _, program := range programs {
+			if program.Number != 0 {
+				d.pmtPID = int(program.PMTPID)
+				break
+			}
+		}
+		return nil
+	}
+	pmt, err := ParsePMT(b[:n])
+	if err != nil {
+		return err
+	}
+	pmt.PID = pid
+	d.pmt = pmt
+	return nil
+}
+
+// flush queues the pending PES packet of pid
+func (d *Demuxer) flush(pid uint16) {
+	data, ok := d.pending[pid]
+	if !ok {
+		return
+	}
+	delete(d.pending, pid)
+	for i, p := range d.order {
+		if p == pid {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+	h, err := ParsePESHeader(data)
+	if err != nil {
+		return
+	}
+	if h.PacketLength > 0 && 6+h.PacketLength < len(data) {
+		data = data[:6+h.PacketLength]
+	}
+	s, _ := d.pmt.Stream(pid)
+	d.ready = append(d.ready, &PES{PID: pid, StreamType: s.Type, Header: h, Data: data[h.Length:]})
+}
@@ -0,0 +1,117 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// testPackets splits payload into packets of pid, the first one starting the
+// payload unit and carrying pcr when it is not negative
+func testPackets(pid uint16, cc *uint8, payload []byte, pcr int64) []byte {
+	out := []byte{}
+	for first := true; first || len(payload) > 0; first = false {
+		p := make([]byte, PacketSize)
+		p[0], p[1], p[2], p[3] = SyncByte, byte(pid>>8), byte(pid), 0x10|*cc&0x0f
+		*cc++
+		if first {
+			p[1] |= 0x40
+		}
+		var adaptation []byte
+		if first && pcr >= 0 {
+			adaptation = []byte{0x10, 0, 0, 0, 0, 0, 0}
+		}
+		room := PacketSize - 4
+		if adaptation != nil {
+			room -= 1 + len(adaptation)
+		}
+		if stuffing := room - len(payload); stuffing > 0 {
+			// stuff the adaptation field so the payload ends the packet
+			switch {
+			case adaptation != nil:
+			case stuffing == 1:
+				adaptation = []byte{}
+				stuffing = 0
+			default:
+				adaptation = []byte{0}
+				stuffing -= 2
+			}
+			adaptation = append(adaptation, bytes.Repeat([]byte{0xff}, stuffing)...)
+		}
+		offset := 4
+		if adaptation != nil {
+			p[3] |= 0x20
+			p[4] = byte(len(adaptation))
+			copy(p[5:], adaptation)
+			offset = 5 + len(adaptation)
+		}
+		payload = payload[copy(p[offset:], payload):]
+		if first && pcr >= 0 {
+			Packet(p).SetPCR(uint64(pcr) * 300)
+		}
+		out = append(out, p...)
+	}
+	return out
+}
+
+func testSection(table byte, id uint16, data []byte) []byte {
+	length := 5 + len(data) + 4
+	s := []byte{0, table, 0xb0 | byte(length>>8), byte(length), byte(id >> 8), byte(id), 0xc1, 0, 0}
+	return append(append(s, data...), 0, 0, 0, 0)
+}
+
+func testPES(streamID byte, pts int64, data []byte) []byte {
+	h := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5, 0x21, 0, 0, 0, 0}
+	writeTimestamp(h[9:], pts)
+	if streamID != 0xe0 {
+		n := len(h) - 6 + len(data)
+		h[4], h[5] = byte(n>>8), byte(n)
+	}
+	return append(h, data...)
+}
+
+func TestDemuxer(t *testing.T) {
+	var patCC, pmtCC, videoCC, audioCC uint8
+	stream := testPackets(PIDPAT, &patCC, testSection(0x00, 1, []byte{0, 1, 0xf0, 0x00}), -1)
+	stream = append(stream, testPackets(0x1000, &pmtCC, testSection(0x02, 1, []byte{0xe1, 0x00, 0xf0, 0x00, StreamTypeH264, 0xe1, 0x00, 0xf0, 0x00, StreamTypeAAC, 0xe1, 0x01, 0xf0, 0x00}), -1)...)
+	video := bytes.Repeat([]byte{0xaa}, 300)
+	audio := bytes.Repeat([]byte{0xbb}, 20)
+	stream = append(stream, testPackets(0x100, &videoCC, testPES(0xe0, 90000, video), 90000)...)
+	stream = append(stream, testPackets(0x101, &audioCC, testPES(0xc0, 90100, audio), -1)...)
+	stream = append(stream, testPackets(0x100, &videoCC, testPES(0xe0, TimestampWrap-1, video[:10]), -1)...)
+
+	d := NewDemuxer(bytes.NewReader(stream))
+	pmt, err := d.Program()
+	if err != nil {
+		t.Fatalf("Program() error = %v", err)
+	}
+	wantStreams := []ElementaryStream{{PID: 0x100, Type: StreamTypeH264, Descriptors: []byte{}}, {PID: 0x101, Type: StreamTypeAAC, Descriptors: []byte{}}}
+	if pmt.PID != 0x1000 || pmt.PCRPID != 0x100 || !reflect.DeepEqual(pmt.Streams, wantStreams) {
+		t.Errorf("Program() = %+v, want the video and audio streams", pmt)
+	}
+	tests := []struct {
+		pid  uint16
+		pts  int64
+		data []byte
+	}{
+		{0x101, 90100, audio},
+		{0x100, 90000, video},
+		{0x100, TimestampWrap - 1, video[:10]},
+	}
+	for _, tt := range tests {
+		pes, err := d.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if pes.PID != tt.pid || pes.Header.PTS != tt.pts || !bytes.Equal(pes.Data, tt.data) {
+			t.Errorf("Next() = PID %v PTS %v %v bytes, want PID %v PTS %v %v bytes", pes.PID, pes.Header.PTS, len(pes.Data), tt.pid, tt.pts, len(tt.data))
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Next() at the end error = %v, want io.EOF", err)
+	}
+	if _, err := NewDemuxer(bytes.NewReader([]byte("<html>"))).Program(); err != ErrNoProgram && err != io.ErrUnexpectedEOF {
+		t.Errorf("Program() of html error = %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package ts
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf16"
+)
+
+// ErrBadID3 is returned for malformed ID3v2 tags
+var ErrBadID3 = errors.New("ts: malformed ID3 tag")
+
+// ID3Frame is a frame of an ID3v2 tag, such as the TXXX and PRIV frames of
+// HLS timed metadata
+type ID3Frame struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// Text is the text of a text information frame, for TXXX frames the value
+// after the description
+func (f ID3Frame) Text() string {
+	if len(f.ID) == 0 || f.ID[0] != 'T' || len(f.Data) == 0 {
+		return ""
+	}
+	encoding, text := f.Data[0], f.Data[1:]
+	if f.ID == "TXXX" {
+		_, text = splitID3Text(encoding, text)
+	}
+	return decodeID3Text(encoding, text)
+}
+
+// Private splits a PRIV frame into its owner identifier and data
+func (f ID3Frame) Private() (owner string, data []byte, ok bool) {
+	if f.ID != "PRIV" {
+		return "", nil, false
+	}
+	i := bytes.IndexByte(f.Data, 0)
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(f.Data[:i]), f.Data[i+1:], true
+}
+
+// splitID3Text splits the terminated string at the start of text from what
+// follows it
+func splitID3Text(encoding byte, text []byte) ([]byte, []byte) {
+	if encoding == 1 || encoding == 2 {
+		// UTF-16 strings end with two zero bytes
+		for i := 0; i+1 < len(text); i += 2 {
+			if text[i] == 0 && text[i+1] == 0 {
+				return text[:i], text[i+2:]
+			}
+		}
+		return text, nil
+	}
+	if i := bytes.IndexByte(text, 0); i >= 0 {
+		return text[:i], text[i+1:]
+	}
+	return text, nil
+}
+
+func decodeID3Text(encoding byte, text []byte) string {
+	text, _ = splitID3Text(encoding, text)
+	switch encoding {
+	case 0:
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(text) >= 2 && (text[0] == 0xfe && text[1] == 0xff || text[0] == 0xff && text[1] == 0xfe) {
+			bigEndian, text = text[0] == 0xfe, text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = uint16(text[2*i])<<8 | uint16(text[2*i+1])
+			} else {
+				units[i] = uint16(text[2*i+1])<<8 | uint16(text[2*i])
+			}
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(text)
+}
+
+// syncsafe decodes an integer stored in 7 bits per byte
+func syncsafe(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<7 | int(c&0x7f)
+	}
+	return n
+}
+
+// ParseID3 parses the frames of the ID3v2.3 and ID3v2.4 tags which make up
+// the PES data of a timed metadata stream
+func ParseID3(b []byte) ([]ID3Frame, error) {
+	frames := []ID3Frame{}
+	for len(b) > 0 {
+		if len(b) < 10 || string(b[:3]) != "ID3" || b[3] < 3 || b[3] > 4 {
+			return frames, ErrBadID3
+		}
+		version, flags := b[3], b[5]
+		size := syncsafe(b[6:10])
+		if 10+size > len(b) {
+			return frames, ErrBadID3
+		}
+		tag := b[10 : 10+size]
+		b = b[10+size:]
+		if flags&0x10 != 0 && len(b) >= 10 && string(b[:3]) == "3DI" {
+			// skip the footer
+			b = b[10:]
+		}
+		if flags&0x40 != 0 {
+			// skip the extended header
+			if len(tag) < 4 {
+				return frames, ErrBadID3
+			}
+			n := syncsafe(tag[:4])
+			if version == 3 {
+				n = int(tag[0])<<24 | int(tag[1])<<16 | int(tag[2])<<8 | int(tag[3]) + 4
+			}
+			if n > len(tag) {
+				return frames, ErrBadID3
+			}
+			tag = tag[n:]
+		}
+		for len(tag) >= 10 && tag[0] != 0 {
+			n := syncsafe(tag[4:8])
+			if version == 3 {
+				n = int(tag[4])<<24 | int(tag[5])<<16 | int(tag[6])<<8 | int(tag[7])
+			}
+			if 10+n > len(tag) {
+				return frames, ErrBadID3
+			}
+			frames = append(frames, ID3Frame{ID: string(tag[:4]), Data: tag[10 : 10+n]})
+			tag = tag[10+n:]
+		}
+	}
+	return frames, nil
+}
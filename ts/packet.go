@@ -0,0 +1,147 @@
+// Package ts reads and rewrites MPEG transport streams (ISO/IEC 13818-1) as
+// used by HLS media segments.
+package ts
+
+import (
+	"errors"
+	"io"
+)
+
+// PacketSize is the size of a transport stream packet
+const PacketSize = 188
+
+// SyncByte starts every packet
+const SyncByte = 0x47
+
+// PIDs with a fixed meaning
+const (
+	PIDPAT  = 0x0000
+	PIDNull = 0x1fff
+)
+
+// ErrSync is returned when a packet does not start with SyncByte
+var ErrSync = errors.New("ts: lost sync")
+
+// Packet is a transport stream packet of PacketSize bytes
+type Packet []byte
+
+// PID is the packet identifier
+func (p Packet) PID() uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+// SetPID changes the packet identifier
+func (p Packet) SetPID(pid uint16) {
+	p[1] = p[1]&0xe0 | byte(pid>>8)&0x1f
+	p[2] = byte(pid)
+}
+
+// PayloadUnitStart reports whether a PES packet or PSI section starts in the
+// payload
+func (p Packet) PayloadUnitStart() bool {
+	return p[1]&0x40 != 0
+}
+
+// HasAdaptationField reports whether the packet has an adaptation field
+func (p Packet) HasAdaptationField() bool {
+	return p[3]&0x20 != 0
+}
+
+// HasPayload reports whether the packet carries a payload
+func (p Packet) HasPayload() bool {
+	return p[3]&0x10 != 0
+}
+
+// ContinuityCounter is the counter incremented by every packet of a PID
+// which carries a payload
+func (p Packet) ContinuityCounter() uint8 {
+	return p[3] & 0x0f
+}
+
+// SetContinuityCounter changes the continuity counter
+func (p Packet) SetContinuityCounter(cc uint8) {
+	p[3] = p[3]&0xf0 | cc&0x0f
+}
+
+// adaptationField is the adaptation field without its length
+func (p Packet) adaptationField() []byte {
+	if !p.HasAdaptationField() {
+		return nil
+	}
+	n := int(p[4])
+	if 5+n > len(p) {
+		return nil
+	}
+	return p[5 : 5+n]
+}
+
+// Discontinuity reports whether the discontinuity indicator is set
+func (p Packet) Discontinuity() bool {
+	af := p.adaptationField()
+	return len(af) > 0 && af[0]&0x80 != 0
+}
+
+// Payload is the payload of the packet, nil when it has none
+func (p Packet) Payload() []byte {
+	if !p.HasPayload() {
+		return nil
+	}
+	start := 4
+	if p.HasAdaptationField() {
+		start += 1 + int(p[4])
+	}
+	if start >= len(p) {
+		return nil
+	}
+	return p[start:]
+}
+
+// PCR is the program clock reference in 27MHz ticks
+func (p Packet) PCR() (uint64, bool) {
+	af := p.adaptationField()
+	if len(af) < 7 || af[0]&0x10 == 0 {
+		return 0, false
+	}
+	base := uint64(af[1])<<25 | uint64(af[2])<<17 | uint64(af[3])<<9 | uint64(af[4])<<1 | uint64(af[5])>>7
+	ext := uint64(af[5]&0x01)<<8 | uint64(af[6])
+	return base*300 + ext, true
+}
+
+// SetPCR changes the program clock reference of a packet which has one
+func (p Packet) SetPCR(pcr uint64) {
+	af := p.adaptationField()
+	if len(af) < 7 || af[0]&0x10 == 0 {
+		return
+	}
+	base, ext := pcr/300%(1<<33), pcr%300
+	af[1] = byte(base >> 25)
+	af[2] = byte(base >> 17)
+	af[3] = byte(base >> 9)
+	af[4] = byte(base >> 1)
+	af[5] = byte(base<<7) | 0x7e | byte(ext>>8)&0x01
+	af[6] = byte(ext)
+}
+
+// Reader reads the packets of a transport stream
+type Reader struct {
+	r   io.Reader
+	buf [PacketSize]byte
+}
+
+// NewReader reads packets from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadPacket reads the next packet, it is only valid until the next call.
+// io.EOF is returned at the end of the stream and io.ErrUnexpectedEOF when
+// it ends within a packet
+func (r *Reader) ReadPacket() (Packet, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		return nil, err
+	}
+	if r.buf[0] != SyncByte {
+		return nil, ErrSync
+	}
+	return Packet(r.buf[:]), nil
+}
@@ -0,0 +1,56 @@
+package ts
+
+import "testing"
+
+func TestPacketPCR(t *testing.T) {
+	tests := []struct {
+		name string
+		pcr  uint64
+	}{
+		{"zero", 0},
+		{"with extension", 90000*300 + 299},
+		{"largest", (TimestampWrap-1)*300 + 299},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cc uint8
+			p := Packet(testPackets(0x100, &cc, []byte{1, 2, 3}, 0)[:PacketSize])
+			p.SetPCR(tt.pcr)
+			if got, ok := p.PCR(); !ok || got != tt.pcr {
+				t.Errorf("PCR() = %v, %v, want %v", got, ok, tt.pcr)
+			}
+			if p.PID() != 0x100 || !p.PayloadUnitStart() || string(p.Payload()) != "\x01\x02\x03" {
+				t.Errorf("SetPCR() changed the packet")
+			}
+		})
+	}
+}
+
+func TestShiftPESTimestamps(t *testing.T) {
+	tests := []struct {
+		name   string
+		pts    int64
+		offset int64
+		want   int64
+	}{
+		{"forward", 90000, 9000, 99000},
+		{"backward", 90000, -90000, 0},
+		{"wraps", TimestampWrap - 10, 20, 10},
+		{"wraps backward", 10, -20, TimestampWrap - 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pes := testPES(0xc0, tt.pts, []byte{1})
+			if err := ShiftPESTimestamps(pes, tt.offset); err != nil {
+				t.Fatalf("ShiftPESTimestamps() error = %v", err)
+			}
+			h, err := ParsePESHeader(pes)
+			if err != nil || h.PTS != tt.want {
+				t.Errorf("PTS = %v, %v, want %v", h.PTS, err, tt.want)
+			}
+			if got := TimestampDiff(h.PTS, tt.pts); got != tt.offset {
+				t.Errorf("TimestampDiff() = %v, want %v", got, tt.offset)
+			}
+		})
+	}
+}
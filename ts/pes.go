@@ -0,0 +1,107 @@
+package ts
+
+import "errors"
+
+// ClockRate is the rate of PTS and DTS timestamps
+const ClockRate = 90000
+
+// TimestampWrap is where 33 bit PTS and DTS timestamps wrap around
+const TimestampWrap = 1 << 33
+
+// ErrNotPES is returned when a payload does not start with a PES header
+var ErrNotPES = errors.New("ts: not a PES packet")
+
+// PESHeader is the header of a PES packet
+type PESHeader struct {
+	StreamID uint8
+	// PacketLength is the length of the packet after the field, 0 when it
+	// is unbounded
+	PacketLength int
+	HasPTS       bool
+	HasDTS       bool
+	PTS          int64
+	DTS          int64
+	// Length is the length of the header, the elementary stream data
+	// follows it
+	Length int
+}
+
+// hasOptionalHeader reports whether PES packets of a stream carry the
+// optional header with timestamps
+func hasOptionalHeader(streamID uint8) bool {
+	switch streamID {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		return false
+	}
+	return true
+}
+
+// ParsePESHeader parses the header at the start of a PES packet
+func ParsePESHeader(b []byte) (*PESHeader, error) {
+	if len(b) < 6 || b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return nil, ErrNotPES
+	}
+	h := &PESHeader{StreamID: b[3], PacketLength: int(b[4])<<8 | int(b[5]), Length: 6}
+	if !hasOptionalHeader(h.StreamID) {
+		return h, nil
+	}
+	if len(b) < 9 {
+		return nil, ErrNotPES
+	}
+	h.Length = 9 + int(b[8])
+	if len(b) < h.Length {
+		return nil, ErrNotPES
+	}
+	flags := b[7] >> 6
+	if flags&0x02 != 0 && h.Length >= 14 {
+		h.HasPTS, h.PTS = true, readTimestamp(b[9:])
+	}
+	if flags == 0x03 && h.Length >= 19 {
+		h.HasDTS, h.DTS = true, readTimestamp(b[14:])
+	}
+	return h, nil
+}
+
+// ShiftPESTimestamps adds offset to the PTS and DTS of the PES header at
+// the start of b, wrapping them around at TimestampWrap
+func ShiftPESTimestamps(b []byte, offset int64) error {
+	h, err := ParsePESHeader(b)
+	if err != nil {
+		return err
+	}
+	if h.HasPTS {
+		writeTimestamp(b[9:], WrapTimestamp(h.PTS+offset))
+	}
+	if h.HasDTS {
+		writeTimestamp(b[14:], WrapTimestamp(h.DTS+offset))
+	}
+	return nil
+}
+
+// WrapTimestamp wraps t into the 33 bits of a timestamp
+func WrapTimestamp(t int64) int64 {
+	t %= TimestampWrap
+	if t < 0 {
+		t += TimestampWrap
+	}
+	return t
+}
+
+// TimestampDiff is a - b for timestamps which may have wrapped around
+// between them
+func TimestampDiff(a, b int64) int64 {
+	return WrapTimestamp(a-b+TimestampWrap/2) - TimestampWrap/2
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// writeTimestamp writes t keeping the prefix of the first byte
+func writeTimestamp(b []byte, t int64) {
+	b[0] = b[0]&0xf0 | byte(t>>29)&0x0e | 0x01
+	b[1] = byte(t >> 22)
+	b[2] = byte(t>>14) | 0x01
+	b[3] = byte(t >> 7)
+	b[4] = byte(t<<1) | 0x01
+}
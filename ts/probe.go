@@ -0,0 +1,129 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// StreamInfo describes an elementary stream found by Probe
+type StreamInfo struct {
+	PID   uint16 `json:"pid"`
+	Type  uint8  `json:"type"`
+	Codec string `json:"codec"`
+	// HasPTS is false for streams without timestamps, FirstPTS and LastPTS
+	// are the earliest and latest presentation timestamps of the others
+	HasPTS   bool  `json:"hasPTS"`
+	FirstPTS int64 `json:"firstPTS"`
+	LastPTS  int64 `json:"lastPTS"`
+	// Duration spans the timestamps of the stream and the last frame, whose
+	// duration is taken to be the one of the frame before it
+	Duration time.Duration `json:"duration"`
+	// lastStep is the gap between the last two timestamps
+	lastStep int64
+}
+
+// Metadata is the ID3 timed metadata of a PES packet
+type Metadata struct {
+	PID    uint16     `json:"pid"`
+	PTS    int64      `json:"pts"`
+	Frames []ID3Frame `json:"frames"`
+}
+
+// Info describes a transport stream
+type Info struct {
+	PMTPID  uint16       `json:"pmtPID"`
+	PCRPID  uint16       `json:"pcrPID"`
+	Streams []StreamInfo `json:"streams"`
+	// Duration is the longest duration of the audio and video streams
+	Duration time.Duration `json:"duration"`
+	Metadata []Metadata    `json:"metadata"`
+}
+
+// Codec names the codec of a PMT stream type
+func Codec(streamType uint8) string {
+	switch streamType {
+	case StreamTypeH264:
+		return "h264"
+	case StreamTypeH265:
+		return "hevc"
+	case StreamTypeAAC:
+		return "aac"
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio:
+		return "mp3"
+	case StreamTypeAC3:
+		return "ac3"
+	case StreamTypeEAC3:
+		return "eac3"
+	case StreamTypeMetadata:
+		return "id3"
+	}
+	return fmt.Sprintf("0x%02x", streamType)
+}
+
+// Probe reads a transport stream and describes the elementary streams of its
+// first program with their timestamps and the ID3 timed metadata they carry
+func Probe(r io.Reader) (*Info, error) {
+	d := NewDemuxer(r)
+	pmt, err := d.Program()
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{PMTPID: pmt.PID, PCRPID: pmt.PCRPID, Metadata: []Metadata{}}
+	index := map[uint16]int{}
+	for _, s := range pmt.Streams {
+		index[s.PID] = len(info.Streams)
+		info.Streams = append(info.Streams, StreamInfo{PID: s.PID, Type: s.Type, Codec: Codec(s.Type)})
+	}
+	for {
+		pes, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		i, ok := index[pes.PID]
+		if !ok {
+			index[pes.PID] = len(info.Streams)
+			i = len(info.Streams)
+			info.Streams = append(info.Streams, StreamInfo{PID: pes.PID, Type: pes.StreamType, Codec: Codec(pes.StreamType)})
+		}
+		if !pes.Header.HasPTS {
+			continue
+		}
+		info.Streams[i].add(pes.Header.PTS)
+		if pes.StreamType == StreamTypeMetadata {
+			if frames, err := ParseID3(pes.Data); err == nil {
+				info.Metadata = append(info.Metadata, Metadata{PID: pes.PID, PTS: pes.Header.PTS, Frames: frames})
+			}
+		}
+	}
+	for i := range info.Streams {
+		s := &info.Streams[i]
+		if !s.HasPTS {
+			continue
+		}
+		ticks := TimestampDiff(s.LastPTS, s.FirstPTS) + s.lastStep
+		s.Duration = time.Duration(ticks) * time.Second / ClockRate
+		if s.Type != StreamTypeMetadata && s.Duration > info.Duration {
+			info.Duration = s.Duration
+		}
+	}
+	return info, nil
+}
+
+// add notes a presentation timestamp of the stream, timestamps may come out
+// of order when frames are reordered
+func (s *StreamInfo) add(pts int64) {
+	if !s.HasPTS {
+		s.HasPTS, s.FirstPTS, s.LastPTS = true, pts, pts
+		return
+	}
+	if TimestampDiff(pts, s.FirstPTS) < 0 {
+		s.FirstPTS = pts
+	}
+	if step := TimestampDiff(pts, s.LastPTS); step > 0 {
+		s.LastPTS, s.lastStep = pts, step
+	}
+}
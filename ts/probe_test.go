@@ -0,0 +1,104 @@
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testID3 is an ID3v2.4 tag of frames
+func testID3(frames ...ID3Frame) []byte {
+	body := []byte{}
+	for _, f := range frames {
+		n := len(f.Data)
+		body = append(body, f.ID...)
+		body = append(body, byte(n>>21)&0x7f, byte(n>>14)&0x7f, byte(n>>7)&0x7f, byte(n)&0x7f, 0, 0)
+		body = append(body, f.Data...)
+	}
+	n := len(body)
+	tag := []byte{'I', 'D', '3', 4, 0, 0, byte(n>>21) & 0x7f, byte(n>>14) & 0x7f, byte(n>>7) & 0x7f, byte(n) & 0x7f}
+	return append(tag, body...)
+}
+
+func TestParseID3(t *testing.T) {
+	txxx := ID3Frame{ID: "TXXX", Data: []byte("\x03title\x00Live")}
+	priv := ID3Frame{ID: "PRIV", Data: []byte("com.apple.streaming.transportStreamTimestamp\x00\x00\x00\x00\x00\x00\x01\x5f\x90")}
+	tit2 := ID3Frame{ID: "TIT2", Data: []byte("\x01\xff\xfeH\x00i\x00\x00\x00")}
+	padded := append(testID3(tit2), make([]byte, 20)...)
+	padded[9] += 20
+	tests := []struct {
+		name    string
+		b       []byte
+		want    []ID3Frame
+		wantErr bool
+	}{
+		{"frames", testID3(txxx, priv), []ID3Frame{txxx, priv}, false},
+		{"padding", padded, []ID3Frame{tit2}, false},
+		{"tags", append(testID3(txxx), testID3(tit2)...), []ID3Frame{txxx, tit2}, false},
+		{"truncated", testID3(txxx)[:15], []ID3Frame{}, true},
+		{"not id3", []byte("not an id3 tag"), []ID3Frame{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseID3(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseID3() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseID3() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := txxx.Text(); got != "Live" {
+		t.Errorf("Text() of TXXX = %q, want Live", got)
+	}
+	if got := tit2.Text(); got != "Hi" {
+		t.Errorf("Text() of UTF-16 TIT2 = %q, want Hi", got)
+	}
+	if owner, data, ok := priv.Private(); !ok || owner != "com.apple.streaming.transportStreamTimestamp" || len(data) != 8 {
+		t.Errorf("Private() = %v, %v, %v", owner, data, ok)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	var patCC, pmtCC, videoCC, audioCC, id3CC uint8
+	stream := testPackets(PIDPAT, &patCC, testSection(0x00, 1, []byte{0, 1, 0xf0, 0x00}), -1)
+	stream = append(stream, testPackets(0x1000, &pmtCC, testSection(0x02, 1, []byte{0xe1, 0x00, 0xf0, 0x00,
+		StreamTypeH264, 0xe1, 0x00, 0xf0, 0x00,
+		StreamTypeAAC, 0xe1, 0x01, 0xf0, 0x00,
+		StreamTypeMetadata, 0xe1, 0x02, 0xf0, 0x00}), -1)...)
+	title := ID3Frame{ID: "TXXX", Data: []byte("\x03title\x00Live")}
+	stream = append(stream, testPackets(0x102, &id3CC, testPES(0xbd, TimestampWrap-3000, testID3(title)), -1)...)
+	// the video frames are reordered and their timestamps wrap
+	for _, pts := range []int64{TimestampWrap - 3000, 3000, 0, 6000} {
+		stream = append(stream, testPackets(0x100, &videoCC, testPES(0xe0, pts, []byte{0xaa}), -1)...)
+	}
+	for pts := int64(TimestampWrap - 3000); pts < TimestampWrap+6000; pts += 1920 {
+		stream = append(stream, testPackets(0x101, &audioCC, testPES(0xc0, WrapTimestamp(pts), []byte{0xbb}), -1)...)
+	}
+
+	info, err := Probe(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("Probe() error = %v", err)
+	}
+	want := []StreamInfo{
+		{PID: 0x100, Type: StreamTypeH264, Codec: "h264", HasPTS: true, FirstPTS: TimestampWrap - 3000, LastPTS: 6000, Duration: 4 * time.Second / 30, lastStep: 3000},
+		{PID: 0x101, Type: StreamTypeAAC, Codec: "aac", HasPTS: true, FirstPTS: TimestampWrap - 3000, LastPTS: 4680, Duration: 9600 * time.Second / ClockRate, lastStep: 1920},
+		{PID: 0x102, Type: StreamTypeMetadata, Codec: "id3", HasPTS: true, FirstPTS: TimestampWrap - 3000, LastPTS: TimestampWrap - 3000},
+	}
+	if info.PMTPID != 0x1000 || info.PCRPID != 0x100 || !reflect.DeepEqual(info.Streams, want) {
+		t.Errorf("Probe() streams = %+v, want %+v", info.Streams, want)
+	}
+	if info.Duration != 4*time.Second/30 {
+		t.Errorf("Probe() duration = %v, want %v", info.Duration, 4*time.Second/30)
+	}
+	wantMetadata := []Metadata{{PID: 0x102, PTS: TimestampWrap - 3000, Frames: []ID3Frame{title}}}
+	if !reflect.DeepEqual(info.Metadata, wantMetadata) {
+		t.Errorf("Probe() metadata = %+v, want %+v", info.Metadata, wantMetadata)
+	}
+	if got := Codec(0x42); got != "0x42" {
+		t.Errorf("Codec() = %v, want 0x42", got)
+	}
+}
@@ -0,0 +1,125 @@
+package ts
+
+import "errors"
+
+// ErrBadSection is returned for malformed PAT and PMT sections
+var ErrBadSection = errors.New("ts: malformed PSI section")
+
+// Stream types of PMT entries
+const (
+	StreamTypeMPEG1Audio = 0x03
+	StreamTypeMPEG2Audio = 0x04
+	StreamTypePrivate    = 0x06
+	StreamTypeAAC        = 0x0f
+	StreamTypeMetadata   = 0x15
+	StreamTypeH264       = 0x1b
+	StreamTypeH265       = 0x24
+	StreamTypeAC3        = 0x81
+	StreamTypeEAC3       = 0x87
+)
+
+// Table ids of PSI sections
+const (
+	tableIDPAT = 0x00
+	tableIDPMT = 0x02
+)
+
+// Program is an entry of the program association table
+type Program struct {
+	Number uint16
+	PMTPID uint16
+}
+
+// PMT is the program map table of a program
+type PMT struct {
+	// PID is the PID the table was carried on
+	PID           uint16
+	ProgramNumber uint16
+	PCRPID        uint16
+	Streams       []ElementaryStream
+}
+
+// ElementaryStream is an entry of a program map table
+type ElementaryStream struct {
+	PID  uint16
+	Type uint8
+	// Descriptors are the raw descriptors of the entry
+	Descriptors []byte
+}
+
+// Stream is the elementary stream of the program on pid
+func (m *PMT) Stream(pid uint16) (ElementaryStream, bool) {
+	for _, s := range m.Streams {
+		if s.PID == pid {
+			return s, true
+		}
+	}
+	return ElementaryStream{}, false
+}
+
+// section splits a long form PSI section into its table id, table id
+// extension and the data between the header and the CRC
+func section(b []byte) (uint8, uint16, []byte, error) {
+	if len(b) < 3 {
+		return 0, 0, nil, ErrBadSection
+	}
+	length := int(b[1]&0x0f)<<8 | int(b[2])
+	if length < 9 || len(b) < 3+length {
+		return 0, 0, nil, ErrBadSection
+	}
+	return b[0], uint16(b[3])<<8 | uint16(b[4]), b[8 : 3+length-4], nil
+}
+
+// sectionLength is the length of the section at the start of b, 0 when it
+// is not known yet
+func sectionLength(b []byte) int {
+	if len(b) < 3 {
+		return 0
+	}
+	return 3 + (int(b[1]&0x0f)<<8 | int(b[2]))
+}
+
+// ParsePAT parses a program association table section
+func ParsePAT(b []byte) ([]Program, error) {
+	table, _, data, err := section(b)
+	if err != nil {
+		return nil, err
+	}
+	if table != tableIDPAT || len(data)%4 != 0 {
+		return nil, ErrBadSection
+	}
+	programs := []Program{}
+	for i := 0; i < len(data); i += 4 {
+		programs = append(programs, Program{
+			Number: uint16(data[i])<<8 | uint16(data[i+1]),
+			PMTPID: uint16(data[i+2]&0x1f)<<8 | uint16(data[i+3]),
+		})
+	}
+	return programs, nil
+}
+
+// ParsePMT parses a program map table section
+func ParsePMT(b []byte) (*PMT, error) {
+	table, number, data, err := section(b)
+	if err != nil {
+		return nil, err
+	}
+	if table != tableIDPMT || len(data) < 4 {
+		return nil, ErrBadSection
+	}
+	m := &PMT{ProgramNumber: number, PCRPID: uint16(data[0]&0x1f)<<8 | uint16(data[1])}
+	i := 4 + (int(data[2]&0x0f)<<8 | int(data[3]))
+	for i+5 <= len(data) {
+		n := int(data[i+3]&0x0f)<<8 | int(data[i+4])
+		if i+5+n > len(data) {
+			return nil, ErrBadSection
+		}
+		m.Streams = append(m.Streams, ElementaryStream{
+			Type:        data[i],
+			PID:         uint16(data[i+1]&0x1f)<<8 | uint16(data[i+2]),
+			Descriptors: data[i+5 : i+5+n],
+		})
+		i += 5 + n
+	}
+	return m, nil
+}
@@ -0,0 +1,83 @@
+package ts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Findings of Validate
+var (
+	ErrTruncated  = errors.New("ts: truncated packet")
+	ErrContinuity = errors.New("ts: continuity counter error")
+	ErrNoPackets  = errors.New("ts: no packets")
+)
+
+// PacketError is a finding of Validate in a packet
+type PacketError struct {
+	// Packet is the 0-based index of the packet in the stream
+	Packet int
+	PID    uint16
+	Err    error
+}
+
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("packet %d (PID %d): %v", e.Packet, e.PID, e.Err)
+}
+
+// Unwrap returns what is wrong with the packet
+func (e *PacketError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks a transport stream is made of whole packets starting with
+// SyncByte, that the continuity counters of every PID follow on and that it
+// carries the PMT of a program. The first finding is returned
+func Validate(r io.Reader) error {
+	d := NewDemuxer(r)
+	counters := map[uint16]uint8{}
+	// duplicates tracks the PIDs whose last packet was repeated, a packet may
+	// be sent twice in a row
+	duplicates := map[uint16]bool{}
+	n := 0
+	d.onPacket = func(p Packet) error {
+		defer func() { n++ }()
+		pid := p.PID()
+		if pid == PIDNull {
+			return nil
+		}
+		last, seen := counters[pid]
+		cc := p.ContinuityCounter()
+		switch {
+		case !seen || p.Discontinuity():
+		case !p.HasPayload():
+			if cc != last {
+				return &PacketError{Packet: n, PID: pid, Err: ErrContinuity}
+			}
+		case cc == last && !duplicates[pid]:
+			duplicates[pid] = true
+			return nil
+		case cc != (last+1)&0x0f:
+			return &PacketError{Packet: n, PID: pid, Err: ErrContinuity}
+		}
+		counters[pid], duplicates[pid] = cc, false
+		return nil
+	}
+	for {
+		_, err := d.Next()
+		switch {
+		case err == io.EOF && n == 0:
+			return ErrNoPackets
+		case err == io.EOF && d.pmt == nil:
+			return ErrNoProgram
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			return &PacketError{Packet: n, Err: ErrTruncated}
+		case err == ErrSync:
+			return &PacketError{Packet: n, Err: ErrSync}
+		case err != nil:
+			return err
+		}
+	}
+}
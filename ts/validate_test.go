@@ -0,0 +1,56 @@
+package ts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testStream is a program with an H.264 and an AAC stream followed by the
+// PES packets of each frame
+func testStream(frames int) []byte {
+	var patCC, pmtCC, videoCC, audioCC uint8
+	stream := testPackets(PIDPAT, &patCC, testSection(0x00, 1, []byte{0, 1, 0xf0, 0x00}), -1)
+	stream = append(stream, testPackets(0x1000, &pmtCC, testSection(0x02, 1, []byte{0xe1, 0x00, 0xf0, 0x00, StreamTypeH264, 0xe1, 0x00, 0xf0, 0x00, StreamTypeAAC, 0xe1, 0x01, 0xf0, 0x00}), -1)...)
+	for i := 0; i < frames; i++ {
+		pts := int64(90000 + 3000*i)
+		stream = append(stream, testPackets(0x100, &videoCC, testPES(0xe0, pts, bytes.Repeat([]byte{0xaa}, 300)), pts)...)
+		stream = append(stream, testPackets(0x101, &audioCC, testPES(0xc0, pts, bytes.Repeat([]byte{0xbb}, 20)), -1)...)
+	}
+	return stream
+}
+
+func TestValidate(t *testing.T) {
+	valid := testStream(3)
+	skipped := append(append([]byte{}, valid[:3*PacketSize]...), valid[4*PacketSize:]...)
+	duplicated := append(append([]byte{}, valid[:5*PacketSize]...), valid[4*PacketSize:]...)
+	var patCC uint8
+	tests := []struct {
+		name   string
+		stream []byte
+		want   error
+		packet int
+	}{
+		{"valid", valid, nil, 0},
+		{"duplicated packet", duplicated, nil, 0},
+		{"empty", []byte{}, ErrNoPackets, 0},
+		{"html", []byte(strings.Repeat("<html><body>Not Found</body></html>", 10)), ErrSync, 0},
+		{"truncated", valid[:len(valid)-100], ErrTruncated, len(valid)/PacketSize - 1},
+		{"skipped packet", skipped, ErrContinuity, 4},
+		{"no program", testPackets(PIDPAT, &patCC, testSection(0x00, 1, []byte{0, 1, 0xf0, 0x00}), -1), ErrNoProgram, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(bytes.NewReader(tt.stream))
+			if e, ok := err.(*PacketError); ok {
+				if e.Err != tt.want || e.Packet != tt.packet {
+					t.Errorf("Validate() error = %v, want %v in packet %v", err, tt.want, tt.packet)
+				}
+				return
+			}
+			if err != tt.want {
+				t.Errorf("Validate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}